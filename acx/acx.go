@@ -0,0 +1,347 @@
+// Package acx reads and writes .acx files, the simple tables of AliceSoft System 4 games.
+//
+// An .acx file is an "ACX" header followed by a zlib stream of a column type list and rows of ints and ShiftJIS strings.
+package acx
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"golang.org/x/text/encoding/japanese"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+	"github.com/mixcode/alicesoft-afa/internal/zlibutil"
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid acx format")
+)
+
+func init() {
+	aliceafa.RegisterDecoder(&aliceafa.Decoder{
+		Type:   aliceafa.ContentACX,
+		Export: aliceafa.ExportCSV,
+		Decode: func(rs io.ReadSeeker, ctx *aliceafa.DecodeContext) (v interface{}, err error) {
+			return Read(rs)
+		},
+	})
+}
+
+// Type of a column.
+type ColumnType int32
+
+const (
+	ColumnInt    ColumnType = 0
+	ColumnString ColumnType = 2
+)
+
+// name of the column type, used in CSV and JSON
+func (t ColumnType) MarshalText() ([]byte, error) {
+	switch t {
+	case ColumnInt:
+		return []byte("int"), nil
+	case ColumnString:
+		return []byte("string"), nil
+	}
+	return nil, fmt.Errorf("unknown column type %d", int(t))
+}
+
+func (t *ColumnType) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "int":
+		*t = ColumnInt
+	case "string":
+		*t = ColumnString
+	default:
+		return fmt.Errorf("unknown column type %q", b)
+	}
+	return nil
+}
+
+// A decoded .acx file.
+// Each cell of Rows is an int32 for ColumnInt, or a string for ColumnString.
+type ACX struct {
+	Version int
+	Columns []ColumnType
+	Rows    [][]interface{}
+}
+
+// .acx file header
+type acxHeader struct {
+	Signature        [4]byte // "ACX\0"
+	Version          uint32  // 0
+	CompressedSize   uint32
+	DecompressedSize uint32
+}
+
+// Read an .acx file.
+func Read(r io.Reader) (acx *ACX, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return Decode(data)
+}
+
+// Decode an .acx file.
+func Decode(data []byte) (acx *ACX, err error) {
+	var hdr acxHeader
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr)
+	if err != nil || string(hdr.Signature[:]) != "ACX\x00" {
+		return nil, ErrInvalidFormat
+	}
+	body := data[binary.Size(hdr):]
+	if int64(hdr.CompressedSize) > int64(len(body)) {
+		return nil, ErrInvalidFormat
+	}
+	plain, err := zlibutil.Inflate(body[:hdr.CompressedSize], hdr.DecompressedSize)
+	if err != nil {
+		return
+	}
+
+	acx = &ACX{Version: int(hdr.Version)}
+	r := &reader{b: plain}
+	acx.Columns = make([]ColumnType, r.count(4))
+	for i := range acx.Columns {
+		acx.Columns[i] = ColumnType(r.int32())
+		if r.err == nil && acx.Columns[i] != ColumnInt && acx.Columns[i] != ColumnString {
+			return nil, fmt.Errorf("unknown column type %d", acx.Columns[i])
+		}
+	}
+	minRowSize := len(acx.Columns)
+	if minRowSize == 0 {
+		minRowSize = 1
+	}
+	acx.Rows = make([][]interface{}, r.count(minRowSize))
+	for i := range acx.Rows {
+		row := make([]interface{}, len(acx.Columns))
+		for j, t := range acx.Columns {
+			if t == ColumnInt {
+				row[j] = r.int32()
+			} else {
+				row[j] = r.str()
+			}
+		}
+		acx.Rows[i] = row
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return
+}
+
+// little-endian reader of decompressed data
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		r.pos = len(r.b)
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) int32() int32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.LittleEndian.Uint32(b))
+}
+
+func (r *reader) count(minSize int) int {
+	n := int(r.int32())
+	if r.err == nil && (n < 0 || n*minSize > len(r.b)-r.pos) {
+		r.err = fmt.Errorf("invalid count %d", n)
+		return 0
+	}
+	return n
+}
+
+// read a zero-terminated ShiftJIS string
+func (r *reader) str() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.b[r.pos:], 0)
+	if i < 0 {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	b := r.bytes(i + 1)[:i]
+	s, err := japanese.ShiftJIS.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
+
+// check the cell types of the rows
+func (p *ACX) validate() error {
+	for i, row := range p.Rows {
+		if len(row) != len(p.Columns) {
+			return fmt.Errorf("row %d has %d cells but the table has %d columns", i, len(row), len(p.Columns))
+		}
+		for j, c := range row {
+			ok := false
+			switch p.Columns[j] {
+			case ColumnInt:
+				_, ok = c.(int32)
+			case ColumnString:
+				_, ok = c.(string)
+			}
+			if !ok {
+				return fmt.Errorf("cell (%d, %d) is %T, not a value of the column type", i, j, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Encode the .acx file.
+func (p *ACX) Encode() (data []byte, err error) {
+	err = p.validate()
+	if err != nil {
+		return
+	}
+	var plain bytes.Buffer
+	putInt := func(v int32) {
+		binary.Write(&plain, binary.LittleEndian, v)
+	}
+	putInt(int32(len(p.Columns)))
+	for _, t := range p.Columns {
+		putInt(int32(t))
+	}
+	putInt(int32(len(p.Rows)))
+	enc := japanese.ShiftJIS.NewEncoder()
+	for _, row := range p.Rows {
+		for _, c := range row {
+			switch v := c.(type) {
+			case int32:
+				putInt(v)
+			case string:
+				var b []byte
+				b, err = enc.Bytes([]byte(v))
+				if err != nil || bytes.IndexByte(b, 0) >= 0 {
+					return nil, fmt.Errorf("string %q is not representable in ShiftJIS", v)
+				}
+				plain.Write(b)
+				plain.WriteByte(0)
+			}
+		}
+	}
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	_, err = zw.Write(plain.Bytes())
+	if err != nil {
+		return
+	}
+	err = zw.Close()
+	if err != nil {
+		return
+	}
+	hdr := acxHeader{
+		Signature:        [4]byte{'A', 'C', 'X', 0},
+		Version:          uint32(p.Version),
+		CompressedSize:   uint32(z.Len()),
+		DecompressedSize: uint32(plain.Len()),
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	buf.Write(z.Bytes())
+	return buf.Bytes(), nil
+}
+
+// Write the .acx file.
+func (p *ACX) Write(w io.Writer) (err error) {
+	data, err := p.Encode()
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
+
+// Write the table as UTF-8 CSV.
+// The first record is the column types, "int" or "string", and the following records are the rows.
+func (p *ACX) WriteCSV(w io.Writer) (err error) {
+	err = p.validate()
+	if err != nil {
+		return
+	}
+	cw := csv.NewWriter(w)
+	rec := make([]string, len(p.Columns))
+	for i, t := range p.Columns {
+		b, _ := t.MarshalText()
+		rec[i] = string(b)
+	}
+	cw.Write(rec)
+	for _, row := range p.Rows {
+		for i, c := range row {
+			switch v := c.(type) {
+			case int32:
+				rec[i] = strconv.Itoa(int(v))
+			case string:
+				rec[i] = v
+			}
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Read a table from CSV written by WriteCSV.
+// Ints are decimal; a leading zero does not make an octal number.
+// version is the version number written in the .acx header.
+func ReadCSV(r io.Reader, version int) (acx *ACX, err error) {
+	cr := csv.NewReader(r)
+	records, err := cr.ReadAll()
+	if err != nil {
+		return
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no column types in CSV")
+	}
+	acx = &ACX{Version: version, Columns: make([]ColumnType, len(records[0]))}
+	for i, s := range records[0] {
+		err = acx.Columns[i].UnmarshalText([]byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %w", i, err)
+		}
+	}
+	acx.Rows = make([][]interface{}, len(records)-1)
+	for i, rec := range records[1:] {
+		row := make([]interface{}, len(rec))
+		for j, s := range rec {
+			if acx.Columns[j] == ColumnString {
+				row[j] = s
+				continue
+			}
+			n, e := strconv.ParseInt(s, 10, 32)
+			if e != nil {
+				return nil, fmt.Errorf("row %d column %d: invalid int %q", i+1, j, s)
+			}
+			row[j] = int32(n)
+		}
+		acx.Rows[i] = row
+	}
+	return
+}
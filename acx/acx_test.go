@@ -0,0 +1,102 @@
+package acx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+)
+
+func TestACX(t *testing.T) {
+	src := &ACX{
+		Columns: []ColumnType{ColumnInt, ColumnString, ColumnInt},
+		Rows: [][]interface{}{
+			{int32(1), "ランス", int32(-1)},
+			{int32(2), "a,\"b\"\nc", int32(0x7fffffff)},
+			{int32(3), "", int32(0)},
+		},
+	}
+	data, err := src.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceafa.DetectContentType(data) != aliceafa.ContentACX {
+		t.Errorf("content type not detected")
+	}
+	acx, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(acx, src) {
+		t.Errorf("decoded acx differs: %+v", acx)
+	}
+	broken := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(broken[12:], 0xffffffff) // DecompressedSize
+	if _, err := Decode(broken); err == nil {
+		t.Errorf("broken decompressed size must fail")
+	}
+
+	// CSV
+	var buf bytes.Buffer
+	err = acx.WriteCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "int,string,int\n1,ランス,-1\n") {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+	acx, err = ReadCSV(&buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(acx, src) {
+		t.Errorf("acx from CSV differs: %+v", acx)
+	}
+
+	// through the decoder registry
+	v, export, err := aliceafa.DecodeContent(aliceafa.ContentACX, bytes.NewReader(data), nil)
+	if err != nil || export != aliceafa.ExportCSV || !reflect.DeepEqual(v, src) {
+		t.Errorf("registry decode failed: %v", err)
+	}
+	var exported bytes.Buffer
+	err = aliceafa.Export(&exported, export, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	src.WriteCSV(&buf)
+	if exported.String() != buf.String() {
+		t.Errorf("unexpected exported CSV:\n%s", exported.String())
+	}
+
+	// ints are decimal
+	acx, err = ReadCSV(strings.NewReader("int\n010\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acx.Rows[0][0] != int32(10) {
+		t.Errorf("010 is read as %v", acx.Rows[0][0])
+	}
+
+	// errors
+	for _, s := range []string{
+		"int,float\n",
+		"int\nabc\n",
+		"int\n0x10\n",
+		"int,string\n1\n",
+	} {
+		if _, err := ReadCSV(strings.NewReader(s), 0); err == nil {
+			t.Errorf("%q: must fail", s)
+		}
+	}
+	src.Rows[0][0] = "not an int"
+	if _, err := src.Encode(); err == nil {
+		t.Errorf("cell type mismatch must fail")
+	}
+	if _, err := Decode(data[:10]); err == nil {
+		t.Errorf("truncated data must fail")
+	}
+}
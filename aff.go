@@ -0,0 +1,215 @@
+package aliceafa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	ErrNoAFFKey = errors.New("AFF key is not set")
+)
+
+// AFF header.
+// AFF wraps a resource with the header, and the prefix of the content is XORed with a key.
+//
+// Handling AFF is opt-in: the key is not included in this package, and AFF is unwrapped only after the key is set with SetAFFKey().
+// Until then, AFF content is detected as ContentAFF and kept as-is, and decoding it fails with ErrNoAFFKey.
+type affHeader struct {
+	Signature [4]byte // "AFF\0"
+	Version   uint32
+	Size      uint32 // size of the file including the header
+	Unknown   uint32
+}
+
+var affHeaderSize = int64(binary.Size(affHeader{}))
+
+var (
+	affKeyMu sync.RWMutex
+	affKey   []byte
+)
+
+// Set the XOR key of AFF. The first len(key) bytes of the content are XORed with the key.
+// A nil key clears the key.
+func SetAFFKey(key []byte) {
+	affKeyMu.Lock()
+	defer affKeyMu.Unlock()
+	affKey = append([]byte(nil), key...)
+	if len(key) == 0 {
+		affKey = nil
+	}
+}
+
+func getAFFKey() []byte {
+	affKeyMu.RLock()
+	defer affKeyMu.RUnlock()
+	return affKey
+}
+
+// XOR the bytes at the offset of the content with the key
+func affXOR(b []byte, off int64, key []byte) {
+	for i := range b {
+		if off+int64(i) >= int64(len(key)) {
+			break
+		}
+		b[i] ^= key[off+int64(i)]
+	}
+}
+
+// Reader of the content of an AFF wrapper.
+type AFFReader struct {
+	rs   io.ReadSeeker
+	base int64 // offset of the content in rs
+	size int64 // size of the content
+	pos  int64
+	key  []byte
+}
+
+// Make a reader of the content of AFF data at the current position of rs.
+func NewAFFReader(rs io.ReadSeeker) (r *AFFReader, err error) {
+	key := getAFFKey()
+	if key == nil {
+		return nil, ErrNoAFFKey
+	}
+	var hdr affHeader
+	err = binary.Read(rs, binary.LittleEndian, &hdr)
+	if err != nil {
+		return
+	}
+	if string(hdr.Signature[:]) != "AFF\x00" || int64(hdr.Size) < affHeaderSize {
+		return nil, fmt.Errorf("invalid AFF header")
+	}
+	base, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	return &AFFReader{rs: rs, base: base, size: int64(hdr.Size) - affHeaderSize, key: key}, nil
+}
+
+// Size of the content.
+func (p *AFFReader) Size() int64 {
+	return p.size
+}
+
+func (p *AFFReader) Read(b []byte) (n int, err error) {
+	if p.pos >= p.size {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.size-p.pos {
+		b = b[:p.size-p.pos]
+	}
+	_, err = p.rs.Seek(p.base+p.pos, io.SeekStart)
+	if err != nil {
+		return
+	}
+	n, err = p.rs.Read(b)
+	affXOR(b[:n], p.pos, p.key)
+	p.pos += int64(n)
+	return
+}
+
+// ReadAt reads the content at the offset. It moves the position of the underlying reader.
+func (p *AFFReader) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= p.size {
+		return 0, io.EOF
+	}
+	short := int64(len(b)) > p.size-off
+	if short {
+		b = b[:p.size-off]
+	}
+	_, err = p.rs.Seek(p.base+off, io.SeekStart)
+	if err != nil {
+		return
+	}
+	n, err = io.ReadFull(p.rs, b)
+	affXOR(b[:n], off, p.key)
+	if err == nil && short {
+		err = io.EOF
+	}
+	return
+}
+
+func (p *AFFReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += p.pos
+	case io.SeekEnd:
+		offset += p.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	p.pos = offset
+	return offset, nil
+}
+
+// Unwrap AFF data at the current position of rs.
+// If the data is not AFF, rs is returned as-is.
+func UnwrapAFF(rs io.ReadSeeker) (r io.ReadSeeker, err error) {
+	var sig [4]byte
+	n, err := io.ReadFull(rs, sig[:])
+	if _, e := rs.Seek(int64(-n), io.SeekCurrent); e != nil {
+		return nil, e
+	}
+	if err != nil || string(sig[:]) != "AFF\x00" {
+		// too short or not AFF
+		return rs, nil
+	}
+	return NewAFFReader(rs)
+}
+
+// Wrap the data in AFF.
+func WrapAFF(w io.Writer, data []byte) (err error) {
+	key := getAFFKey()
+	if key == nil {
+		return ErrNoAFFKey
+	}
+	hdr := affHeader{
+		Signature: [4]byte{'A', 'F', 'F', 0},
+		Version:   1,
+		Size:      uint32(affHeaderSize) + uint32(len(data)),
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	prefix := len(key)
+	if prefix > len(data) {
+		prefix = len(data)
+	}
+	buf.Write(data[:prefix])
+	affXOR(buf.Bytes()[affHeaderSize:], 0, key)
+	buf.Write(data[prefix:])
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// Detect the content type of the data at the current position of rs, unwrapping AFF if the key is set.
+// The returned content is positioned at the beginning of the content, and is rs itself if the data is not wrapped.
+func SniffContent(rs io.ReadSeeker) (t ContentType, content io.ReadSeeker, err error) {
+	content, err = UnwrapAFF(rs)
+	if errors.Is(err, ErrNoAFFKey) {
+		// keep AFF as is
+		content, err = rs, nil
+	}
+	if err != nil {
+		return
+	}
+	header := make([]byte, ContentSniffLen)
+	n, err := io.ReadFull(content, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	_, err = content.Seek(int64(-n), io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	return DetectContentType(header[:n]), content, nil
+}
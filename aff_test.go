@@ -0,0 +1,112 @@
+package aliceafa
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+)
+
+func TestAFF(t *testing.T) {
+	defer SetAFFKey(nil)
+
+	img := testPattern(image.Rect(0, 0, 32, 24), 0)
+	var qnt bytes.Buffer
+	err := EncodeQNT(&qnt, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no key
+	SetAFFKey(nil)
+	if err := WrapAFF(io.Discard, qnt.Bytes()); !errors.Is(err, ErrNoAFFKey) {
+		t.Errorf("wrapping without the key must fail: %v", err)
+	}
+
+	SetAFFKey([]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0})
+	var aff bytes.Buffer
+	err = WrapAFF(&aff, qnt.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := aff.Bytes()
+	if DetectContentType(wrapped) != ContentAFF {
+		t.Errorf("AFF not detected")
+	}
+	if bytes.Contains(wrapped[:affHeaderSize+8], qnt.Bytes()[:4]) {
+		t.Errorf("content prefix is not encrypted")
+	}
+
+	// sniff and unwrap
+	ct, content, err := SniffContent(bytes.NewReader(wrapped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != ContentQNT {
+		t.Errorf("unexpected content type %v", ct)
+	}
+	r, ok := content.(*AFFReader)
+	if !ok || r.Size() != int64(qnt.Len()) {
+		t.Fatalf("unexpected content reader %T", content)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, qnt.Bytes()) {
+		t.Errorf("unwrapped content mismatch")
+	}
+	buf := make([]byte, 4)
+	if _, err := r.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || !bytes.Equal(buf, data[len(data)-4:]) {
+		t.Errorf("read after seek mismatch: %v", err)
+	}
+
+	// decoders unwrap AFF
+	dec, err := LoadQNT(bytes.NewReader(wrapped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(dec, img) {
+		t.Errorf("image mismatch")
+	}
+	v, export, err := DecodeContent(ContentAFF, bytes.NewReader(wrapped), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.(image.Image); !ok || export != ExportPNG {
+		t.Errorf("unexpected decoded value %T, %v", v, export)
+	}
+
+	// AFF-wrapped base image of DCF
+	variant := testPattern(image.Rect(0, 0, 32, 24), 0)
+	variant.SetNRGBA(3, 3, color.NRGBA{0xff, 0, 0, 0xff}) // other blocks are taken from the base
+	dcf, err := DecodeDCF(bytes.NewReader(encodeTestDCF(t, img, variant, "base.qnt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	composed, err := ComposeDCF(dcf, mapResolver{"base": wrapped})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(composed, variant) {
+		t.Errorf("composed image mismatch")
+	}
+
+	// without the key, AFF is kept as-is
+	SetAFFKey(nil)
+	ct, content, err = SniffContent(bytes.NewReader(wrapped))
+	if err != nil || ct != ContentAFF {
+		t.Errorf("unexpected sniff result %v, %v", ct, err)
+	}
+	if _, ok := content.(*AFFReader); ok {
+		t.Errorf("AFF must not be unwrapped without the key")
+	}
+	if _, _, err := DecodeContent(ContentAFF, bytes.NewReader(wrapped), nil); !errors.Is(err, ErrNoAFFKey) {
+		t.Errorf("decoding without the key must fail: %v", err)
+	}
+}
@@ -0,0 +1,581 @@
+// Package ain reads AIN files, the compiled bytecode of AliceSoft System 4 games.
+//
+// An AIN file is a sequence of tagged sections: "VERS", "CODE", "FUNC", "GLOB", "STRT", "MSG0", "STR0" and more.
+// The sections are stored as-is, XORed with a pseudo random key stream, or zlib-compressed after an "AI2" header.
+package ain
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+var (
+	sjisDecoder = japanese.ShiftJIS.NewDecoder()
+)
+
+// Data type of variables.
+type DataType int
+
+// Commonly used data types. The list is not complete.
+const (
+	TypeVoid           DataType = 0
+	TypeInt            DataType = 10
+	TypeFloat          DataType = 11
+	TypeString         DataType = 12
+	TypeStruct         DataType = 13
+	TypeArrayInt       DataType = 14
+	TypeArrayFloat     DataType = 15
+	TypeArrayString    DataType = 16
+	TypeArrayStruct    DataType = 17
+	TypeRefInt         DataType = 18
+	TypeRefFloat       DataType = 19
+	TypeRefString      DataType = 20
+	TypeRefStruct      DataType = 21
+	TypeRefArrayInt    DataType = 22
+	TypeRefArrayFloat  DataType = 23
+	TypeRefArrayString DataType = 24
+	TypeRefArrayStruct DataType = 25
+	TypeFuncType       DataType = 27
+	TypeBool           DataType = 47
+	TypeLongInt        DataType = 55
+	TypeDelegate       DataType = 63
+	TypeArray          DataType = 79
+	TypeRefArray       DataType = 80
+)
+
+// Type of a variable or a return value.
+type Type struct {
+	Data    DataType
+	Struct  int   // struct index, or -1
+	Rank    int   // array rank. in v11+, 1 if Subtype exists
+	Subtype *Type `json:",omitempty"` // element type of containers in v11+
+}
+
+// A variable: a local variable, an argument, or a struct member.
+type Variable struct {
+	Name       string
+	Name2      string `json:",omitempty"` // v12+
+	Type       Type
+	HasInit    bool   `json:",omitempty"` // v8+
+	InitInt    int32  `json:",omitempty"`
+	InitString string `json:",omitempty"`
+}
+
+// A function.
+type Function struct {
+	Address  int // offset in the CODE section
+	Name     string
+	IsLabel  int `json:",omitempty"` // v2~v6
+	Return   Type
+	NumArgs  int // the first NumArgs variables are the arguments
+	IsLambda int `json:",omitempty"` // v11+
+	CRC      uint32
+	Vars     []Variable
+}
+
+// A global variable.
+type Global struct {
+	Name       string
+	Name2      string `json:",omitempty"` // v12+
+	Type       Type
+	GroupIndex int // v5+
+}
+
+// Initial value of a global variable.
+type GlobalInit struct {
+	Global   int
+	DataType DataType
+	Int      int32  `json:",omitempty"`
+	String   string `json:",omitempty"`
+}
+
+// An interface implemented by a struct. v11+
+type Interface struct {
+	Struct       int
+	VTableOffset int
+}
+
+// A struct.
+type Struct struct {
+	Name        string
+	Interfaces  []Interface `json:",omitempty"` // v11+
+	Constructor int         // function index, or -1
+	Destructor  int         // function index, or -1
+	Members     []Variable
+	VMethods    []int `json:",omitempty"` // v14+
+}
+
+// A function of an HLL library.
+type LibraryFunction struct {
+	Name   string
+	Return Type
+	Args   []Variable
+}
+
+// An HLL library, a set of native functions.
+type Library struct {
+	Name      string
+	Functions []LibraryFunction
+}
+
+// A case of a switch statement.
+type SwitchCase struct {
+	Value   int32
+	Address int
+}
+
+// A switch statement.
+type Switch struct {
+	CaseType       int // 2 for int, 4 for string
+	DefaultAddress int
+	Cases          []SwitchCase
+}
+
+// A scenario label.
+type Label struct {
+	Name    string
+	Address int
+}
+
+// A function type, or a delegate type.
+type FuncType struct {
+	Name    string
+	Return  Type
+	NumArgs int
+	Vars    []Variable
+}
+
+// A section of an AIN file.
+type Section struct {
+	Tag    string
+	Offset int    // offset in the plain data, of the tag
+	Data   []byte // section body, excluding the tag
+}
+
+// A parsed AIN file.
+type AIN struct {
+	Container Container
+	Sections  []Section // all sections in the file order
+
+	Version      int
+	Keycode      int
+	Code         []byte
+	Functions    []Function
+	Globals      []Global
+	GlobalInits  []GlobalInit
+	Structs      []Struct
+	Messages     []string // MSG0 or MSG1
+	MSG1Unknown  int
+	Main         int // index of the main function
+	MessageFunc  int // index of the message function
+	Libraries    []Library
+	Switches     []Switch
+	GameVersion  int
+	Labels       []Label
+	Strings      []string
+	Filenames    []string
+	OJMP         int
+	FuncTypeSize int
+	FuncTypes    []FuncType
+	DelegateSize int
+	Delegates    []FuncType
+	GlobalGroups []string
+	Enums        []string
+}
+
+// Find a section by its tag. Returns nil if not exists.
+func (p *AIN) Section(tag string) *Section {
+	for i := range p.Sections {
+		if p.Sections[i].Tag == tag {
+			return &p.Sections[i]
+		}
+	}
+	return nil
+}
+
+// Read an AIN file.
+func Read(r io.Reader) (ain *AIN, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return Decode(data)
+}
+
+// Decode an AIN file, in any of the containers.
+func Decode(data []byte) (ain *AIN, err error) {
+	plain, c, err := Unwrap(data)
+	if err != nil {
+		return
+	}
+	ain, err = DecodePlain(plain)
+	if err != nil {
+		return
+	}
+	ain.Container = c
+	return
+}
+
+// Decode plain AIN sections.
+func DecodePlain(plain []byte) (ain *AIN, err error) {
+	ain = &AIN{Main: -1, MessageFunc: -1}
+	r := &reader{b: plain}
+	for r.pos < len(plain) {
+		start := r.pos
+		tag := string(r.bytes(4))
+		err = ain.readSection(r, tag)
+		if err == nil {
+			err = r.err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section %q at 0x%x: %w", tag, start, err)
+		}
+		ain.Sections = append(ain.Sections, Section{Tag: tag, Offset: start, Data: plain[start+4 : r.pos]})
+	}
+	if len(ain.Sections) == 0 || ain.Sections[0].Tag != "VERS" {
+		return nil, ErrInvalidFormat
+	}
+	return
+}
+
+// read the body of a section
+func (p *AIN) readSection(r *reader, tag string) (err error) {
+	switch tag {
+	case "VERS":
+		p.Version = r.int()
+	case "KEYC":
+		p.Keycode = r.int()
+	case "CODE":
+		p.Code = r.bytes(r.count(1))
+	case "FUNC":
+		p.Functions = make([]Function, r.count(4))
+		for i := range p.Functions {
+			p.Functions[i] = p.readFunction(r)
+		}
+	case "GLOB":
+		p.Globals = make([]Global, r.count(4))
+		for i := range p.Globals {
+			g := &p.Globals[i]
+			g.Name = r.str()
+			if p.Version >= 12 {
+				g.Name2 = r.str()
+			}
+			g.Type = p.readType(r)
+			if p.Version >= 5 {
+				g.GroupIndex = r.int()
+			}
+		}
+	case "GSET":
+		p.GlobalInits = make([]GlobalInit, r.count(8))
+		for i := range p.GlobalInits {
+			g := &p.GlobalInits[i]
+			g.Global = r.int()
+			g.DataType = DataType(r.int())
+			if g.DataType == TypeString {
+				g.String = r.str()
+			} else {
+				g.Int = r.int32()
+			}
+		}
+	case "STRT":
+		p.Structs = make([]Struct, r.count(4))
+		for i := range p.Structs {
+			p.Structs[i] = p.readStruct(r)
+		}
+	case "MSG0":
+		p.Messages = r.strs()
+	case "MSG1":
+		n := r.count(4)
+		p.MSG1Unknown = r.int()
+		p.Messages = make([]string, n)
+		for i := range p.Messages {
+			p.Messages[i] = decodeString(decodeMSG1(r.bytes(r.count(1))))
+		}
+	case "MAIN":
+		p.Main = r.int()
+	case "MSGF":
+		p.MessageFunc = r.int()
+	case "HLL0":
+		p.Libraries = make([]Library, r.count(8))
+		for i := range p.Libraries {
+			p.Libraries[i] = p.readLibrary(r)
+		}
+	case "SWI0":
+		p.Switches = make([]Switch, r.count(12))
+		for i := range p.Switches {
+			s := &p.Switches[i]
+			s.CaseType = r.int()
+			s.DefaultAddress = r.int()
+			s.Cases = make([]SwitchCase, r.count(8))
+			for j := range s.Cases {
+				s.Cases[j].Value = r.int32()
+				s.Cases[j].Address = r.int()
+			}
+		}
+	case "GVER":
+		p.GameVersion = r.int()
+	case "SLBL":
+		p.Labels = make([]Label, r.count(5))
+		for i := range p.Labels {
+			p.Labels[i].Name = r.str()
+			p.Labels[i].Address = r.int()
+		}
+	case "STR0":
+		p.Strings = r.strs()
+	case "FNAM":
+		p.Filenames = r.strs()
+	case "OJMP":
+		p.OJMP = r.int()
+	case "FNCT":
+		p.FuncTypeSize = r.int()
+		p.FuncTypes = p.readFuncTypes(r)
+	case "DELG":
+		p.DelegateSize = r.int()
+		p.Delegates = p.readFuncTypes(r)
+	case "OBJG":
+		p.GlobalGroups = r.strs()
+	case "ENUM":
+		p.Enums = r.strs()
+	default:
+		return fmt.Errorf("unknown section")
+	}
+	return
+}
+
+// read a variable type
+func (p *AIN) readType(r *reader) (t Type) {
+	t.Data = DataType(r.int())
+	t.Struct = r.int()
+	t.Rank = r.int()
+	if p.Version >= 11 && t.Rank != 0 && r.err == nil {
+		// in v11+, rank is a flag of the subtype
+		if t.Rank != 1 {
+			r.fail(fmt.Errorf("invalid subtype flag %d", t.Rank))
+			return
+		}
+		sub := p.readType(r)
+		t.Subtype = &sub
+	}
+	return
+}
+
+// read the return type of a function
+func (p *AIN) readReturnType(r *reader) (t Type) {
+	if p.Version >= 11 {
+		return p.readType(r)
+	}
+	t.Data = DataType(r.int())
+	t.Struct = r.int()
+	return
+}
+
+// read a list of variables
+func (p *AIN) readVariables(r *reader, n int) (vars []Variable) {
+	vars = make([]Variable, n)
+	for i := range vars {
+		v := &vars[i]
+		v.Name = r.str()
+		if p.Version >= 12 {
+			v.Name2 = r.str()
+		}
+		v.Type = p.readType(r)
+		if p.Version >= 8 {
+			v.HasInit = r.int() != 0
+			if v.HasInit {
+				switch v.Type.Data {
+				case TypeString:
+					v.InitString = r.str()
+				case TypeDelegate, TypeRefArray:
+					// no value
+				default:
+					v.InitInt = r.int32()
+				}
+			}
+		}
+		if r.err != nil {
+			break
+		}
+	}
+	return
+}
+
+func (p *AIN) readFunction(r *reader) (f Function) {
+	f.Address = r.int()
+	f.Name = r.str()
+	if p.Version > 1 && p.Version < 7 {
+		f.IsLabel = r.int()
+	}
+	f.Return = p.readReturnType(r)
+	f.NumArgs = r.int()
+	nVars := r.count(13)
+	if p.Version >= 11 {
+		f.IsLambda = r.int()
+	}
+	if p.Version > 1 {
+		f.CRC = uint32(r.int32())
+	}
+	f.Vars = p.readVariables(r, nVars)
+	return
+}
+
+func (p *AIN) readStruct(r *reader) (s Struct) {
+	s.Name = r.str()
+	if p.Version >= 11 {
+		s.Interfaces = make([]Interface, r.count(8))
+		for i := range s.Interfaces {
+			s.Interfaces[i].Struct = r.int()
+			s.Interfaces[i].VTableOffset = r.int()
+		}
+	}
+	s.Constructor = r.int()
+	s.Destructor = r.int()
+	s.Members = p.readVariables(r, r.count(13))
+	if p.Version >= 14 {
+		s.VMethods = make([]int, r.count(4))
+		for i := range s.VMethods {
+			s.VMethods[i] = r.int()
+		}
+	}
+	return
+}
+
+func (p *AIN) readLibrary(r *reader) (l Library) {
+	l.Name = r.str()
+	l.Functions = make([]LibraryFunction, r.count(9))
+	for i := range l.Functions {
+		f := &l.Functions[i]
+		f.Name = r.str()
+		if p.Version >= 14 {
+			f.Return = p.readType(r)
+		} else {
+			f.Return.Data = DataType(r.int())
+		}
+		f.Args = make([]Variable, r.count(5))
+		for j := range f.Args {
+			f.Args[j].Name = r.str()
+			if p.Version >= 14 {
+				f.Args[j].Type = p.readType(r)
+			} else {
+				f.Args[j].Type.Data = DataType(r.int())
+			}
+		}
+		if r.err != nil {
+			break
+		}
+	}
+	return
+}
+
+func (p *AIN) readFuncTypes(r *reader) (types []FuncType) {
+	types = make([]FuncType, r.count(13))
+	for i := range types {
+		t := &types[i]
+		t.Name = r.str()
+		t.Return = p.readReturnType(r)
+		t.NumArgs = r.int()
+		t.Vars = p.readVariables(r, r.count(13))
+		if r.err != nil {
+			break
+		}
+	}
+	return
+}
+
+// remove the obfuscation of MSG1 strings
+func decodeMSG1(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[i] = c - byte(i) - 0x60
+	}
+	return out
+}
+
+// convert a ShiftJIS string to UTF-8
+func decodeString(b []byte) string {
+	s, err := sjisDecoder.Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
+
+// little-endian reader of section data.
+// the first error is kept in err, and the following reads return zero values.
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.pos = len(r.b)
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.b) {
+		r.fail(io.ErrUnexpectedEOF)
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) int32() int32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int32(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+}
+
+func (r *reader) int() int {
+	return int(r.int32())
+}
+
+// read an element count. minSize is the minimum size of an element, to reject broken counts.
+func (r *reader) count(minSize int) int {
+	n := r.int()
+	if r.err == nil && (n < 0 || n*minSize > len(r.b)-r.pos) {
+		r.fail(fmt.Errorf("invalid count %d", n))
+		return 0
+	}
+	return n
+}
+
+// read a zero-terminated ShiftJIS string
+func (r *reader) rawStr() []byte {
+	if r.err != nil {
+		return nil
+	}
+	for i := r.pos; i < len(r.b); i++ {
+		if r.b[i] == 0 {
+			s := r.b[r.pos:i]
+			r.pos = i + 1
+			return s
+		}
+	}
+	r.fail(io.ErrUnexpectedEOF)
+	return nil
+}
+
+func (r *reader) str() string {
+	return decodeString(r.rawStr())
+}
+
+// read a count and the strings
+func (r *reader) strs() (s []string) {
+	s = make([]string, r.count(1))
+	for i := range s {
+		s[i] = r.str()
+	}
+	return
+}
@@ -0,0 +1,205 @@
+package ain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// section builder for tests
+type testWriter struct {
+	bytes.Buffer
+}
+
+func (w *testWriter) tag(s string) *testWriter {
+	w.WriteString(s)
+	return w
+}
+
+func (w *testWriter) i32(v ...int) *testWriter {
+	for _, n := range v {
+		binary.Write(&w.Buffer, binary.LittleEndian, int32(n))
+	}
+	return w
+}
+
+func (w *testWriter) str(s ...string) *testWriter {
+	for _, t := range s {
+		w.WriteString(t)
+		w.WriteByte(0)
+	}
+	return w
+}
+
+func (w *testWriter) op(code uint16, args ...int) *testWriter {
+	binary.Write(&w.Buffer, binary.LittleEndian, code)
+	return w.i32(args...)
+}
+
+// code of the v4 AIN
+func testCode() []byte {
+	w := new(testWriter)
+	w.op(0x61, 0)          // 0x00: FUNC main
+	w.op(0x00, 1)          // 0x06: PUSH 1
+	w.op(0x2d, 30)         // 0x0c: IFZ 0x1e
+	w.op(0x41, 0)          // 0x12: S_PUSH "str"
+	w.op(0x2c, 36)         // 0x18: JUMP 0x24
+	w.op(0x59, 1)          // 0x1e: MSG "world"
+	w.op(0x5f, 0)          // 0x24: SWITCH 0
+	w.op(0x5e, 0)          // 0x2a: SH_LOCALREF x
+	w.op(0x40, 0x3fc00000) // 0x30: F_PUSH 1.5
+	w.op(0x2f)             // 0x36: RETURN
+	w.op(0x7e, 0)          // 0x38: ENDFUNC main
+	w.op(0x62, 0)          // 0x3e: _EOF "main.jaf"
+	return w.Bytes()
+}
+
+// a small v4 AIN
+func testAINv4() []byte {
+	code := testCode()
+	w := new(testWriter)
+	w.tag("VERS").i32(4)
+	w.tag("CODE").i32(len(code)).Write(code)
+	w.tag("FUNC").i32(2)
+	w.i32(6).str("main").i32(0, 10, -1, 0, 1, 0x1234).str("x").i32(10, -1, 0)
+	w.i32(54).str("f").i32(0, 0, -1, 1, 1, 0x5678).str("a").i32(12, -1, 0)
+	w.tag("GLOB").i32(1).str("g").i32(14, -1, 1)
+	w.tag("GSET").i32(2).i32(0, 12).str("init").i32(1, 10, 42)
+	w.tag("STRT").i32(1).str("S").i32(-1, -1, 1).str("m").i32(10, -1, 0)
+	w.tag("MSG0").i32(2).str("hello", "world")
+	w.tag("MAIN").i32(0)
+	w.tag("HLL0").i32(1).str("Math").i32(1).str("Sqrt").i32(11, 1).str("x").i32(11)
+	w.tag("SWI0").i32(1).i32(2, 54, 1).i32(3, 42)
+	w.tag("GVER").i32(100)
+	w.tag("STR0").i32(1).str("str")
+	w.tag("FNAM").i32(1).str("main.jaf")
+	w.tag("OJMP").i32(0)
+	return w.Bytes()
+}
+
+// a small v12 AIN with MSG1
+func testAINv12() []byte {
+	msg := encodeTestMSG1("abc")
+	w := new(testWriter)
+	w.tag("VERS").i32(12)
+	w.tag("KEYC").i32(0)
+	w.tag("CODE").i32(0)
+	w.tag("FUNC").i32(1)
+	w.i32(0).str("main").i32(10, -1, 0, 0, 1, 0, 0x1234).str("v", "").i32(79, -1, 1, 10, -1, 0).i32(0)
+	w.tag("GLOB").i32(1).str("g", "").i32(12, -1, 0, 0)
+	w.tag("STRT").i32(1).str("S").i32(1, 2, 3).i32(-1, -1, 1).str("m", "").i32(10, -1, 0).i32(1, 7)
+	w.tag("MSG1").i32(1, 0).i32(len(msg))
+	w.Write(msg)
+	w.tag("FNCT").i32(0, 1).str("ft").i32(0, -1, 0).i32(0, 0)
+	w.tag("DELG").i32(0, 0)
+	w.tag("OBJG").i32(1).str("grp")
+	w.tag("ENUM").i32(0)
+	return w.Bytes()
+}
+
+func encodeTestMSG1(s string) []byte {
+	b := []byte(s)
+	for i := range b {
+		b[i] += byte(i) + 0x60
+	}
+	return b
+}
+
+func TestDecodeAIN(t *testing.T) {
+	a, err := DecodePlain(testAINv4())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Version != 4 || len(a.Code) != 0x44 || a.Main != 0 || a.GameVersion != 100 {
+		t.Errorf("invalid header values")
+	}
+	if len(a.Functions) != 2 || a.Functions[1].Name != "f" || a.Functions[1].Address != 54 ||
+		a.Functions[1].NumArgs != 1 || a.Functions[1].CRC != 0x5678 || a.Functions[1].Vars[0].Type.Data != TypeString {
+		t.Errorf("invalid functions: %+v", a.Functions)
+	}
+	if len(a.Globals) != 1 || a.Globals[0].Type.Rank != 1 || a.Globals[0].Type.Data != TypeArrayInt {
+		t.Errorf("invalid globals: %+v", a.Globals)
+	}
+	if !reflect.DeepEqual(a.GlobalInits, []GlobalInit{{0, TypeString, 0, "init"}, {1, TypeInt, 42, ""}}) {
+		t.Errorf("invalid global inits: %+v", a.GlobalInits)
+	}
+	if len(a.Structs) != 1 || a.Structs[0].Name != "S" || len(a.Structs[0].Members) != 1 {
+		t.Errorf("invalid structs: %+v", a.Structs)
+	}
+	if !reflect.DeepEqual(a.Messages, []string{"hello", "world"}) || !reflect.DeepEqual(a.Strings, []string{"str"}) {
+		t.Errorf("invalid strings")
+	}
+	if len(a.Libraries) != 1 || a.Libraries[0].Functions[0].Name != "Sqrt" || a.Libraries[0].Functions[0].Args[0].Type.Data != TypeFloat {
+		t.Errorf("invalid libraries: %+v", a.Libraries)
+	}
+	if len(a.Switches) != 1 || !reflect.DeepEqual(a.Switches[0].Cases, []SwitchCase{{3, 42}}) {
+		t.Errorf("invalid switches: %+v", a.Switches)
+	}
+	if len(a.Sections) != 14 || a.Section("MSG0") == nil || a.Section("MSG1") != nil {
+		t.Errorf("invalid sections")
+	}
+
+	a, err = DecodePlain(testAINv12())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := a.Functions[0].Vars[0]
+	if v.Type.Data != TypeArray || v.Type.Subtype == nil || v.Type.Subtype.Data != TypeInt || v.HasInit {
+		t.Errorf("invalid v12 variable: %+v", v)
+	}
+	s := a.Structs[0]
+	if !reflect.DeepEqual(s.Interfaces, []Interface{{2, 3}}) || s.Members[0].Name != "m" {
+		t.Errorf("invalid v12 struct: %+v", s)
+	}
+	if !reflect.DeepEqual(a.Messages, []string{"abc"}) || a.FuncTypes[0].Name != "ft" || a.GlobalGroups[0] != "grp" {
+		t.Errorf("invalid v12 values")
+	}
+
+	// broken data
+	plain := testAINv4()
+	_, err = DecodePlain(plain[:len(plain)-2])
+	if err == nil {
+		t.Errorf("truncated data must fail")
+	}
+}
+
+func TestContainer(t *testing.T) {
+	plain := testAINv4()
+
+	// the magic of encrypted files
+	enc, err := Wrap(plain, ContainerEncrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(enc, []byte{0xd3, 0xfa, 0x08, 0xe1}) {
+		t.Errorf("unexpected encrypted header % x", enc[:4])
+	}
+
+	for _, c := range []Container{ContainerPlain, ContainerEncrypted, ContainerCompressed} {
+		data, err := Wrap(plain, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := Decode(data)
+		if err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+		if a.Container != c || a.Version != 4 {
+			t.Errorf("%v: container mismatch", c)
+		}
+	}
+
+	_, _, err = Unwrap([]byte("garbage data"))
+	if err != ErrInvalidFormat {
+		t.Errorf("expected ErrInvalidFormat, got %v", err)
+	}
+	z, err := Wrap(plain, ContainerCompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(z[8:], 0xffffffff) // DecompressedSize
+	if _, _, err = Unwrap(z); err == nil {
+		t.Errorf("broken decompressed size must fail")
+	}
+}
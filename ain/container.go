@@ -0,0 +1,162 @@
+package ain
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+
+	"github.com/mixcode/alicesoft-afa/internal/zlibutil"
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid AIN format")
+)
+
+// Outer form of an AIN file.
+type Container int
+
+const (
+	ContainerPlain      Container = iota // plain sections starting with "VERS"
+	ContainerEncrypted                   // sections XORed with the MT19937 key stream
+	ContainerCompressed                  // "AI2" header with zlib-compressed sections
+)
+
+func (c Container) String() string {
+	switch c {
+	case ContainerPlain:
+		return "plain"
+	case ContainerEncrypted:
+		return "encrypted"
+	case ContainerCompressed:
+		return "compressed"
+	}
+	return "unknown"
+}
+
+// seed of the key stream of encrypted AIN files
+const cryptSeed = 0x5D3E3
+
+// XOR the data with the key stream of encrypted AIN files.
+// Encryption and decryption are the same operation.
+func crypt(data []byte) {
+	mt := newMT19937(cryptSeed)
+	for i := range data {
+		data[i] ^= byte(mt.next())
+	}
+}
+
+// AI2 file header
+type ai2Header struct {
+	Signature        [4]byte // "AI2\0"
+	Unknown          uint32  // always 0
+	DecompressedSize uint32
+	CompressedSize   uint32
+}
+
+// Unwrap the container of an AIN file and returns the plain sections.
+// The data is not modified.
+func Unwrap(data []byte) (plain []byte, c Container, err error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("VERS")):
+		return data, ContainerPlain, nil
+
+	case bytes.HasPrefix(data, []byte("AI2\x00")):
+		var hdr ai2Header
+		err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr)
+		if err != nil {
+			return nil, 0, ErrInvalidFormat
+		}
+		body := data[binary.Size(hdr):]
+		if int(hdr.CompressedSize) < len(body) {
+			body = body[:hdr.CompressedSize]
+		}
+		plain, err = zlibutil.Inflate(body, hdr.DecompressedSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		return plain, ContainerCompressed, nil
+	}
+
+	// try decryption
+	plain = make([]byte, len(data))
+	copy(plain, data)
+	crypt(plain)
+	if !bytes.HasPrefix(plain, []byte("VERS")) {
+		return nil, 0, ErrInvalidFormat
+	}
+	return plain, ContainerEncrypted, nil
+}
+
+// Wrap plain AIN sections into a container.
+func Wrap(plain []byte, c Container) (data []byte, err error) {
+	switch c {
+	case ContainerPlain:
+		return plain, nil
+
+	case ContainerEncrypted:
+		data = make([]byte, len(plain))
+		copy(data, plain)
+		crypt(data)
+		return
+
+	case ContainerCompressed:
+		var zbuf bytes.Buffer
+		zw := zlib.NewWriter(&zbuf)
+		_, err = zw.Write(plain)
+		if err != nil {
+			return
+		}
+		err = zw.Close()
+		if err != nil {
+			return
+		}
+		hdr := ai2Header{
+			Signature:        [4]byte{'A', 'I', '2', 0},
+			DecompressedSize: uint32(len(plain)),
+			CompressedSize:   uint32(zbuf.Len()),
+		}
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, &hdr)
+		buf.Write(zbuf.Bytes())
+		return buf.Bytes(), nil
+	}
+	return nil, errors.New("unknown container")
+}
+
+// MT19937 pseudo random number generator
+type mt19937 struct {
+	state [624]uint32
+	index int
+}
+
+func newMT19937(seed uint32) *mt19937 {
+	m := &mt19937{index: 624}
+	m.state[0] = seed
+	for i := 1; i < 624; i++ {
+		p := m.state[i-1]
+		m.state[i] = 1812433253*(p^(p>>30)) + uint32(i)
+	}
+	return m
+}
+
+func (m *mt19937) next() uint32 {
+	if m.index >= 624 {
+		for k := 0; k < 624; k++ {
+			y := (m.state[k] & 0x80000000) | (m.state[(k+1)%624] & 0x7fffffff)
+			v := m.state[(k+397)%624] ^ (y >> 1)
+			if y&1 != 0 {
+				v ^= 0x9908b0df
+			}
+			m.state[k] = v
+		}
+		m.index = 0
+	}
+	y := m.state[m.index]
+	m.index++
+	y ^= y >> 11
+	y ^= (y << 7) & 0x9d2c5680
+	y ^= (y << 15) & 0xefc60000
+	y ^= y >> 18
+	return y
+}
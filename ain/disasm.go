@@ -0,0 +1,385 @@
+package ain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A decoded instruction in the CODE section.
+type CodeInstruction struct {
+	Address int
+	*Instruction
+	Args []int32
+}
+
+// Decode the CODE section into instructions.
+func (p *AIN) DecodeCode() (code []CodeInstruction, err error) {
+	for addr := 0; addr < len(p.Code); {
+		if addr+2 > len(p.Code) {
+			return nil, fmt.Errorf("truncated instruction at 0x%x", addr)
+		}
+		op := binary.LittleEndian.Uint16(p.Code[addr:])
+		ins := LookupOpcode(op)
+		if ins == nil {
+			return nil, fmt.Errorf("unknown opcode 0x%04x at 0x%x", op, addr)
+		}
+		argTypes := p.instructionArgs(ins)
+		if addr+2+len(argTypes)*4 > len(p.Code) {
+			return nil, fmt.Errorf("truncated instruction at 0x%x", addr)
+		}
+		ci := CodeInstruction{Address: addr, Instruction: ins, Args: make([]int32, len(argTypes))}
+		for i := range ci.Args {
+			ci.Args[i] = int32(binary.LittleEndian.Uint32(p.Code[addr+2+i*4:]))
+		}
+		code = append(code, ci)
+		addr += 2 + len(argTypes)*4
+	}
+	return
+}
+
+// name of the label at an address
+func labelName(addr int) string {
+	return fmt.Sprintf("L_%08x", addr)
+}
+
+// Write a readable listing of the CODE section.
+//
+// Each line is an instruction with its arguments, or a label, or a directive.
+// Jump targets are replaced with labels, and names of functions, variables and string literals are added as comments after ';'.
+// Directives ".function", ".slabel", ".switch" and ".case" mark the addresses referenced from FUNC, SLBL and SWI0 sections.
+func (p *AIN) Disassemble(w io.Writer) (err error) {
+	code, err := p.DecodeCode()
+	if err != nil {
+		return
+	}
+
+	// instruction boundaries, including the end of code
+	boundary := make(map[int]bool)
+	for _, ci := range code {
+		boundary[ci.Address] = true
+	}
+	boundary[len(p.Code)] = true
+
+	// jump targets
+	labels := make(map[int]bool)
+	for _, ci := range code {
+		for i, t := range p.instructionArgs(ci.Instruction) {
+			if t == ArgAddr && boundary[int(ci.Args[i])] {
+				labels[int(ci.Args[i])] = true
+			}
+		}
+	}
+	for _, s := range p.Switches {
+		if boundary[s.DefaultAddress] {
+			labels[s.DefaultAddress] = true
+		}
+		for _, c := range s.Cases {
+			if boundary[c.Address] {
+				labels[c.Address] = true
+			}
+		}
+	}
+	target := func(addr int) string {
+		if labels[addr] {
+			return labelName(addr)
+		}
+		return strconv.Itoa(addr)
+	}
+
+	// directives
+	directives := make(map[int][]string)
+	for i, f := range p.Functions {
+		if boundary[f.Address] {
+			directives[f.Address] = append(directives[f.Address], fmt.Sprintf(".function %d\t; %s", i, f.Name))
+		}
+	}
+	for i, l := range p.Labels {
+		if boundary[l.Address] {
+			directives[l.Address] = append(directives[l.Address], fmt.Sprintf(".slabel %d\t; %s", i, l.Name))
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "; AIN v%d, 0x%x bytes of code\n", p.Version, len(p.Code))
+	writeMarks := func(addr int) {
+		for _, d := range directives[addr] {
+			fmt.Fprintf(bw, "%s\n", d)
+		}
+		if labels[addr] {
+			fmt.Fprintf(bw, "%s:\n", labelName(addr))
+		}
+	}
+	curFunc := -1
+	for _, ci := range code {
+		writeMarks(ci.Address)
+		if ci.Opcode == opFunc {
+			curFunc = int(ci.Args[0])
+		}
+		argTypes := p.instructionArgs(ci.Instruction)
+		args := make([]string, len(ci.Args))
+		notes := make([]string, 0)
+		for i, t := range argTypes {
+			a := ci.Args[i]
+			switch t {
+			case ArgAddr:
+				args[i] = target(int(a))
+			case ArgFloat:
+				f := math.Float32frombits(uint32(a))
+				if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+					args[i] = fmt.Sprintf("0x%08x", uint32(a))
+				} else {
+					args[i] = strconv.FormatFloat(float64(f), 'g', -1, 32)
+				}
+			default:
+				args[i] = strconv.Itoa(int(a))
+			}
+			if note := p.argNote(t, a, ci.Args, curFunc); note != "" {
+				notes = append(notes, note)
+			}
+		}
+		line := "\t" + ci.Name
+		if len(args) > 0 {
+			line += " " + strings.Join(args, ", ")
+		}
+		if len(notes) > 0 {
+			line += "\t; " + strings.Join(notes, ", ")
+		}
+		fmt.Fprintln(bw, line)
+	}
+	writeMarks(len(p.Code))
+
+	// switch tables
+	for i, s := range p.Switches {
+		fmt.Fprintf(bw, ".switch %d %d %s\n", i, s.CaseType, target(s.DefaultAddress))
+		for _, c := range s.Cases {
+			fmt.Fprintf(bw, ".case %d %s\n", c.Value, target(c.Address))
+		}
+	}
+	return bw.Flush()
+}
+
+// comment on an instruction argument
+func (p *AIN) argNote(t ArgType, a int32, args []int32, curFunc int) string {
+	i := int(a)
+	switch t {
+	case ArgFunc:
+		if i >= 0 && i < len(p.Functions) {
+			return p.Functions[i].Name
+		}
+	case ArgString:
+		if i >= 0 && i < len(p.Strings) {
+			return strconv.Quote(p.Strings[i])
+		}
+	case ArgMessage:
+		if i >= 0 && i < len(p.Messages) {
+			return strconv.Quote(p.Messages[i])
+		}
+	case ArgLocal:
+		if curFunc >= 0 && curFunc < len(p.Functions) && i >= 0 && i < len(p.Functions[curFunc].Vars) {
+			return p.Functions[curFunc].Vars[i].Name
+		}
+	case ArgGlobal:
+		if i >= 0 && i < len(p.Globals) {
+			return p.Globals[i].Name
+		}
+	case ArgStruct:
+		if i >= 0 && i < len(p.Structs) {
+			return p.Structs[i].Name
+		}
+	case ArgDelegate:
+		if i >= 0 && i < len(p.Delegates) {
+			return p.Delegates[i].Name
+		}
+	case ArgFile:
+		if i >= 0 && i < len(p.Filenames) {
+			return p.Filenames[i]
+		}
+	case ArgLibrary:
+		lib := int(args[0])
+		if lib >= 0 && lib < len(p.Libraries) && len(args) > 1 {
+			fn := int(args[1])
+			if fn >= 0 && fn < len(p.Libraries[lib].Functions) {
+				return p.Libraries[lib].Name + "." + p.Libraries[lib].Functions[fn].Name
+			}
+		}
+	}
+	return ""
+}
+
+// a parsed line of an assembly listing
+type asmLine struct {
+	num    int      // line number
+	op     string   // mnemonic or directive
+	args   []string // arguments
+	ins    *Instruction
+	addr   int
+	target []ArgType
+}
+
+// Assemble a listing written by Disassemble, and replace the CODE section.
+// Addresses of functions, scenario labels and switch cases are updated by the directives in the listing.
+func (p *AIN) Assemble(r io.Reader) (err error) {
+	// parse lines and assign addresses
+	var lines []asmLine
+	labels := make(map[string]int)
+	addr := 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for num := 1; sc.Scan(); num++ {
+		s := sc.Text()
+		if i := strings.IndexByte(s, ';'); i >= 0 {
+			s = s[:i]
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		l := asmLine{num: num, addr: addr}
+		if strings.HasSuffix(s, ":") {
+			label := strings.TrimSuffix(s, ":")
+			if _, exists := labels[label]; exists || label == "" {
+				return fmt.Errorf("line %d: invalid or duplicated label %q", num, label)
+			}
+			labels[label] = addr
+			continue
+		}
+		fields := strings.FieldsFunc(s, func(c rune) bool { return c == ' ' || c == '\t' || c == ',' })
+		if len(fields) == 0 {
+			// only separators
+			continue
+		}
+		l.op, l.args = fields[0], fields[1:]
+		if !strings.HasPrefix(l.op, ".") {
+			l.ins = LookupMnemonic(l.op)
+			if l.ins == nil {
+				return fmt.Errorf("line %d: unknown instruction %q", num, l.op)
+			}
+			l.target = p.instructionArgs(l.ins)
+			if len(l.args) != len(l.target) {
+				return fmt.Errorf("line %d: %s needs %d arguments", num, l.op, len(l.target))
+			}
+			addr += 2 + 4*len(l.target)
+		}
+		lines = append(lines, l)
+	}
+	if err = sc.Err(); err != nil {
+		return
+	}
+
+	// parse an integer, or an address label
+	value := func(l *asmLine, s string, isAddr bool) (v int32, err error) {
+		if isAddr {
+			if a, ok := labels[s]; ok {
+				return int32(a), nil
+			}
+		}
+		n, e := strconv.ParseInt(s, 0, 64)
+		if e != nil || n < math.MinInt32 || n > math.MaxUint32 {
+			return 0, fmt.Errorf("line %d: invalid value %q", l.num, s)
+		}
+		return int32(n), nil
+	}
+	index := func(l *asmLine, s string, n int) (i int, err error) {
+		v, err := value(l, s, false)
+		if err == nil && (v < 0 || int(v) >= n) {
+			err = fmt.Errorf("line %d: index %d out of range", l.num, v)
+		}
+		return int(v), err
+	}
+
+	// encode instructions and directives
+	code := make([]byte, 0, addr)
+	funcAddr := make(map[int]int)
+	labelAddr := make(map[int]int)
+	switches := make(map[int]*Switch)
+	var curSwitch *Switch
+	for i := range lines {
+		l := &lines[i]
+		if l.ins != nil {
+			code = binary.LittleEndian.AppendUint16(code, l.ins.Opcode)
+			for j, t := range l.target {
+				var v int32
+				if t == ArgFloat && !strings.HasPrefix(strings.ToLower(l.args[j]), "0x") {
+					f, e := strconv.ParseFloat(l.args[j], 32)
+					if e != nil {
+						return fmt.Errorf("line %d: invalid float %q", l.num, l.args[j])
+					}
+					v = int32(math.Float32bits(float32(f)))
+				} else {
+					v, err = value(l, l.args[j], t == ArgAddr)
+					if err != nil {
+						return
+					}
+				}
+				code = binary.LittleEndian.AppendUint32(code, uint32(v))
+			}
+			continue
+		}
+
+		var n, v int
+		switch l.op {
+		case ".function", ".slabel":
+			if len(l.args) != 1 {
+				return fmt.Errorf("line %d: %s needs an index", l.num, l.op)
+			}
+			if l.op == ".function" {
+				n, err = index(l, l.args[0], len(p.Functions))
+				funcAddr[n] = l.addr
+			} else {
+				n, err = index(l, l.args[0], len(p.Labels))
+				labelAddr[n] = l.addr
+			}
+		case ".switch":
+			if len(l.args) != 3 {
+				return fmt.Errorf("line %d: .switch needs an index, a case type and a default address", l.num)
+			}
+			n, err = index(l, l.args[0], len(p.Switches))
+			if err != nil {
+				return
+			}
+			curSwitch = &Switch{}
+			switches[n] = curSwitch
+			v, err = index(l, l.args[1], math.MaxInt32)
+			curSwitch.CaseType = v
+			if err == nil {
+				var d int32
+				d, err = value(l, l.args[2], true)
+				curSwitch.DefaultAddress = int(d)
+			}
+		case ".case":
+			if len(l.args) != 2 || curSwitch == nil {
+				return fmt.Errorf("line %d: invalid .case", l.num)
+			}
+			var c SwitchCase
+			c.Value, err = value(l, l.args[0], false)
+			if err == nil {
+				var a int32
+				a, err = value(l, l.args[1], true)
+				c.Address = int(a)
+			}
+			curSwitch.Cases = append(curSwitch.Cases, c)
+		default:
+			return fmt.Errorf("line %d: unknown directive %q", l.num, l.op)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	p.Code = code
+	for i, a := range funcAddr {
+		p.Functions[i].Address = a
+	}
+	for i, a := range labelAddr {
+		p.Labels[i].Address = a
+	}
+	for i, s := range switches {
+		p.Switches[i] = *s
+	}
+	return
+}
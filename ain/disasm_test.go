@@ -0,0 +1,125 @@
+package ain
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	a, err := DecodePlain(testAINv4())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = a.Disassemble(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := buf.String()
+	for _, s := range []string{
+		"\tFUNC 0\t; main\n.function 0\t; main\n\tPUSH 1\n",
+		"\tIFZ L_0000001e\n",
+		"\tS_PUSH 0\t; \"str\"\n",
+		"L_0000001e:\n\tMSG 1\t; \"world\"\n",
+		"\tSH_LOCALREF 0\t; x\n",
+		"\tF_PUSH 1.5\n",
+		".function 1\t; f\nL_00000036:\n\tRETURN\n",
+		"\t_EOF 0\t; main.jaf\n",
+		".switch 0 2 L_00000036\n.case 3 L_0000002a\n",
+	} {
+		if !strings.Contains(listing, s) {
+			t.Errorf("listing does not contain %q:\n%s", s, listing)
+		}
+	}
+
+	// assemble the listing as-is
+	err = a.Assemble(strings.NewReader(listing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Code, testCode()) {
+		t.Errorf("assembled code differs")
+	}
+	plain, err := a.EncodePlain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, testAINv4()) {
+		t.Errorf("encoded AIN differs")
+	}
+
+	// insert instructions; addresses must be moved
+	edited := strings.Replace(listing, "\tPUSH 1\n", "\tPUSH 1\n\tPUSH 0x10\n\tPOP\n", 1)
+	err = a.Assemble(strings.NewReader(edited))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err = a.EncodePlain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := DecodePlain(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := b.DecodeCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 14 || code[2].Name != "PUSH" || code[2].Args[0] != 16 || code[3].Name != "POP" {
+		t.Fatalf("unexpected code %+v", code)
+	}
+	if code[4].Name != "IFZ" || code[4].Args[0] != 0x1e+8 || code[6].Name != "JUMP" || code[6].Args[0] != 0x24+8 {
+		t.Errorf("jump targets not moved: %+v %+v", code[4], code[6])
+	}
+	if b.Functions[0].Address != 6 || b.Functions[1].Address != 54+8 {
+		t.Errorf("function addresses not moved: %d %d", b.Functions[0].Address, b.Functions[1].Address)
+	}
+	if !reflect.DeepEqual(b.Switches[0], Switch{2, 54 + 8, []SwitchCase{{3, 42 + 8}}}) {
+		t.Errorf("switch not moved: %+v", b.Switches[0])
+	}
+
+	// extended instructions; a line of separators only is ignored
+	edited = strings.Replace(listing, "\tPUSH 1\n", "\tPUSH 1\n\tSH_IF_LOC_LT_IMM 0, 5, L_0000001e\n\t,\n\tX_MOV 1, 2\n", 1)
+	err = a.Assemble(strings.NewReader(edited))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err = a.DecodeCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code[2].Name != "SH_IF_LOC_LT_IMM" || code[2].Opcode != 0xb8 || !reflect.DeepEqual(code[2].Args, []int32{0, 5, 0x1e + 24}) {
+		t.Errorf("unexpected instruction %+v", code[2])
+	}
+	if code[3].Name != "X_MOV" || code[3].Opcode != 0x109 || !reflect.DeepEqual(code[3].Args, []int32{1, 2}) {
+		t.Errorf("unexpected instruction %+v", code[3])
+	}
+	buf.Reset()
+	err = a.Disassemble(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\tSH_IF_LOC_LT_IMM 0, 5, L_00000036\t; x\n") {
+		t.Errorf("unexpected listing:\n%s", buf.String())
+	}
+
+	// errors
+	for _, s := range []string{
+		"\tNOSUCHOP\n",
+		"\tPUSH\n",
+		"\tJUMP L_nowhere\n",
+		"L_0:\nL_0:\n",
+		".function 5\n",
+	} {
+		if err := a.Assemble(strings.NewReader(s)); err == nil {
+			t.Errorf("%q: must fail", s)
+		}
+	}
+	a.Code = []byte{0xff, 0xff}
+	if _, err := a.DecodeCode(); err == nil {
+		t.Errorf("unknown opcode must fail")
+	}
+}
@@ -0,0 +1,112 @@
+package ain
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// little-endian writer of section data
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) int(v int) {
+	w.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+}
+
+// Encode the AIN into plain sections.
+// CODE, SWI0, SLBL, MSG0, MSG1 and STR0 sections are rebuilt, and function addresses in FUNC are updated.
+// All other sections are written as they were read.
+// The number of functions, messages, strings, switches and labels must not be changed.
+func (p *AIN) EncodePlain() (plain []byte, err error) {
+	var buf bytes.Buffer
+	for _, sec := range p.Sections {
+		buf.WriteString(sec.Tag)
+		var data []byte
+		switch sec.Tag {
+		case "CODE":
+			w := new(writer)
+			w.int(len(p.Code))
+			w.Write(p.Code)
+			data = w.Bytes()
+		case "FUNC":
+			data, err = p.encodeFunctionSection(sec)
+		case "SWI0":
+			w := new(writer)
+			w.int(len(p.Switches))
+			for _, s := range p.Switches {
+				w.int(s.CaseType)
+				w.int(s.DefaultAddress)
+				w.int(len(s.Cases))
+				for _, c := range s.Cases {
+					w.int(int(c.Value))
+					w.int(c.Address)
+				}
+			}
+			data = w.Bytes()
+		case "SLBL":
+			w := new(writer)
+			w.int(len(p.Labels))
+			for _, l := range p.Labels {
+				var name []byte
+				name, err = encodeString(l.Name)
+				if err != nil {
+					return
+				}
+				w.Write(name)
+				w.WriteByte(0)
+				w.int(l.Address)
+			}
+			data = w.Bytes()
+		case "MSG0", "MSG1":
+			data, err = p.encodeStringSection(sec, p.Messages)
+		case "STR0":
+			data, err = p.encodeStringSection(sec, p.Strings)
+		default:
+			data = sec.Data
+		}
+		if err != nil {
+			return
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// copy the FUNC section with the current function addresses
+func (p *AIN) encodeFunctionSection(sec Section) (data []byte, err error) {
+	data = make([]byte, len(sec.Data))
+	copy(data, sec.Data)
+	r := &reader{b: sec.Data}
+	n := r.count(4)
+	if n != len(p.Functions) {
+		return nil, fmt.Errorf("number of functions changed")
+	}
+	for i := 0; i < n && r.err == nil; i++ {
+		// the address is the first field of a function
+		a := p.Functions[i].Address
+		copy(data[r.pos:], []byte{byte(a), byte(a >> 8), byte(a >> 16), byte(a >> 24)})
+		p.readFunction(r)
+	}
+	return data, r.err
+}
+
+// Encode the AIN in its original container.
+func (p *AIN) Encode() (data []byte, err error) {
+	plain, err := p.EncodePlain()
+	if err != nil {
+		return
+	}
+	return Wrap(plain, p.Container)
+}
+
+// Write the AIN in its original container.
+func (p *AIN) Write(w io.Writer) (err error) {
+	data, err := p.Encode()
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
@@ -0,0 +1,366 @@
+package ain
+
+import (
+	"sync"
+)
+
+// Type of an instruction argument.
+type ArgType int
+
+const (
+	ArgInt      ArgType = iota // integer value
+	ArgFloat                   // float32 value
+	ArgAddr                    // address in the CODE section
+	ArgFunc                    // function index
+	ArgString                  // index of STR0
+	ArgMessage                 // index of MSG0/MSG1
+	ArgLocal                   // local variable index of the current function
+	ArgGlobal                  // global variable index
+	ArgStruct                  // struct index
+	ArgMember                  // struct member index
+	ArgSyscall                 // system call number
+	ArgLibrary                 // HLL library index
+	ArgLibFunc                 // HLL function index of the library
+	ArgFile                    // index of FNAM
+	ArgSwitch                  // index of SWI0
+	ArgDelegate                // index of DELG
+)
+
+// An instruction of System 4 bytecode.
+// An instruction is a 16-bit opcode followed by 32-bit arguments.
+type Instruction struct {
+	Opcode uint16
+	Name   string
+	Args   []ArgType
+}
+
+// Opcodes referenced by the disassembler.
+const (
+	opJump      = 0x2c
+	opCallFunc  = 0x30
+	opCallHLL   = 0x5a
+	opFunc      = 0x61
+	opEndFunc   = 0x7e
+	opSwitch    = 0x5f
+	opStrSwitch = 0x60
+)
+
+var (
+	instructionMu     sync.RWMutex
+	instructionByCode = make(map[uint16]*Instruction)
+	instructionByName = make(map[string]*Instruction)
+)
+
+// Register an instruction for the disassembler and the assembler.
+// An instruction registered later replaces the previous one of the same opcode.
+func RegisterInstruction(ins Instruction) {
+	instructionMu.Lock()
+	defer instructionMu.Unlock()
+	if old := instructionByCode[ins.Opcode]; old != nil {
+		delete(instructionByName, old.Name)
+	}
+	p := &ins
+	instructionByCode[ins.Opcode] = p
+	instructionByName[ins.Name] = p
+}
+
+// Find an instruction by its opcode. Returns nil if not known.
+func LookupOpcode(op uint16) *Instruction {
+	instructionMu.RLock()
+	defer instructionMu.RUnlock()
+	return instructionByCode[op]
+}
+
+// Find an instruction by its mnemonic. Returns nil if not known.
+func LookupMnemonic(name string) *Instruction {
+	instructionMu.RLock()
+	defer instructionMu.RUnlock()
+	return instructionByName[name]
+}
+
+// arguments of an instruction in the AIN version
+func (p *AIN) instructionArgs(ins *Instruction) []ArgType {
+	if ins.Opcode == opCallHLL && p.Version >= 11 {
+		// v11+ has the type argument of generic functions
+		return []ArgType{ArgLibrary, ArgLibFunc, ArgInt}
+	}
+	return ins.Args
+}
+
+func init() {
+	// instructions by opcode, up to the extended instructions of the recent versions
+	for i, ins := range []struct {
+		name string
+		args []ArgType
+	}{
+		{"PUSH", []ArgType{ArgInt}},
+		{"POP", nil},
+		{"REF", nil},
+		{"REFREF", nil},
+		{"PUSHGLOBALPAGE", nil},
+		{"PUSHLOCALPAGE", nil},
+		{"INV", nil},
+		{"NOT", nil},
+		{"COMPL", nil},
+		{"ADD", nil},
+		{"SUB", nil},
+		{"MUL", nil},
+		{"DIV", nil},
+		{"MOD", nil},
+		{"AND", nil},
+		{"OR", nil},
+		{"XOR", nil}, // 0x10
+		{"LSHIFT", nil},
+		{"RSHIFT", nil},
+		{"LT", nil},
+		{"GT", nil},
+		{"LTE", nil},
+		{"GTE", nil},
+		{"NOTE", nil},
+		{"EQUALE", nil},
+		{"ASSIGN", nil},
+		{"PLUSA", nil},
+		{"MINUSA", nil},
+		{"MULA", nil},
+		{"DIVA", nil},
+		{"MODA", nil},
+		{"ANDA", nil},
+		{"ORA", nil}, // 0x20
+		{"XORA", nil},
+		{"LSHIFTA", nil},
+		{"RSHIFTA", nil},
+		{"F_ASSIGN", nil},
+		{"F_PLUSA", nil},
+		{"F_MINUSA", nil},
+		{"F_MULA", nil},
+		{"F_DIVA", nil},
+		{"DUP2", nil},
+		{"DUP_X2", nil},
+		{"CMP", nil},
+		{"JUMP", []ArgType{ArgAddr}},
+		{"IFZ", []ArgType{ArgAddr}},
+		{"IFNZ", []ArgType{ArgAddr}},
+		{"RETURN", nil},
+		{"CALLFUNC", []ArgType{ArgFunc}}, // 0x30
+		{"INC", nil},
+		{"DEC", nil},
+		{"FTOI", nil},
+		{"ITOF", nil},
+		{"F_INV", nil},
+		{"F_ADD", nil},
+		{"F_SUB", nil},
+		{"F_MUL", nil},
+		{"F_DIV", nil},
+		{"F_LT", nil},
+		{"F_GT", nil},
+		{"F_LTE", nil},
+		{"F_GTE", nil},
+		{"F_NOTE", nil},
+		{"F_EQUALE", nil},
+		{"F_PUSH", []ArgType{ArgFloat}}, // 0x40
+		{"S_PUSH", []ArgType{ArgString}},
+		{"S_POP", nil},
+		{"S_ADD", nil},
+		{"S_ASSIGN", nil},
+		{"S_PLUSA", nil},
+		{"S_REF", nil},
+		{"S_REFREF", nil},
+		{"S_NOTE", nil},
+		{"S_EQUALE", nil},
+		{"SF_CREATE", nil},
+		{"SF_CREATE_PIXEL", nil},
+		{"SF_CREATE_ALPHA", nil},
+		{"SR_POP", nil},
+		{"SR_ASSIGN", nil},
+		{"SR_REF", []ArgType{ArgStruct}},
+		{"SR_REFREF", nil}, // 0x50
+		{"A_ALLOC", nil},
+		{"A_REALLOC", nil},
+		{"A_FREE", nil},
+		{"A_NUMOF", nil},
+		{"A_COPY", nil},
+		{"A_FILL", nil},
+		{"C_REF", nil},
+		{"C_ASSIGN", nil},
+		{"MSG", []ArgType{ArgMessage}},
+		{"CALLHLL", []ArgType{ArgLibrary, ArgLibFunc}},
+		{"PUSHSTRUCTPAGE", nil},
+		{"CALLMETHOD", []ArgType{ArgFunc}},
+		{"SH_GLOBALREF", []ArgType{ArgGlobal}},
+		{"SH_LOCALREF", []ArgType{ArgLocal}},
+		{"SWITCH", []ArgType{ArgSwitch}},
+		{"STRSWITCH", []ArgType{ArgSwitch}}, // 0x60
+		{"FUNC", []ArgType{ArgFunc}},
+		{"_EOF", []ArgType{ArgFile}},
+		{"CALLSYS", []ArgType{ArgSyscall}},
+		{"SJUMP", nil},
+		{"CALLONJUMP", nil},
+		{"SWAP", nil},
+		{"SH_STRUCTREF", []ArgType{ArgMember}},
+		{"S_LENGTH", nil},
+		{"S_LENGTHBYTE", nil},
+		{"I_STRING", nil},
+		{"CALLFUNC2", nil},
+		{"DUP2_X1", nil},
+		{"R_ASSIGN", nil},
+		{"FT_ASSIGNS", []ArgType{ArgInt}},
+		{"ASSERT", nil},
+		{"S_LT", nil}, // 0x70
+		{"S_GT", nil},
+		{"S_LTE", nil},
+		{"S_GTE", nil},
+		{"S_LENGTH2", nil},
+		{"S_LENGTHBYTE2", nil},
+		{"NEW", []ArgType{ArgStruct, ArgInt}},
+		{"DELETE", nil},
+		{"CHECKUDO", nil},
+		{"A_REF", nil},
+		{"DUP", nil},
+		{"DUP_U2", nil},
+		{"SP_INC", nil},
+		{"SP_DEC", nil},
+		{"ENDFUNC", []ArgType{ArgFunc}},
+		{"R_EQUALE", nil},
+		{"R_NOTE", nil}, // 0x80
+		{"SH_LOCALCREATE", []ArgType{ArgLocal, ArgStruct}},
+		{"SH_LOCALDELETE", []ArgType{ArgLocal}},
+		{"STOI", nil},
+		{"A_PUSHBACK", nil},
+		{"A_POPBACK", nil},
+		{"S_EMPTY", nil},
+		{"A_EMPTY", nil},
+		{"A_ERASE", nil},
+		{"A_INSERT", nil},
+		{"SH_LOCALINC", []ArgType{ArgLocal}},
+		{"SH_LOCALDEC", []ArgType{ArgLocal}},
+		{"SH_LOCALASSIGN", []ArgType{ArgLocal, ArgInt}},
+		{"ITOB", nil},
+		{"S_FIND", nil},
+		{"S_GETPART", nil},
+		{"A_SORT", nil}, // 0x90
+		{"S_PUSHBACK", nil},
+		{"S_POPBACK", nil},
+		{"FTOS", nil},
+		{"S_MOD", []ArgType{ArgInt}},
+		{"S_PLUSA2", nil},
+		{"OBJSWAP", []ArgType{ArgInt}},
+		{"S_ERASE", nil},
+		{"SR_REF2", []ArgType{ArgStruct}},
+		{"S_ERASE2", nil},
+		{"S_PUSHBACK2", nil},
+		{"S_POPBACK2", nil},
+		{"ITOLI", nil},
+		{"LI_ADD", nil},
+		{"LI_SUB", nil},
+		{"LI_MUL", nil},
+		{"LI_DIV", nil}, // 0xa0
+		{"LI_MOD", nil},
+		{"LI_ASSIGN", nil},
+		{"LI_PLUSA", nil},
+		{"LI_MINUSA", nil},
+		{"LI_MULA", nil},
+		{"LI_DIVA", nil},
+		{"LI_MODA", nil},
+		{"LI_ANDA", nil},
+		{"LI_ORA", nil},
+		{"LI_XORA", nil},
+		{"LI_LSHIFTA", nil},
+		{"LI_RSHIFTA", nil},
+		{"LI_INC", nil},
+		{"LI_DEC", nil},
+		{"A_FIND", nil},
+		{"A_REVERSE", nil}, // 0xb0
+		{"SH_SR_ASSIGN", nil},
+		{"SH_MEM_ASSIGN_LOCAL", []ArgType{ArgMember, ArgLocal}},
+		{"A_NUMOF_GLOB_1", []ArgType{ArgGlobal}},
+		{"A_NUMOF_STRUCT_1", []ArgType{ArgMember}},
+		{"SH_MEM_ASSIGN_IMM", []ArgType{ArgMember, ArgInt}},
+		{"SH_LOCALREFREF", []ArgType{ArgLocal}},
+		{"SH_LOCALASSIGN_SUB_IMM", []ArgType{ArgLocal, ArgInt}},
+		{"SH_IF_LOC_LT_IMM", []ArgType{ArgLocal, ArgInt, ArgAddr}},
+		{"SH_IF_LOC_GE_IMM", []ArgType{ArgLocal, ArgInt, ArgAddr}},
+		{"SH_LOCREF_ASSIGN_MEM", []ArgType{ArgLocal, ArgMember}},
+		{"PAGE_REF", []ArgType{ArgInt}},
+		{"SH_GLOBAL_ASSIGN_LOCAL", []ArgType{ArgGlobal, ArgLocal}},
+		{"SH_STRUCTREF_GT_IMM", []ArgType{ArgMember, ArgInt}},
+		{"SH_STRUCT_ASSIGN_LOCALREF_ITOB", []ArgType{ArgMember, ArgLocal}},
+		{"SH_LOCAL_ASSIGN_STRUCTREF", []ArgType{ArgLocal, ArgMember}},
+		{"SH_IF_STRUCTREF_NE_LOCALREF", []ArgType{ArgMember, ArgLocal, ArgAddr}}, // 0xc0
+		{"SH_IF_STRUCTREF_GT_IMM", []ArgType{ArgMember, ArgInt, ArgAddr}},
+		{"SH_STRUCTREF_CALLMETHOD_NO_PARAM", []ArgType{ArgMember, ArgFunc}},
+		{"SH_STRUCTREF2", []ArgType{ArgMember, ArgMember}},
+		{"SH_REF_STRUCTREF2", []ArgType{ArgMember, ArgMember}},
+		{"SH_STRUCTREF3", []ArgType{ArgMember, ArgMember, ArgMember}},
+		{"SH_STRUCTREF2_CALLMETHOD_NO_PARAM", []ArgType{ArgMember, ArgMember, ArgFunc}},
+		{"SH_IF_STRUCTREF_Z", []ArgType{ArgMember, ArgAddr}},
+		{"SH_IF_STRUCT_A_NOT_EMPTY", []ArgType{ArgMember, ArgAddr}},
+		{"SH_IF_LOC_GT_IMM", []ArgType{ArgLocal, ArgInt, ArgAddr}},
+		{"SH_IF_STRUCTREF_NE_IMM", []ArgType{ArgMember, ArgInt, ArgAddr}},
+		{"THISCALLMETHOD_NOPARAM", []ArgType{ArgFunc}},
+		{"SH_IF_LOC_NE_IMM", []ArgType{ArgLocal, ArgInt, ArgAddr}},
+		{"SH_IF_STRUCTREF_EQ_IMM", []ArgType{ArgMember, ArgInt, ArgAddr}},
+		{"SH_GLOBAL_ASSIGN_IMM", []ArgType{ArgGlobal, ArgInt}},
+		{"SH_LOCALSTRUCT_ASSIGN_IMM", []ArgType{ArgLocal, ArgMember, ArgInt}},
+		{"SH_STRUCT_A_PUSHBACK_LOCAL_STRUCT", []ArgType{ArgMember, ArgLocal}}, // 0xd0
+		{"SH_GLOBAL_A_PUSHBACK_LOCAL_STRUCT", []ArgType{ArgGlobal, ArgLocal}},
+		{"SH_LOCAL_A_PUSHBACK_LOCAL_STRUCT", []ArgType{ArgLocal, ArgLocal}},
+		{"SH_IF_SREF_NE_STR0", []ArgType{ArgString, ArgAddr}},
+		{"SH_S_ASSIGN_REF", nil},
+		{"SH_A_FIND_SREF", nil},
+		{"SH_SREF_EMPTY", nil},
+		{"SH_STRUCTSREF_EQ_LOCALSREF", []ArgType{ArgMember, ArgLocal}},
+		{"SH_LOCALSREF_EQ_STR0", []ArgType{ArgLocal, ArgString}},
+		{"SH_STRUCTSREF_NE_LOCALSREF", []ArgType{ArgMember, ArgLocal}},
+		{"SH_LOCALSREF_NE_STR0", []ArgType{ArgLocal, ArgString}},
+		{"SH_STRUCT_SR_REF", []ArgType{ArgMember, ArgStruct}},
+		{"SH_STRUCT_S_REF", []ArgType{ArgMember}},
+		{"S_REF2", []ArgType{ArgMember}},
+		{"SH_REF_LOCAL_ASSIGN_STRUCTREF2", []ArgType{ArgMember, ArgLocal, ArgMember}},
+		{"SH_GLOBAL_S_REF", []ArgType{ArgGlobal}},
+		{"SH_LOCAL_S_REF", []ArgType{ArgLocal}}, // 0xe0
+		{"SH_LOCALREF_SASSIGN_LOCALSREF", []ArgType{ArgLocal, ArgLocal}},
+		{"SH_LOCAL_APUSHBACK_LOCALSREF", []ArgType{ArgLocal, ArgLocal}},
+		{"SH_S_ASSIGN_CALLSYS19", nil},
+		{"SH_S_ASSIGN_STR0", []ArgType{ArgString}},
+		{"SH_SASSIGN_LOCALSREF", []ArgType{ArgLocal}},
+		{"SH_STRUCTREF_SASSIGN_LOCALSREF", []ArgType{ArgMember, ArgLocal}},
+		{"SH_LOCALSREF_EMPTY", []ArgType{ArgLocal}},
+		{"SH_GLOBAL_APUSHBACK_LOCALSREF", []ArgType{ArgGlobal, ArgLocal}},
+		{"SH_STRUCT_APUSHBACK_LOCALSREF", []ArgType{ArgMember, ArgLocal}},
+		{"SH_STRUCTSREF_EMPTY", []ArgType{ArgMember}},
+		{"SH_GLOBALSREF_EMPTY", []ArgType{ArgGlobal}},
+		{"SH_SASSIGN_STRUCTSREF", []ArgType{ArgMember}},
+		{"SH_SASSIGN_GLOBALSREF", []ArgType{ArgGlobal}},
+		{"SH_STRUCTSREF_NE_STR0", []ArgType{ArgMember, ArgString}},
+		{"SH_GLOBALSREF_NE_STR0", []ArgType{ArgGlobal, ArgString}},
+		{"SH_LOC_LT_IMM_OR_LOC_GE_IMM", []ArgType{ArgLocal, ArgInt, ArgInt}}, // 0xf0
+		{"A_SORT_MEM", nil},
+		{"DG_ADD", nil},
+		{"DG_SET", nil},
+		{"DG_CALL", []ArgType{ArgDelegate, ArgAddr}},
+		{"DG_NUMOF", nil},
+		{"DG_EXIST", nil},
+		{"DG_ERASE", nil},
+		{"DG_CLEAR", nil},
+		{"DG_COPY", nil},
+		{"DG_ASSIGN", nil},
+		{"DG_PLUSA", nil},
+		{"DG_POP", nil},
+		{"DG_NEW_FROM_METHOD", nil},
+		{"DG_MINUSA", nil},
+		{"DG_CALLBEGIN", []ArgType{ArgDelegate}},
+		{"DG_NEW", nil}, // 0x100
+		{"DG_STR_TO_METHOD", []ArgType{ArgDelegate}},
+		{"OP_0X102", nil},
+		{"X_GETENV", nil},
+		{"X_SET", nil},
+		{"X_ICAST", []ArgType{ArgStruct}},
+		{"X_OCAST", []ArgType{ArgStruct}},
+		{"X_ASSIGN", []ArgType{ArgInt}},
+		{"X_DUP", []ArgType{ArgInt}},
+		{"X_MOV", []ArgType{ArgInt, ArgInt}},
+		{"X_REF", []ArgType{ArgInt}},
+	} {
+		RegisterInstruction(Instruction{Opcode: uint16(i), Name: ins.name, Args: ins.args})
+	}
+}
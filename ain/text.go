@@ -0,0 +1,197 @@
+package ain
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// ID prefixes of the exported text lines.
+const (
+	TextIDMessage = "msg" // MSG0 or MSG1
+	TextIDString  = "str" // STR0
+)
+
+// ID of a text line
+func textID(prefix string, index int) string {
+	return fmt.Sprintf("%s.%06d", prefix, index)
+}
+
+// Export messages and strings as UTF-8 text lines for translation.
+// Each line is an ID and a text separated by a tab, as in "msg.000012\ttext".
+// Newlines, tabs and backslashes in the text are escaped with backslashes.
+// Lines starting with '#' are comments.
+func (p *AIN) ExportText(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# AIN v%d: %d messages, %d strings\n", p.Version, len(p.Messages), len(p.Strings))
+	for i, s := range p.Messages {
+		fmt.Fprintf(bw, "%s\t%s\n", textID(TextIDMessage, i), escapeText(s))
+	}
+	for i, s := range p.Strings {
+		fmt.Fprintf(bw, "%s\t%s\n", textID(TextIDString, i), escapeText(s))
+	}
+	return bw.Flush()
+}
+
+// Import text lines written by ExportText, and replace the messages and strings.
+// Lines may be omitted to leave the text unchanged. Returns the number of imported lines.
+// The text must be representable in ShiftJIS.
+func (p *AIN) ImportText(r io.Reader) (n int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSuffix(sc.Text(), "\r")
+		if line == 1 {
+			s = strings.TrimPrefix(s, "\ufeff") // BOM
+		}
+		if s == "" || s[0] == '#' {
+			continue
+		}
+		id, text, ok := strings.Cut(s, "\t")
+		if !ok {
+			return n, fmt.Errorf("line %d: no tab separator", line)
+		}
+		prefix, num, _ := strings.Cut(id, ".")
+		index, e := strconv.Atoi(num)
+		var list []string
+		switch prefix {
+		case TextIDMessage:
+			list = p.Messages
+		case TextIDString:
+			list = p.Strings
+		}
+		if list == nil || e != nil || index < 0 || index >= len(list) {
+			return n, fmt.Errorf("line %d: invalid ID %q", line, id)
+		}
+		text, err = unescapeText(text)
+		if err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, e := encodeString(text); e != nil {
+			return n, fmt.Errorf("line %d: %w", line, e)
+		}
+		list[index] = text
+		n++
+	}
+	err = sc.Err()
+	return
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r", "\t", "\\t").Replace(s)
+}
+
+func unescapeText(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf("unterminated escape")
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			return "", fmt.Errorf("unknown escape \\%c", s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// convert a UTF-8 string to ShiftJIS
+func encodeString(s string) ([]byte, error) {
+	if strings.IndexByte(s, 0) >= 0 {
+		return nil, fmt.Errorf("text contains NUL")
+	}
+	b, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("text %q is not representable in ShiftJIS", s)
+	}
+	return b, nil
+}
+
+// encode a string list, reusing the original bytes for unchanged strings
+func encodeStrings(list []string, orig [][]byte) (enc [][]byte, err error) {
+	enc = make([][]byte, len(list))
+	for i, s := range list {
+		if i < len(orig) && decodeString(orig[i]) == s {
+			enc[i] = orig[i]
+			continue
+		}
+		enc[i], err = encodeString(s)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// rebuild the body of a string section
+func (p *AIN) encodeStringSection(sec Section, list []string) (data []byte, err error) {
+	// original raw strings
+	r := &reader{b: sec.Data}
+	orig := make([][]byte, r.count(1))
+	if sec.Tag == "MSG1" {
+		r.int() // unknown
+		for i := range orig {
+			orig[i] = decodeMSG1(r.bytes(r.count(1)))
+		}
+	} else {
+		for i := range orig {
+			orig[i] = r.rawStr()
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(orig) != len(list) {
+		return nil, fmt.Errorf("number of strings in %s changed", sec.Tag)
+	}
+	enc, err := encodeStrings(list, orig)
+	if err != nil {
+		return
+	}
+
+	w := new(writer)
+	w.int(len(enc))
+	if sec.Tag == "MSG1" {
+		w.int(p.MSG1Unknown)
+		for _, b := range enc {
+			w.int(len(b))
+			w.Write(encodeMSG1(b))
+		}
+	} else {
+		for _, b := range enc {
+			w.Write(b)
+			w.WriteByte(0)
+		}
+	}
+	return w.Bytes(), nil
+}
+
+// apply the obfuscation of MSG1 strings
+func encodeMSG1(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[i] = c + byte(i) + 0x60
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package ain
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTextRoundTrip(t *testing.T) {
+	for _, plain := range [][]byte{testAINv4(), testAINv12()} {
+		a, err := DecodePlain(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// unchanged AIN must be encoded to the identical bytes
+		enc, err := a.EncodePlain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(enc, plain) {
+			t.Errorf("v%d: re-encoded data differs", a.Version)
+		}
+
+		var buf bytes.Buffer
+		err = a.ExportText(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := a.ImportText(strings.NewReader(buf.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(a.Messages)+len(a.Strings) {
+			t.Errorf("v%d: %d lines imported", a.Version, n)
+		}
+	}
+}
+
+func TestImportText(t *testing.T) {
+	a, err := DecodePlain(testAINv12())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Container = ContainerCompressed
+
+	// translate MSG1
+	n, err := a.ImportText(strings.NewReader("# comment\n\nmsg.000000\tこんにちは\\n世界\r\n"))
+	if err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	data, err := a.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b.Messages, []string{"こんにちは\n世界"}) {
+		t.Errorf("unexpected messages %q", b.Messages)
+	}
+	// other sections must be preserved
+	for i, sec := range a.Sections {
+		if sec.Tag != "MSG1" && !bytes.Equal(sec.Data, b.Sections[i].Data) {
+			t.Errorf("section %s changed", sec.Tag)
+		}
+	}
+
+	// MSG0 and STR0
+	a, err = DecodePlain(testAINv4())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.ImportText(strings.NewReader("msg.000001\tテスト\nstr.000000\tab\\\\c\\t\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := a.EncodePlain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = DecodePlain(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b.Messages, []string{"hello", "テスト"}) || !reflect.DeepEqual(b.Strings, []string{"ab\\c\t"}) {
+		t.Errorf("unexpected text %q %q", b.Messages, b.Strings)
+	}
+
+	// invalid lines
+	for _, s := range []string{
+		"msg.000002\tout of range",
+		"xyz.000000\tunknown",
+		"msg.000000 no tab",
+		"msg.000000\tbad\\escape",
+		"msg.000000\t\U0001F600", // not in ShiftJIS
+	} {
+		_, err = a.ImportText(strings.NewReader(s))
+		if err == nil {
+			t.Errorf("%q: must fail", s)
+		}
+	}
+}
@@ -26,8 +26,9 @@ type FileEntry struct {
 type FileType int
 
 const (
-	TypeALD FileType = 0x01 // .ald archive
-	TypeAFA FileType = 0x11 // .afa archive
+	TypeALD  FileType = 0x01 // .ald archive
+	TypeAFA  FileType = 0x11 // .afa archive
+	TypeFLAT FileType = 0x21 // files embedded in .flat
 )
 
 // AliceSoft ALD/AFA archive
@@ -0,0 +1,133 @@
+package aliceafa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Load the embedded files of an AliceSoft FLAT animation file.
+// A FLAT file may have ".flat" or ".flt" extension.
+//
+// The file is an optional "ELNA" header of 8 bytes, then chunks of a 4-char signature, uint32 length and the body.
+// The "TMNL" chunk is the thumbnail image, and the "LIBL" chunk is the library of the embedded files:
+// the number of files, then each file is uint32 unknown, the length-prefixed ShiftJIS name padded to 4 bytes,
+// uint32 type, the length-prefixed data padded to 4 bytes.
+// The layout is reconstructed from observation. Other chunks are ignored.
+//
+// The thumbnail is named "thumbnail", and a file without a name is named by its index.
+// The offsets of the entries are relative to the start of rs.
+func OpenFLAT(rs io.ReadSeeker) (flat *AliceArch, err error) {
+	_, err = rs.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+	data, err := io.ReadAll(rs)
+	if err != nil {
+		return
+	}
+	pos := 0
+	if bytes.HasPrefix(data, []byte("ELNA")) {
+		pos = 8
+	}
+	if len(data) < pos+8 || string(data[pos:pos+4]) != "FLAT" {
+		return nil, ErrInvalidArchive
+	}
+
+	flat = &AliceArch{Type: TypeFLAT}
+	for pos+8 <= len(data) {
+		sig := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if size < 0 || size > len(data)-pos {
+			return nil, fmt.Errorf("FLAT chunk %q: %w", sig, ErrInvalidArchive)
+		}
+		body := data[pos : pos+size]
+		switch sig {
+		case "TMNL":
+			off, sz := imageInChunk(body)
+			flat.Entry = append(flat.Entry, FileEntry{Name: "thumbnail", Offset: int64(pos + off), Size: int64(sz)})
+		case "LIBL":
+			var entries []FileEntry
+			entries, err = readFLATLibrary(body, int64(pos))
+			if err != nil {
+				return nil, err
+			}
+			flat.Entry = append(flat.Entry, entries...)
+		}
+		pos += size
+	}
+	return
+}
+
+// read the entries of a LIBL chunk at the offset base
+func readFLATLibrary(b []byte, base int64) (entries []FileEntry, err error) {
+	pos := 0
+	u32 := func() int {
+		if err != nil {
+			return 0
+		}
+		if pos+4 > len(b) {
+			err = fmt.Errorf("LIBL chunk: %w", io.ErrUnexpectedEOF)
+			return 0
+		}
+		v := int(binary.LittleEndian.Uint32(b[pos:]))
+		pos += 4
+		return v
+	}
+	// length-prefixed bytes padded to 4 bytes
+	chunk := func() (off, size int) {
+		size = u32()
+		if err == nil && (size < 0 || size > len(b)-pos) {
+			err = fmt.Errorf("LIBL chunk: %w", ErrInvalidArchive)
+		}
+		if err != nil {
+			return 0, 0
+		}
+		off = pos
+		pos += (size + 3) &^ 3
+		if pos > len(b) {
+			pos = len(b)
+		}
+		return
+	}
+
+	n := u32()
+	if err == nil && (n < 0 || n*16 > len(b)-pos) {
+		return nil, fmt.Errorf("LIBL chunk: %w", ErrInvalidArchive)
+	}
+	sjis := japanese.ShiftJIS.NewDecoder()
+	entries = make([]FileEntry, n)
+	for i := range entries {
+		u32() // unknown
+		off, size := chunk()
+		name, e := sjis.Bytes(b[off : off+size])
+		if e != nil {
+			name = b[off : off+size]
+		}
+		u32() // type
+		off, size = chunk()
+		if err != nil {
+			return nil, err
+		}
+		entries[i].Name = string(name)
+		if entries[i].Name == "" {
+			entries[i].Name = fmt.Sprintf("%03d", i)
+		}
+		o, sz := imageInChunk(b[off : off+size])
+		entries[i].Offset = base + int64(off+o)
+		entries[i].Size = int64(sz)
+	}
+	return
+}
+
+// Images in FLAT may have a 4-byte prefix. Returns the offset and the size of the image in b.
+func imageInChunk(b []byte) (off, size int) {
+	if DetectContentType(b) == ContentUnknown && len(b) > 4 && DetectContentType(b[4:]) != ContentUnknown {
+		return 4, len(b) - 4
+	}
+	return 0, len(b)
+}
@@ -0,0 +1,97 @@
+// Package asd reads and writes .asd save data of AliceSoft System 4 games.
+//
+// A save file is a small header followed by a zlib-compressed payload.
+// The payload of a global save has the tables of global variables, struct records, strings and arrays, in the layout read by xsystem4.
+// Other payloads, such as local and resume saves, are not decoded and kept as raw bytes.
+package asd
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/mixcode/alicesoft-afa/internal/zlibutil"
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid save data format")
+)
+
+// save file header
+type asdHeader struct {
+	Signature        [4]byte
+	Version          uint32
+	DecompressedSize uint32
+	CompressedSize   uint32
+}
+
+// A save file.
+type ASD struct {
+	Signature string // 4-byte signature of the file
+	Version   int
+	Payload   []byte // decompressed payload
+}
+
+// Read a save file.
+func Read(r io.Reader) (asd *ASD, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return Decode(data)
+}
+
+// Decode a save file.
+func Decode(data []byte) (asd *ASD, err error) {
+	var hdr asdHeader
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr)
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	body := data[binary.Size(hdr):]
+	if int64(hdr.CompressedSize) > int64(len(body)) || !zlibutil.IsHeader(body) {
+		return nil, ErrInvalidFormat
+	}
+	asd = &ASD{Signature: string(hdr.Signature[:]), Version: int(hdr.Version)}
+	asd.Payload, err = zlibutil.Inflate(body[:hdr.CompressedSize], hdr.DecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Encode the save file.
+func (p *ASD) Encode() (data []byte, err error) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	_, err = zw.Write(p.Payload)
+	if err != nil {
+		return
+	}
+	err = zw.Close()
+	if err != nil {
+		return
+	}
+	hdr := asdHeader{
+		Version:          uint32(p.Version),
+		DecompressedSize: uint32(len(p.Payload)),
+		CompressedSize:   uint32(z.Len()),
+	}
+	copy(hdr.Signature[:], p.Signature)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	buf.Write(z.Bytes())
+	return buf.Bytes(), nil
+}
+
+// Write the save file.
+func (p *ASD) Write(w io.Writer) (err error) {
+	data, err := p.Encode()
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
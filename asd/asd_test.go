@@ -0,0 +1,170 @@
+package asd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+
+	"github.com/mixcode/alicesoft-afa/ain"
+)
+
+// payload bytes of a global save, written field by field in the gsave layout
+func testGlobalPayload() []byte {
+	var b bytes.Buffer
+	i32 := func(v ...int32) { binary.Write(&b, binary.LittleEndian, v) }
+	str := func(s string) {
+		sj, _ := japanese.ShiftJIS.NewEncoder().Bytes([]byte(s))
+		b.Write(sj)
+		b.WriteByte(0)
+	}
+	str("ランス")
+	i32(0, 5, 0, 3) // unknown, version, unknown, AIN globals
+	str("main")
+	// globals
+	i32(5)
+	str("g_day")
+	i32(int32(ain.TypeInt), 12)
+	str("g_name")
+	i32(int32(ain.TypeString), 0)
+	str("g_party")
+	i32(int32(ain.TypeStruct), 0)
+	str("power")
+	i32(int32(ain.TypeFloat), int32(math.Float32bits(1.5)))
+	str("members")
+	i32(int32(ain.TypeArrayInt), 0)
+	// records
+	i32(1, 1)
+	str("Party")
+	i32(2, 3, 4)
+	// strings
+	i32(1)
+	str("シィル")
+	// arrays: one of rank 1, and an empty one
+	i32(2, 1, 2, 1, 2, int32(ain.TypeInt), 1, int32(ain.TypeInt), -1, -1)
+	// key-values
+	i32(1)
+	str("flag")
+	i32(1)
+	return b.Bytes()
+}
+
+func TestGlobalSave(t *testing.T) {
+	src := &GlobalSave{
+		Key:        "ランス",
+		Version:    5,
+		AINGlobals: 3,
+		Group:      "main",
+		Globals: []Global{
+			{Name: "g_day", Type: ain.TypeInt, Value: 12},
+			{Name: "g_name", Type: ain.TypeString, Value: 0},
+			{Name: "g_party", Type: ain.TypeStruct, Value: 0},
+			{Name: "power", Type: ain.TypeFloat, Value: int32(math.Float32bits(1.5))},
+			{Name: "members", Type: ain.TypeArrayInt, Value: 0},
+		},
+		Records: []Record{{Type: 1, StructName: "Party", Indices: []int32{3, 4}}},
+		Strings: []string{"シィル"},
+		Arrays: []Array{
+			{Rank: 1, Dimensions: []int32{2}, Flat: [][]ArrayValue{{{ain.TypeInt, 1}, {ain.TypeInt, -1}}}},
+			{Rank: -1},
+		},
+		KeyValues: []KeyValue{{Name: "flag", Value: 1}},
+	}
+	save := &ASD{Signature: "GD\x00\x00", Version: 7, Payload: testGlobalPayload()}
+	gs, err := save.DecodeGlobals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gs, src) {
+		t.Errorf("decoded globals differ: %+v", gs)
+	}
+	if gs.Globals[3].Float() != 1.5 {
+		t.Errorf("unexpected float value")
+	}
+	if err := save.SetGlobals(gs); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(save.Payload, testGlobalPayload()) {
+		t.Errorf("encoded payload differs")
+	}
+
+	// globals have no names before version 5
+	old := *src
+	old.Version = 4
+	old.Globals = append([]Global(nil), src.Globals...)
+	for i := range old.Globals {
+		old.Globals[i].Name = ""
+	}
+	if err := save.SetGlobals(&old); err != nil {
+		t.Fatal(err)
+	}
+	if gs, err := save.DecodeGlobals(); err != nil || !reflect.DeepEqual(gs, &old) {
+		t.Errorf("version 4 save differs: %+v, %v", gs, err)
+	}
+	if err := save.SetGlobals(src); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := save.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asd, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(asd, save) {
+		t.Errorf("decoded save differs: %+v", asd)
+	}
+
+	// validation against AIN
+	a := &ain.AIN{
+		Globals: []ain.Global{
+			{Name: "g_day", Type: ain.Type{Data: ain.TypeInt, Struct: -1}},
+			{Name: "g_name", Type: ain.Type{Data: ain.TypeString, Struct: -1}},
+			{Name: "g_party", Type: ain.Type{Data: ain.TypeStruct, Struct: 0}},
+		},
+		Structs: []ain.Struct{
+			{Name: "Party", Members: []ain.Variable{
+				{Name: "power", Type: ain.Type{Data: ain.TypeFloat, Struct: -1}},
+				{Name: "members", Type: ain.Type{Data: ain.TypeArrayInt, Struct: -1, Rank: 1}},
+			}},
+		},
+	}
+	if err := gs.Validate(a); err != nil {
+		t.Errorf("validation failed: %v", err)
+	}
+	a.Structs[0].Members[0].Type.Data = ain.TypeInt
+	if err := gs.Validate(a); err == nil {
+		t.Errorf("member type mismatch must fail")
+	}
+	a.Structs[0].Members[0].Type.Data = ain.TypeFloat
+	gs.Globals[2].Value = 5
+	if err := gs.Validate(a); err == nil {
+		t.Errorf("invalid record index must fail")
+	}
+	gs.Globals[0].Name = "g_unknown"
+	if err := gs.Validate(a); err == nil {
+		t.Errorf("unknown global must fail")
+	}
+
+	// errors
+	if _, err := Decode(data[:10]); err == nil {
+		t.Errorf("truncated data must fail")
+	}
+	broken := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(broken[8:], 0xffffffff) // DecompressedSize
+	if _, err := Decode(broken); err == nil {
+		t.Errorf("broken decompressed size must fail")
+	}
+	if _, err := (&ASD{Payload: save.Payload[:20]}).DecodeGlobals(); err == nil {
+		t.Errorf("truncated payload must fail")
+	}
+	src.Key = "é☺"
+	if err := save.SetGlobals(src); err == nil {
+		t.Errorf("non-ShiftJIS string must fail")
+	}
+}
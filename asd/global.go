@@ -0,0 +1,351 @@
+package asd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/text/encoding/japanese"
+
+	"github.com/mixcode/alicesoft-afa/ain"
+)
+
+// A global variable, or a member of a struct record.
+// Value is the value of int and bool types, the bits of a float, or an index:
+// of Strings for a string, of Records for a struct, and of Arrays for an array (-1 if none).
+type Global struct {
+	Name  string `json:",omitempty"` // name of the variable. saved from version 5
+	Type  ain.DataType
+	Value int32
+}
+
+// Float value of a float type.
+func (g Global) Float() float32 {
+	return math.Float32frombits(uint32(g.Value))
+}
+
+// A struct record. The members are the globals at the indices.
+type Record struct {
+	Type       int32
+	StructName string
+	Indices    []int32
+}
+
+// A value in an array.
+type ArrayValue struct {
+	Type  ain.DataType
+	Value int32 // same as Global.Value
+}
+
+// An array. The values are flattened, and grouped by the innermost dimension.
+type Array struct {
+	Rank       int32          // -1 for an empty array
+	Dimensions []int32        `json:",omitempty"`
+	Flat       [][]ArrayValue `json:",omitempty"`
+}
+
+// A named int value.
+type KeyValue struct {
+	Name  string
+	Value int32
+}
+
+// Payload of a global save, in the layout read by xsystem4.
+//
+// The header is the key string, an unknown int, the version, an unknown int, the number of globals in the AIN file and the group name.
+// Then follow the tables of globals, struct records, strings, arrays and key-values, each prefixed by its length.
+// Strings are zero-terminated ShiftJIS.
+type GlobalSave struct {
+	Key        string
+	Unknown1   int32
+	Version    int32
+	Unknown2   int32
+	AINGlobals int // number of global variables in the AIN file
+	Group      string
+	Globals    []Global
+	Records    []Record
+	Strings    []string
+	Arrays     []Array
+	KeyValues  []KeyValue
+}
+
+// Decode the payload as a global save.
+func (p *ASD) DecodeGlobals() (save *GlobalSave, err error) {
+	r := &reader{b: p.Payload}
+	save = &GlobalSave{Key: r.str(), Unknown1: r.int32(), Version: r.int32(), Unknown2: r.int32(), AINGlobals: int(r.int32()), Group: r.str()}
+	save.Globals = make([]Global, r.count(8))
+	for i := range save.Globals {
+		g := &save.Globals[i]
+		if save.Version >= 5 {
+			g.Name = r.str()
+		}
+		g.Type, g.Value = ain.DataType(r.int32()), r.int32()
+	}
+	save.Records = make([]Record, r.count(9))
+	for i := range save.Records {
+		rec := &save.Records[i]
+		rec.Type, rec.StructName = r.int32(), r.str()
+		rec.Indices = make([]int32, r.count(4))
+		for j := range rec.Indices {
+			rec.Indices[j] = r.int32()
+		}
+	}
+	save.Strings = make([]string, r.count(1))
+	for i := range save.Strings {
+		save.Strings[i] = r.str()
+	}
+	save.Arrays = make([]Array, r.count(4))
+	for i := range save.Arrays {
+		a := &save.Arrays[i]
+		a.Rank = r.int32()
+		if a.Rank < 1 {
+			continue
+		}
+		a.Dimensions = make([]int32, r.check(int(a.Rank), 4))
+		for j := range a.Dimensions {
+			a.Dimensions[j] = r.int32()
+		}
+		a.Flat = make([][]ArrayValue, r.count(4))
+		for j := range a.Flat {
+			a.Flat[j] = make([]ArrayValue, r.count(8))
+			for k := range a.Flat[j] {
+				a.Flat[j][k] = ArrayValue{Type: ain.DataType(r.int32()), Value: r.int32()}
+			}
+		}
+	}
+	save.KeyValues = make([]KeyValue, r.count(5))
+	for i := range save.KeyValues {
+		save.KeyValues[i] = KeyValue{Name: r.str(), Value: r.int32()}
+	}
+	if r.err == nil && r.pos != len(r.b) {
+		r.err = fmt.Errorf("%d extra bytes at the end of the payload", len(r.b)-r.pos)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return
+}
+
+// Encode a global save into the payload.
+func (p *ASD) SetGlobals(save *GlobalSave) (err error) {
+	w := &writer{}
+	w.str(save.Key)
+	w.int32(save.Unknown1)
+	w.int32(save.Version)
+	w.int32(save.Unknown2)
+	w.int32(int32(save.AINGlobals))
+	w.str(save.Group)
+	w.int32(int32(len(save.Globals)))
+	for _, g := range save.Globals {
+		if save.Version >= 5 {
+			w.str(g.Name)
+		}
+		w.int32(int32(g.Type))
+		w.int32(g.Value)
+	}
+	w.int32(int32(len(save.Records)))
+	for _, rec := range save.Records {
+		w.int32(rec.Type)
+		w.str(rec.StructName)
+		w.int32(int32(len(rec.Indices)))
+		for _, i := range rec.Indices {
+			w.int32(i)
+		}
+	}
+	w.int32(int32(len(save.Strings)))
+	for _, s := range save.Strings {
+		w.str(s)
+	}
+	w.int32(int32(len(save.Arrays)))
+	for i, a := range save.Arrays {
+		w.int32(a.Rank)
+		if a.Rank < 1 {
+			continue
+		}
+		if len(a.Dimensions) != int(a.Rank) && w.err == nil {
+			w.err = fmt.Errorf("array %d has %d dimensions for rank %d", i, len(a.Dimensions), a.Rank)
+		}
+		for _, d := range a.Dimensions {
+			w.int32(d)
+		}
+		w.int32(int32(len(a.Flat)))
+		for _, flat := range a.Flat {
+			w.int32(int32(len(flat)))
+			for _, v := range flat {
+				w.int32(int32(v.Type))
+				w.int32(v.Value)
+			}
+		}
+	}
+	w.int32(int32(len(save.KeyValues)))
+	for _, kv := range save.KeyValues {
+		w.str(kv.Name)
+		w.int32(kv.Value)
+	}
+	if w.err != nil {
+		return w.err
+	}
+	p.Payload = w.Bytes()
+	return
+}
+
+// check the index of a value to its table
+func (p *GlobalSave) checkRef(t ain.DataType, v int32) error {
+	n := -1
+	switch {
+	case t == ain.TypeString:
+		n = len(p.Strings)
+	case t == ain.TypeStruct:
+		n = len(p.Records)
+	case isArrayType(t):
+		n = len(p.Arrays)
+	}
+	if n >= 0 && (v < -1 || int(v) >= n) {
+		return fmt.Errorf("invalid index %d", v)
+	}
+	return nil
+}
+
+// whether the type is an array type
+func isArrayType(t ain.DataType) bool {
+	switch t {
+	case ain.TypeArrayInt, ain.TypeArrayFloat, ain.TypeArrayString, ain.TypeArrayStruct, ain.TypeArray:
+		return true
+	}
+	return false
+}
+
+// Check the global save against the AIN file of the game.
+// Named globals in the AIN must have the same data types, struct records must match the members of their structs,
+// and indices must be in their tables.
+func (p *GlobalSave) Validate(a *ain.AIN) (err error) {
+	globals := make(map[string]*ain.Global)
+	for i := range a.Globals {
+		globals[a.Globals[i].Name] = &a.Globals[i]
+	}
+	structs := make(map[string]*ain.Struct)
+	for i := range a.Structs {
+		structs[a.Structs[i].Name] = &a.Structs[i]
+	}
+
+	for i, g := range p.Globals {
+		if err = p.checkRef(g.Type, g.Value); err != nil {
+			return fmt.Errorf("global %d %s: %w", i, g.Name, err)
+		}
+		if i >= p.AINGlobals || g.Name == "" {
+			// a struct member, or a global without the name
+			continue
+		}
+		ag := globals[g.Name]
+		if ag == nil {
+			return fmt.Errorf("global %s is not in the AIN", g.Name)
+		}
+		if ag.Type.Data != g.Type {
+			return fmt.Errorf("global %s has type %d, but %d in the AIN", g.Name, g.Type, ag.Type.Data)
+		}
+	}
+	for i, rec := range p.Records {
+		s := structs[rec.StructName]
+		if s == nil {
+			return fmt.Errorf("record %d: struct %s is not in the AIN", i, rec.StructName)
+		}
+		if len(rec.Indices) != len(s.Members) {
+			return fmt.Errorf("record %d: %d values for %d members of struct %s", i, len(rec.Indices), len(s.Members), s.Name)
+		}
+		for j, idx := range rec.Indices {
+			if idx < 0 || int(idx) >= len(p.Globals) {
+				return fmt.Errorf("record %d: invalid global index %d", i, idx)
+			}
+			if g := p.Globals[idx]; g.Type != s.Members[j].Type.Data {
+				return fmt.Errorf("record %d: member %s has type %d, but %d in the AIN", i, s.Members[j].Name, g.Type, s.Members[j].Type.Data)
+			}
+		}
+	}
+	for i, arr := range p.Arrays {
+		for _, flat := range arr.Flat {
+			for _, v := range flat {
+				if err = p.checkRef(v.Type, v.Value); err != nil {
+					return fmt.Errorf("array %d: %w", i, err)
+				}
+			}
+		}
+	}
+	return
+}
+
+// little-endian reader of the payload
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) int32() int32 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+4 > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := int32(binary.LittleEndian.Uint32(r.b[r.pos:]))
+	r.pos += 4
+	return v
+}
+
+// read a count of items of at least minSize bytes
+func (r *reader) count(minSize int) int {
+	return r.check(int(r.int32()), minSize)
+}
+
+// check a count of items of at least minSize bytes against the rest of the payload
+func (r *reader) check(n, minSize int) int {
+	if r.err == nil && (n < 0 || n > (len(r.b)-r.pos)/minSize) {
+		r.err = fmt.Errorf("invalid count %d", n)
+		return 0
+	}
+	return n
+}
+
+// read a zero-terminated ShiftJIS string
+func (r *reader) str() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.b[r.pos:], 0)
+	if i < 0 {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	b := r.b[r.pos : r.pos+i]
+	r.pos += i + 1
+	s, err := japanese.ShiftJIS.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
+
+// little-endian writer of the payload, with a sticky error
+type writer struct {
+	bytes.Buffer
+	err error
+}
+
+func (w *writer) int32(v int32) {
+	binary.Write(&w.Buffer, binary.LittleEndian, v)
+}
+
+func (w *writer) str(s string) {
+	b, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(s))
+	if err != nil || bytes.IndexByte(b, 0) >= 0 {
+		if w.err == nil {
+			w.err = fmt.Errorf("string %q is not representable in ShiftJIS", s)
+		}
+		return
+	}
+	w.Write(b)
+	w.WriteByte(0)
+}
@@ -0,0 +1,395 @@
+package aliceafa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAudio = errors.New("invalid audio data")
+)
+
+// Properties of an audio content.
+type AudioInfo struct {
+	Type       ContentType // ContentOGG, ContentWAV or ContentMP3
+	Codec      string      // "vorbis", "opus", "pcm", "float", "mp3", or "format 0x...." for other WAV formats
+	SampleRate int
+	Channels   int
+	Bits       int      `json:",omitempty"` // bits per sample of WAV
+	Samples    int64    // length in samples per channel. estimated from the bitrate for MP3
+	Duration   float64  // length in seconds
+	Looped     bool     // whether the loop points are given
+	LoopStart  int64    `json:",omitempty"` // start of the loop in samples
+	LoopLength int64    `json:",omitempty"` // length of the loop in samples
+	Comments   []string `json:",omitempty"` // Vorbis comments of OGG, as "KEY=value"
+}
+
+// Read the properties of an audio content of the size.
+// Loop points are read from LOOPSTART and LOOPLENGTH comments of OGG, and the first loop of the smpl chunk of WAV.
+func ReadAudioInfo(r io.ReaderAt, size int64) (info *AudioInfo, err error) {
+	header := make([]byte, ContentSniffLen)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return
+	}
+	header = header[:n]
+	switch DetectContentType(header) {
+	case ContentOGG:
+		info, err = readOggInfo(r, size)
+	case ContentWAV:
+		info, err = readWAVInfo(r, size)
+	case ContentMP3:
+		info, err = readMP3Info(r, size, true)
+	default:
+		// MP3 without an ID3 tag
+		info, err = readMP3Info(r, size, false)
+		if err != nil {
+			return nil, ErrInvalidAudio
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.SampleRate > 0 {
+		info.Duration = float64(info.Samples) / float64(info.SampleRate)
+	}
+	return
+}
+
+// an OGG page
+type oggPage struct {
+	HeaderType byte
+	Granule    int64
+	Serial     uint32
+	Segments   []byte // segment table
+	Body       []byte
+}
+
+const oggHeaderSize = 27
+
+// read an OGG page at off. returns the offset of the next page.
+func readOggPage(r io.ReaderAt, off int64) (p oggPage, next int64, err error) {
+	var hdr [oggHeaderSize]byte
+	_, err = r.ReadAt(hdr[:], off)
+	if err != nil {
+		return
+	}
+	if string(hdr[0:4]) != "OggS" || hdr[4] != 0 {
+		return p, 0, ErrInvalidAudio
+	}
+	p.HeaderType = hdr[5]
+	p.Granule = int64(binary.LittleEndian.Uint64(hdr[6:]))
+	p.Serial = binary.LittleEndian.Uint32(hdr[14:])
+	p.Segments = make([]byte, hdr[26])
+	_, err = r.ReadAt(p.Segments, off+oggHeaderSize)
+	if err != nil {
+		return
+	}
+	bodySize := 0
+	for _, s := range p.Segments {
+		bodySize += int(s)
+	}
+	p.Body = make([]byte, bodySize)
+	_, err = r.ReadAt(p.Body, off+oggHeaderSize+int64(len(p.Segments)))
+	if err != nil {
+		return
+	}
+	next = off + oggHeaderSize + int64(len(p.Segments)) + int64(bodySize)
+	return
+}
+
+// read the first n packets of the first logical stream
+func readOggPackets(r io.ReaderAt, size int64, n int) (serial uint32, packets [][]byte, err error) {
+	const maxPages = 256 // header packets are in the first pages
+	var cur []byte
+	off := int64(0)
+	for i := 0; i < maxPages && off < size && len(packets) < n; i++ {
+		var p oggPage
+		p, off, err = readOggPage(r, off)
+		if err != nil {
+			return
+		}
+		if i == 0 {
+			serial = p.Serial
+		} else if p.Serial != serial {
+			continue
+		}
+		pos := 0
+		for _, s := range p.Segments {
+			cur = append(cur, p.Body[pos:pos+int(s)]...)
+			pos += int(s)
+			if s < 255 {
+				packets = append(packets, cur)
+				cur = nil
+				if len(packets) == n {
+					break
+				}
+			}
+		}
+	}
+	if len(packets) < n {
+		err = ErrInvalidAudio
+	}
+	return
+}
+
+// granule position of the last page of the stream
+func lastOggGranule(r io.ReaderAt, size int64, serial uint32) (granule int64, err error) {
+	const tailSize = 65536 // an OGG page is at most 65307 bytes
+	start := size - tailSize
+	if start < 0 {
+		start = 0
+	}
+	tail := make([]byte, size-start)
+	_, err = r.ReadAt(tail, start)
+	if err != nil && err != io.EOF {
+		return
+	}
+	err = nil
+	for end := len(tail); end > 0; {
+		i := bytes.LastIndex(tail[:end], []byte("OggS"))
+		if i < 0 {
+			break
+		}
+		end = i
+		if i+oggHeaderSize > len(tail) || tail[i+4] != 0 {
+			continue
+		}
+		g := int64(binary.LittleEndian.Uint64(tail[i+6:]))
+		if binary.LittleEndian.Uint32(tail[i+14:]) == serial && g >= 0 {
+			return g, nil
+		}
+	}
+	return 0, ErrInvalidAudio
+}
+
+// parse comments of Vorbis or Opus: vendor string, then a list of strings
+func parseVorbisComments(b []byte) (comments []string, err error) {
+	next := func() (s string, ok bool) {
+		if len(b) < 4 {
+			return
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return
+		}
+		s, b = string(b[4:4+n]), b[4+n:]
+		return s, true
+	}
+	if _, ok := next(); !ok { // vendor
+		return nil, ErrInvalidAudio
+	}
+	if len(b) < 4 {
+		return nil, ErrInvalidAudio
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		s, ok := next()
+		if !ok {
+			return nil, ErrInvalidAudio
+		}
+		comments = append(comments, s)
+	}
+	return
+}
+
+func readOggInfo(r io.ReaderAt, size int64) (info *AudioInfo, err error) {
+	serial, packets, err := readOggPackets(r, size, 2)
+	if err != nil {
+		return
+	}
+	info = &AudioInfo{Type: ContentOGG}
+	head, tags := packets[0], packets[1]
+	preSkip := int64(0)
+	switch {
+	case len(head) >= 16 && string(head[0:7]) == "\x01vorbis" && len(tags) >= 7 && string(tags[0:7]) == "\x03vorbis":
+		info.Codec = "vorbis"
+		info.Channels = int(head[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(head[12:]))
+		info.Comments, err = parseVorbisComments(tags[7:])
+	case len(head) >= 19 && string(head[0:8]) == "OpusHead" && len(tags) >= 8 && string(tags[0:8]) == "OpusTags":
+		info.Codec = "opus"
+		info.Channels = int(head[9])
+		info.SampleRate = 48000 // granule positions of Opus are always in 48kHz
+		preSkip = int64(binary.LittleEndian.Uint16(head[10:]))
+		info.Comments, err = parseVorbisComments(tags[8:])
+	default:
+		return nil, fmt.Errorf("unsupported OGG codec")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	granule, err := lastOggGranule(r, size, serial)
+	if err != nil {
+		return nil, err
+	}
+	info.Samples = granule - preSkip
+	if info.Samples < 0 {
+		info.Samples = 0
+	}
+
+	// loop points
+	hasStart, hasLength := false, false
+	for _, c := range info.Comments {
+		k, v, ok := strings.Cut(c, "=")
+		if !ok {
+			continue
+		}
+		n, e := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if e != nil {
+			continue
+		}
+		switch strings.ToUpper(k) {
+		case "LOOPSTART":
+			info.LoopStart, hasStart = n, true
+		case "LOOPLENGTH":
+			info.LoopLength, hasLength = n, true
+		}
+	}
+	if hasStart {
+		info.Looped = true
+		if !hasLength {
+			// loop to the end
+			info.LoopLength = info.Samples - info.LoopStart
+		}
+	}
+	return
+}
+
+func readWAVInfo(r io.ReaderAt, size int64) (info *AudioInfo, err error) {
+	info = &AudioInfo{Type: ContentWAV}
+	blockAlign, dataSize := 0, int64(-1)
+	var hdr [8]byte
+	for off := int64(12); off+8 <= size; {
+		_, err = r.ReadAt(hdr[:], off)
+		if err != nil {
+			return nil, err
+		}
+		id, chunkSize := string(hdr[0:4]), int64(binary.LittleEndian.Uint32(hdr[4:]))
+		off += 8
+		if off+chunkSize > size {
+			if id != "data" {
+				return nil, ErrInvalidAudio
+			}
+			chunkSize = size - off // truncated data chunk
+		}
+		switch id {
+		case "fmt ":
+			if chunkSize < 16 {
+				return nil, ErrInvalidAudio
+			}
+			var fmtChunk [16]byte
+			_, err = r.ReadAt(fmtChunk[:], off)
+			if err != nil {
+				return nil, err
+			}
+			format := binary.LittleEndian.Uint16(fmtChunk[0:])
+			switch format {
+			case 1:
+				info.Codec = "pcm"
+			case 3:
+				info.Codec = "float"
+			case 0x55:
+				info.Codec = "mp3"
+			default:
+				info.Codec = fmt.Sprintf("format 0x%04x", format)
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:]))
+			blockAlign = int(binary.LittleEndian.Uint16(fmtChunk[12:]))
+			info.Bits = int(binary.LittleEndian.Uint16(fmtChunk[14:]))
+		case "data":
+			dataSize = chunkSize
+		case "smpl":
+			// sampler chunk: 9 fields of 32 bits, then the loops of 6 fields
+			if chunkSize < 36 {
+				return nil, ErrInvalidAudio
+			}
+			smpl := make([]byte, chunkSize)
+			_, err = r.ReadAt(smpl, off)
+			if err != nil {
+				return nil, err
+			}
+			numLoops := binary.LittleEndian.Uint32(smpl[28:])
+			if numLoops > 0 && chunkSize >= 36+24 {
+				start := int64(binary.LittleEndian.Uint32(smpl[36+8:]))
+				end := int64(binary.LittleEndian.Uint32(smpl[36+12:])) // inclusive
+				info.Looped = true
+				info.LoopStart = start
+				info.LoopLength = end - start + 1
+			}
+		}
+		off += chunkSize + chunkSize&1 // chunks are padded to even size
+	}
+	if info.Codec == "" || dataSize < 0 {
+		return nil, ErrInvalidAudio
+	}
+	if blockAlign > 0 {
+		info.Samples = dataSize / int64(blockAlign)
+	}
+	return
+}
+
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG1 layer III
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG2/2.5 layer III
+	}
+	mp3SampleRates = [3]int{44100, 48000, 32000} // MPEG1
+)
+
+// read the first frame header of MP3. the length is estimated from the bitrate of the first frame.
+func readMP3Info(r io.ReaderAt, size int64, hasID3 bool) (info *AudioInfo, err error) {
+	off := int64(0)
+	if hasID3 {
+		var id3 [10]byte
+		_, err = r.ReadAt(id3[:], 0)
+		if err != nil {
+			return
+		}
+		// syncsafe size of the tag
+		off = 10 + (int64(id3[6]&0x7f)<<21 | int64(id3[7]&0x7f)<<14 | int64(id3[8]&0x7f)<<7 | int64(id3[9]&0x7f))
+		if id3[5]&0x10 != 0 { // footer
+			off += 10
+		}
+	}
+	var hdr [4]byte
+	_, err = r.ReadAt(hdr[:], off)
+	if err != nil {
+		return
+	}
+	if hdr[0] != 0xff || hdr[1]&0xe0 != 0xe0 {
+		return nil, ErrInvalidAudio
+	}
+	version, layer := (hdr[1]>>3)&3, (hdr[1]>>1)&3
+	bitrateIndex, rateIndex := hdr[2]>>4, (hdr[2]>>2)&3
+	if version == 1 || layer != 1 || rateIndex == 3 {
+		// reserved version, or not layer III
+		return nil, ErrInvalidAudio
+	}
+	info = &AudioInfo{Type: ContentMP3, Codec: "mp3", Channels: 2}
+	info.SampleRate = mp3SampleRates[rateIndex]
+	bitrate := mp3Bitrates[0][bitrateIndex]
+	switch version {
+	case 2: // MPEG2
+		info.SampleRate /= 2
+		bitrate = mp3Bitrates[1][bitrateIndex]
+	case 0: // MPEG2.5
+		info.SampleRate /= 4
+		bitrate = mp3Bitrates[1][bitrateIndex]
+	}
+	if hdr[3]>>6 == 3 {
+		info.Channels = 1
+	}
+	if bitrate > 0 {
+		info.Samples = (size - off) * 8 * int64(info.SampleRate) / int64(bitrate*1000)
+	}
+	return
+}
@@ -0,0 +1,150 @@
+package aliceafa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// an OGG page with a single packet
+func testOggPage(serial uint32, granule int64, packet []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("OggS")
+	buf.Write([]byte{0, 0})
+	binary.Write(&buf, binary.LittleEndian, granule)
+	binary.Write(&buf, binary.LittleEndian, serial)
+	buf.Write(make([]byte, 8)) // sequence number and CRC
+	var segs []byte
+	n := len(packet)
+	for ; n >= 255; n -= 255 {
+		segs = append(segs, 255)
+	}
+	segs = append(segs, byte(n))
+	buf.WriteByte(byte(len(segs)))
+	buf.Write(segs)
+	buf.Write(packet)
+	return buf.Bytes()
+}
+
+// Vorbis comment packet
+func testVorbisComments(prefix string, comments ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+	str := func(s string) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	str("test vendor")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		str(c)
+	}
+	return buf.Bytes()
+}
+
+func TestAudioInfoOGG(t *testing.T) {
+	var ident bytes.Buffer
+	ident.WriteString("\x01vorbis")
+	binary.Write(&ident, binary.LittleEndian, uint32(0))     // version
+	ident.WriteByte(2)                                       // channels
+	binary.Write(&ident, binary.LittleEndian, uint32(44100)) // rate
+	ident.Write(make([]byte, 14))
+
+	var ogg bytes.Buffer
+	ogg.Write(testOggPage(7, 0, ident.Bytes()))
+	ogg.Write(testOggPage(7, 0, testVorbisComments("\x03vorbis", "TITLE=bgm", "LOOPSTART=1000", "looplength=43100", string(bytes.Repeat([]byte("x"), 300)))))
+	ogg.Write(testOggPage(7, 22050, make([]byte, 100)))
+	ogg.Write(testOggPage(7, 88200, make([]byte, 100)))
+	data := ogg.Bytes()
+
+	info, err := ReadAudioInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != ContentOGG || info.Codec != "vorbis" || info.Channels != 2 || info.SampleRate != 44100 ||
+		info.Samples != 88200 || info.Duration != 2 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if !info.Looped || info.LoopStart != 1000 || info.LoopLength != 43100 || len(info.Comments) != 4 {
+		t.Errorf("unexpected loop: %+v", info)
+	}
+
+	// Opus, loop to the end
+	var head bytes.Buffer
+	head.WriteString("OpusHead")
+	head.Write([]byte{1, 1})
+	binary.Write(&head, binary.LittleEndian, uint16(312))   // pre-skip
+	binary.Write(&head, binary.LittleEndian, uint32(44100)) // input rate
+	head.Write([]byte{0, 0, 0})
+	ogg.Reset()
+	ogg.Write(testOggPage(3, 0, head.Bytes()))
+	ogg.Write(testOggPage(3, 0, testVorbisComments("OpusTags", "LOOPSTART=48000")))
+	ogg.Write(testOggPage(3, 96312, make([]byte, 10)))
+	data = ogg.Bytes()
+	info, err = ReadAudioInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Codec != "opus" || info.Channels != 1 || info.SampleRate != 48000 || info.Samples != 96000 ||
+		!info.Looped || info.LoopStart != 48000 || info.LoopLength != 48000 {
+		t.Errorf("unexpected opus info: %+v", info)
+	}
+}
+
+func TestAudioInfoWAV(t *testing.T) {
+	chunk := func(buf *bytes.Buffer, id string, body []byte) {
+		buf.WriteString(id)
+		binary.Write(buf, binary.LittleEndian, uint32(len(body)))
+		buf.Write(body)
+		if len(body)%2 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	var fmtChunk bytes.Buffer
+	binary.Write(&fmtChunk, binary.LittleEndian, []uint16{1, 2})
+	binary.Write(&fmtChunk, binary.LittleEndian, []uint32{22050, 22050 * 4})
+	binary.Write(&fmtChunk, binary.LittleEndian, []uint16{4, 16})
+	var smpl bytes.Buffer
+	binary.Write(&smpl, binary.LittleEndian, []uint32{0, 0, 45351, 60, 0, 0, 0, 1, 0})
+	binary.Write(&smpl, binary.LittleEndian, []uint32{0, 0, 100, 11124, 0, 0})
+
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	chunk(&body, "fmt ", fmtChunk.Bytes())
+	chunk(&body, "LIST", []byte("odd"))
+	chunk(&body, "data", make([]byte, 22050*4*2))
+	chunk(&body, "smpl", smpl.Bytes())
+	var wav bytes.Buffer
+	chunk(&wav, "RIFF", body.Bytes())
+	data := wav.Bytes()
+
+	info, err := ReadAudioInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != ContentWAV || info.Codec != "pcm" || info.Channels != 2 || info.SampleRate != 22050 || info.Bits != 16 ||
+		info.Samples != 44100 || info.Duration != 2 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if !info.Looped || info.LoopStart != 100 || info.LoopLength != 11025 {
+		t.Errorf("unexpected loop: %+v", info)
+	}
+}
+
+func TestAudioInfoMP3(t *testing.T) {
+	// MPEG1 layer III, 128kbps, 44.1kHz, mono, 1 second of data after a 16-byte ID3 tag
+	data := make([]byte, 10+6+16000)
+	copy(data, []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 6})
+	copy(data[16:], []byte{0xff, 0xfb, 0x90, 0xc0})
+	info, err := ReadAudioInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != ContentMP3 || info.SampleRate != 44100 || info.Channels != 1 || info.Samples != 44100 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	if _, err := ReadAudioInfo(bytes.NewReader([]byte("not an audio")), 12); err == nil {
+		t.Errorf("non-audio data must fail")
+	}
+}
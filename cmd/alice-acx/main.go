@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mixcode/alicesoft-afa/acx"
+)
+
+// flags
+var (
+	version   = 0
+	outFile   = ""
+	overwrite = false
+)
+
+// stdout as the output; the tool does not close it
+type stdout struct{ io.Writer }
+
+func (stdout) Close() error { return nil }
+
+// open the output file, or stdout if the name is empty
+func createOutput(name string) (w io.WriteCloser, err error) {
+	if name == "" || name == "-" {
+		return stdout{os.Stdout}, nil
+	}
+	if !overwrite {
+		if _, e := os.Stat(name); e == nil {
+			return nil, fmt.Errorf("file %s exists", name)
+		}
+	}
+	return os.Create(name)
+}
+
+func run() (err error) {
+	args := flag.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("input filename not given (use -help for help)")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return
+	}
+
+	if bytes.HasPrefix(data, []byte("ACX\x00")) {
+		// .acx to CSV
+		var a *acx.ACX
+		a, err = acx.Decode(data)
+		if err != nil {
+			return
+		}
+		var w io.WriteCloser
+		w, err = createOutput(outFile)
+		if err != nil {
+			return
+		}
+		err = a.WriteCSV(w)
+		if e := w.Close(); err == nil {
+			err = e
+		}
+		return
+	}
+
+	// CSV to .acx
+	if outFile == "" {
+		return fmt.Errorf("output filename not given")
+	}
+	a, err := acx.ReadCSV(bytes.NewReader(data), version)
+	if err != nil {
+		return
+	}
+	acxData, err := a.Encode()
+	if err != nil {
+		return
+	}
+	w, err := createOutput(outFile)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(acxData)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func main() {
+	var err error
+
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "%s: convert AliceSoft System 4 .acx file to CSV, or CSV to .acx\n", os.Args[0])
+		fmt.Fprintf(o, "usage: %s [flags] InputFile\n", os.Args[0])
+		fmt.Fprintf(o, "flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.IntVar(&version, "version", version, "version number of the .acx header written from CSV")
+	flag.StringVar(&outFile, "o", outFile, "output `file`. default is stdout for CSV")
+	flag.BoolVar(&overwrite, "f", overwrite, "force overwrite existing files")
+
+	flag.Parse()
+
+	err = run()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
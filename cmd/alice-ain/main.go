@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mixcode/alicesoft-afa/ain"
+)
+
+// flags
+var (
+	disasm     = false
+	asmFile    = ""
+	exportText = false
+	importFile = ""
+	outFile    = ""
+	overwrite  = false
+)
+
+// stdout as the output; the tool does not close it
+type stdout struct{ io.Writer }
+
+func (stdout) Close() error { return nil }
+
+// open the output file, or stdout if the name is empty
+func createOutput(name string) (w io.WriteCloser, err error) {
+	if name == "" || name == "-" {
+		return stdout{os.Stdout}, nil
+	}
+	if !overwrite {
+		if _, e := os.Stat(name); e == nil {
+			return nil, fmt.Errorf("file %s exists", name)
+		}
+	}
+	return os.Create(name)
+}
+
+// show sections and counts of the AIN
+func showInfo(a *ain.AIN) {
+	fmt.Printf("version %d, %s\n", a.Version, a.Container)
+	for _, sec := range a.Sections {
+		fmt.Printf("%s\t0x%08x\t%d bytes\n", sec.Tag, sec.Offset, len(sec.Data))
+	}
+	fmt.Printf("%d functions, %d globals, %d structs, %d messages, %d strings, %d libraries\n",
+		len(a.Functions), len(a.Globals), len(a.Structs), len(a.Messages), len(a.Strings), len(a.Libraries))
+}
+
+// read a file and apply it to the AIN
+func applyFile(name string, apply func(r io.Reader) error) (err error) {
+	fi, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer fi.Close()
+	return apply(fi)
+}
+
+func run() (err error) {
+	args := flag.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("AIN filename not given (use -help for help)")
+	}
+
+	fi, err := os.Open(args[0])
+	if err != nil {
+		return
+	}
+	a, err := ain.Read(fi)
+	fi.Close()
+	if err != nil {
+		return
+	}
+
+	if asmFile == "" && importFile == "" {
+		// output modes
+		if !disasm && !exportText {
+			showInfo(a)
+			return
+		}
+		var w io.WriteCloser
+		w, err = createOutput(outFile)
+		if err != nil {
+			return
+		}
+		if disasm {
+			err = a.Disassemble(w)
+		} else {
+			err = a.ExportText(w)
+		}
+		if e := w.Close(); err == nil {
+			err = e
+		}
+		return
+	}
+
+	// modify the AIN
+	if asmFile != "" {
+		err = applyFile(asmFile, a.Assemble)
+		if err != nil {
+			return
+		}
+	}
+	if importFile != "" {
+		err = applyFile(importFile, func(r io.Reader) error {
+			_, e := a.ImportText(r)
+			return e
+		})
+		if err != nil {
+			return
+		}
+	}
+	if outFile == "" {
+		return fmt.Errorf("output filename not given")
+	}
+	data, err := a.Encode()
+	if err != nil {
+		return
+	}
+	w, err := createOutput(outFile)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func main() {
+	var err error
+
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "%s: inspect, disassemble and patch AliceSoft System 4 AIN files\n", os.Args[0])
+		fmt.Fprintf(o, "usage: %s [flags] AINFile\n", os.Args[0])
+		fmt.Fprintf(o, "  without flags, show the sections of the file\n")
+		fmt.Fprintf(o, "flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.BoolVar(&disasm, "d", disasm, "disassemble the code")
+	flag.StringVar(&asmFile, "a", asmFile, "assemble the listing `file` and replace the code")
+	flag.BoolVar(&exportText, "x", exportText, "export messages and strings as text")
+	flag.StringVar(&importFile, "i", importFile, "import translated messages and strings from the text `file`")
+	flag.StringVar(&outFile, "o", outFile, "output `file`. default is stdout for -d and -x")
+	flag.BoolVar(&overwrite, "f", overwrite, "force overwrite existing files")
+
+	flag.Parse()
+
+	err = run()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
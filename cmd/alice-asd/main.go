@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mixcode/alicesoft-afa/ain"
+	"github.com/mixcode/alicesoft-afa/asd"
+)
+
+// flags
+var (
+	fromJSON  = false
+	ainFile   = ""
+	outFile   = ""
+	overwrite = false
+)
+
+// JSON dump of a save file.
+// Payload is set only if the payload is not a global save.
+type dump struct {
+	Signature string
+	Version   int
+	Globals   *asd.GlobalSave `json:",omitempty"`
+	Payload   []byte          `json:",omitempty"`
+}
+
+// stdout as the output; the tool does not close it
+type stdout struct{ io.Writer }
+
+func (stdout) Close() error { return nil }
+
+// open the output file, or stdout if the name is empty
+func createOutput(name string) (w io.WriteCloser, err error) {
+	if name == "" || name == "-" {
+		return stdout{os.Stdout}, nil
+	}
+	if !overwrite {
+		if _, e := os.Stat(name); e == nil {
+			return nil, fmt.Errorf("file %s exists", name)
+		}
+	}
+	return os.Create(name)
+}
+
+// decode the save file to JSON
+func saveToJSON(data []byte) (err error) {
+	save, err := asd.Decode(data)
+	if err != nil {
+		return
+	}
+	d := dump{Signature: save.Signature, Version: save.Version}
+	d.Globals, err = save.DecodeGlobals()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "payload is not a global save: %v\n", err)
+		d.Payload, err = save.Payload, nil
+	}
+	if d.Globals != nil && ainFile != "" {
+		var a *ain.AIN
+		a, err = readAIN(ainFile)
+		if err != nil {
+			return
+		}
+		err = d.Globals.Validate(a)
+		if err != nil {
+			return
+		}
+	}
+
+	w, err := createOutput(outFile)
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(&d)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// encode JSON to a save file
+func jsonToSave(data []byte) (err error) {
+	if outFile == "" {
+		return fmt.Errorf("output filename not given")
+	}
+	var d dump
+	err = json.Unmarshal(data, &d)
+	if err != nil {
+		return
+	}
+	save := &asd.ASD{Signature: d.Signature, Version: d.Version, Payload: d.Payload}
+	if d.Globals != nil {
+		if ainFile != "" {
+			var a *ain.AIN
+			a, err = readAIN(ainFile)
+			if err != nil {
+				return
+			}
+			err = d.Globals.Validate(a)
+			if err != nil {
+				return
+			}
+		}
+		err = save.SetGlobals(d.Globals)
+		if err != nil {
+			return
+		}
+	}
+	saveData, err := save.Encode()
+	if err != nil {
+		return
+	}
+	w, err := createOutput(outFile)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(saveData)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func readAIN(name string) (a *ain.AIN, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return ain.Read(f)
+}
+
+func run() (err error) {
+	args := flag.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("input filename not given (use -help for help)")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return
+	}
+	if fromJSON {
+		return jsonToSave(data)
+	}
+	return saveToJSON(data)
+}
+
+func main() {
+	var err error
+
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "%s: dump AliceSoft System 4 .asd save data as JSON, or create save data from JSON\n", os.Args[0])
+		fmt.Fprintf(o, "usage: %s [flags] InputFile\n", os.Args[0])
+		fmt.Fprintf(o, "flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.BoolVar(&fromJSON, "j", fromJSON, "the input is JSON; create save data")
+	flag.StringVar(&ainFile, "ain", ainFile, "AIN `file` of the game to validate the globals")
+	flag.StringVar(&outFile, "o", outFile, "output `file`. default is stdout for JSON")
+	flag.BoolVar(&overwrite, "f", overwrite, "force overwrite existing files")
+
+	flag.Parse()
+
+	err = run()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
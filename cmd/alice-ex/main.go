@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mixcode/alicesoft-afa/ex"
+)
+
+// flags
+var (
+	keyFile   = ""
+	outFile   = ""
+	overwrite = false
+)
+
+// stdout as the output; the tool does not close it
+type stdout struct{ io.Writer }
+
+func (stdout) Close() error { return nil }
+
+// open the output file, or stdout if the name is empty
+func createOutput(name string) (w io.WriteCloser, err error) {
+	if name == "" || name == "-" {
+		return stdout{os.Stdout}, nil
+	}
+	if !overwrite {
+		if _, e := os.Stat(name); e == nil {
+			return nil, fmt.Errorf("file %s exists", name)
+		}
+	}
+	return os.Create(name)
+}
+
+func run() (err error) {
+	args := flag.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("input filename not given (use -help for help)")
+	}
+
+	if keyFile != "" {
+		var key []byte
+		key, err = os.ReadFile(keyFile)
+		if err != nil {
+			return
+		}
+		err = ex.SetKeyTable(key)
+		if err != nil {
+			return
+		}
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return
+	}
+
+	if bytes.HasPrefix(data, []byte("HEAD")) {
+		// .ex to JSON
+		var e *ex.EX
+		e, err = ex.Decode(data)
+		if err != nil {
+			return
+		}
+		var w io.WriteCloser
+		w, err = createOutput(outFile)
+		if err != nil {
+			return
+		}
+		err = e.WriteJSON(w)
+		if e := w.Close(); err == nil {
+			err = e
+		}
+		return
+	}
+
+	// JSON to .ex
+	if outFile == "" {
+		return fmt.Errorf("output filename not given")
+	}
+	e, err := ex.ReadJSON(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	exData, err := e.Encode()
+	if err != nil {
+		return
+	}
+	w, err := createOutput(outFile)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(exData)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func main() {
+	var err error
+
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "%s: convert AliceSoft System 4 .ex file to JSON, or JSON to .ex\n", os.Args[0])
+		fmt.Fprintf(o, "usage: %s [flags] InputFile\n", os.Args[0])
+		fmt.Fprintf(o, "flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&keyFile, "key", keyFile, "256-byte substitution table `file` of the .ex cipher")
+	flag.StringVar(&outFile, "o", outFile, "output `file`. default is stdout for JSON")
+	flag.BoolVar(&overwrite, "f", overwrite, "force overwrite existing files")
+
+	flag.Parse()
+
+	err = run()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mixcode/alicesoft-afa/fnl"
+)
+
+// flags
+var (
+	listOnly  = false
+	outDir    = ""
+	overwrite = false
+)
+
+// create an output file
+func createOutput(name string) (f *os.File, err error) {
+	if !overwrite {
+		if _, e := os.Stat(name); e == nil {
+			return nil, fmt.Errorf("file %s exists", name)
+		}
+	}
+	return os.Create(name)
+}
+
+// write the glyph atlas and the BDF of a face
+func exportFace(face *fnl.Face, base string) (err error) {
+	atlas, _, err := face.Atlas()
+	if err != nil {
+		return
+	}
+	f, err := createOutput(base + ".png")
+	if err != nil {
+		return
+	}
+	err = png.Encode(f, atlas)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+
+	f, err = createOutput(base + ".bdf")
+	if err != nil {
+		return
+	}
+	err = face.WriteBDF(f, filepath.Base(base))
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func run() (err error) {
+	args := flag.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("input filename not given (use -help for help)")
+	}
+
+	r, err := os.Open(args[0])
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	font, err := fnl.Read(r)
+	if err != nil {
+		return
+	}
+
+	name := strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+	for i, f := range font.Fonts {
+		for _, face := range f.Faces {
+			base := fmt.Sprintf("%s_%d_%d", name, i, face.Height)
+			fmt.Printf("%s: font %d, height %d, %d glyphs\n", base, i, face.Height, len(face.Codes()))
+			if listOnly {
+				continue
+			}
+			err = exportFace(face, filepath.Join(outDir, base))
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func main() {
+	var err error
+
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "%s: export the faces of an AliceSoft .fnl font as glyph-atlas PNG and BDF files\n", os.Args[0])
+		fmt.Fprintf(o, "usage: %s [flags] InputFile\n", os.Args[0])
+		fmt.Fprintf(o, "output files are named as <name>_<font>_<height>.png and .bdf\n")
+		fmt.Fprintf(o, "flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.BoolVar(&listOnly, "ls", listOnly, "list the faces only")
+	flag.StringVar(&outDir, "outdir", outDir, "output `directory`")
+	flag.BoolVar(&overwrite, "f", overwrite, "force overwrite existing files")
+
+	flag.Parse()
+
+	err = run()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+	"github.com/mixcode/alicesoft-afa/reign"
+)
+
+// flags
+var (
+	outFile   = ""
+	quiet     = false
+	overwrite = false
+	motions   stringList
+)
+
+// create an output file
+func createOutput(name string) (f *os.File, err error) {
+	if !overwrite {
+		if _, e := os.Stat(name); e == nil {
+			return nil, fmt.Errorf("file %s exists", name)
+		}
+	}
+	return os.Create(name)
+}
+
+// open an AFA or ALD archive
+func openArchive(f *os.File) (arch *aliceafa.AliceArch, err error) {
+	if strings.ToLower(filepath.Ext(f.Name())) == ".ald" {
+		return aliceafa.OpenALD(f)
+	}
+	arch, err = aliceafa.OpenAFA(f)
+	if err != nil {
+		arch, err = aliceafa.OpenALD(f)
+	}
+	return
+}
+
+// list of strings given by a repeated flag
+type stringList []string
+
+func (p *stringList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *stringList) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+// source of the model, its textures and motions: an archive, or files on disk
+type source struct {
+	f    *os.File
+	arch *aliceafa.AliceArch // nil for files on disk
+}
+
+// open the source. returns the name of the model.
+func openSource(args []string) (src *source, modelName string, err error) {
+	if len(args) == 1 {
+		return &source{}, args[0], nil
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return
+	}
+	arch, err := openArchive(f)
+	if err != nil {
+		f.Close()
+		return
+	}
+	return &source{f: f, arch: arch}, args[1], nil
+}
+
+func (p *source) Close() {
+	if p.f != nil {
+		p.f.Close()
+	}
+}
+
+// read a file. an archive entry is matched case-insensitively, and also searched in the directory of the model.
+// returns the name of the file found.
+func (p *source) read(name, modelName string) (data []byte, found string, err error) {
+	if p.arch == nil {
+		data, err = os.ReadFile(name)
+		return data, name, err
+	}
+	names := []string{name}
+	if i := strings.LastIndexAny(modelName, "\\/"); i >= 0 {
+		names = append(names, modelName[:i+1]+name)
+	}
+	for _, n := range names {
+		for i, e := range p.arch.Entry {
+			if strings.EqualFold(e.Name, n) {
+				data, err = p.arch.Read(p.f, i)
+				return data, e.Name, err
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("%s is not in the archive", name)
+}
+
+// resolver of the textures of the model
+func (p *source) resolver(modelName string) (resolver aliceafa.ImageResolver, err error) {
+	if p.arch == nil {
+		return aliceafa.NewDirResolver(filepath.Dir(modelName))
+	}
+	return reign.TextureResolver(aliceafa.NewArchiveResolver(p.arch, p.f), modelName), nil
+}
+
+func run() (err error) {
+	args := flag.Args()
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("input filename not given (use -help for help)")
+	}
+
+	src, name, err := openSource(args)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	data, name, err := src.read(name, "")
+	if err != nil {
+		return
+	}
+	model, err := reign.DecodeModel(data)
+	if err != nil {
+		return
+	}
+	resolver, err := src.resolver(name)
+	if err != nil {
+		return
+	}
+
+	ctx := &aliceafa.DecodeContext{Name: name, Resolver: resolver}
+	if !quiet {
+		ctx.Warn = func(err error) { fmt.Fprintln(os.Stderr, err) }
+	}
+	g, err := model.GLTF(ctx)
+	if err != nil {
+		return
+	}
+
+	// motions
+	for _, m := range motions {
+		var motName string
+		data, motName, err = src.read(m, name)
+		if err != nil {
+			return
+		}
+		var mot *reign.Motion
+		mot, err = reign.DecodeMotion(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", motName, err)
+		}
+		base := filepath.Base(strings.ReplaceAll(motName, "\\", "/"))
+		err = g.AddMotion(strings.TrimSuffix(base, filepath.Ext(base)), mot)
+		if err != nil {
+			return
+		}
+	}
+
+	if outFile == "" {
+		base := filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+		outFile = strings.TrimSuffix(base, filepath.Ext(base)) + ".glb"
+	}
+	f, err := createOutput(outFile)
+	if err != nil {
+		return
+	}
+	if strings.ToLower(filepath.Ext(outFile)) != ".gltf" {
+		err = g.WriteGLB(f)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		return
+	}
+
+	// glTF JSON and the binary buffer
+	binFile := strings.TrimSuffix(outFile, filepath.Ext(outFile)) + ".bin"
+	err = g.WriteJSON(f, filepath.Base(binFile))
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+	f, err = createOutput(binFile)
+	if err != nil {
+		return
+	}
+	err = g.WriteBin(f)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func main() {
+	var err error
+
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "%s: export a Reign-engine .pol/.mdl model and .mot motions to glTF 2.0\n", os.Args[0])
+		fmt.Fprintf(o, "usage: %s [flags] ArchiveFile ModelName\n", os.Args[0])
+		fmt.Fprintf(o, "       %s [flags] ModelFile\n", os.Args[0])
+		fmt.Fprintf(o, "textures are read from the archive, or the directory of the model file\n")
+		fmt.Fprintf(o, "flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&outFile, "o", outFile, "output `file`. .glb for binary glTF, or .gltf for glTF JSON with a .bin buffer. default is <model>.glb")
+	flag.Var(&motions, "mot", "(experimental) motion `file` to add as an animation. an archive entry name if the model is in an archive. may be repeated")
+	flag.BoolVar(&quiet, "q", quiet, "suppress warnings")
+	flag.BoolVar(&overwrite, "f", overwrite, "force overwrite existing files")
+
+	flag.Parse()
+
+	err = run()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mixcode/alicesoft-afa/sco"
+)
+
+// flags
+var (
+	headerOnly = false
+)
+
+func run() (err error) {
+	args := flag.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("input filename not given (use -help for help)")
+	}
+
+	for _, name := range args {
+		var data []byte
+		data, err = os.ReadFile(name)
+		if err != nil {
+			return
+		}
+		var page *sco.SCO
+		page, err = sco.Decode(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("# %s: %s page %d, %q, header 0x%x, size 0x%x\n", name, page.Signature, page.Page, page.Name, page.HeaderSize, page.Size)
+		if headerOnly {
+			continue
+		}
+		texts, e := page.Texts()
+		for _, t := range texts {
+			if t.Kind == sco.TextMenu {
+				fmt.Printf("%08x\t%s\t%08x\t%s\n", t.Offset, t.Kind, t.Target, t.Text)
+			} else {
+				fmt.Printf("%08x\t%s\t\t%s\n", t.Offset, t.Kind, t.Text)
+			}
+		}
+		if e != nil {
+			// the texts after the command are not listed
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, e)
+		}
+	}
+	return
+}
+
+func main() {
+	var err error
+
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "%s: list messages and menu items of AliceSoft System 3.x .sco scenario files\n", os.Args[0])
+		fmt.Fprintf(o, "usage: %s [flags] InputFile [InputFile...]\n", os.Args[0])
+		fmt.Fprintf(o, "output: offset, kind, menu target and text, separated by tabs\n")
+		fmt.Fprintf(o, "flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.BoolVar(&headerOnly, "h", headerOnly, "print the page headers only")
+
+	flag.Parse()
+
+	err = run()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
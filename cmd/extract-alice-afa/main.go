@@ -1,11 +1,9 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"image"
-	"image/draw"
-	"image/png"
 	"io"
 	"os"
 	"path/filepath"
@@ -15,28 +13,31 @@ import (
 	"golang.org/x/text/encoding/japanese"
 
 	aliceafa "github.com/mixcode/alicesoft-afa"
+	_ "github.com/mixcode/alicesoft-afa/acx"
+	"github.com/mixcode/alicesoft-afa/ex"
 	bst "github.com/mixcode/binarystruct"
 )
 
 // flags
 var (
-	listOnly  = false
-	imageOnly = false
-	rawImage  = false
-	plainDCF  = false
-	quiet     = false
-	overwrite = false
-	outDir    = ""
+	listOnly   = false
+	imageOnly  = false
+	rawImage   = false
+	plainDCF   = false
+	quiet      = false
+	overwrite  = false
+	outDir     = ""
+	cacheMB    = 512
+	exKeyFile  = ""
+	affKeyFile = ""
+	audioInfo  = false
+	depth      = 0
 )
 
 var (
 	sjisDecoder = japanese.ShiftJIS.NewDecoder()
 )
 
-func isImageExt(ext string) bool {
-	return ext == ".dcf" || ext == ".qnt"
-}
-
 func baseAndLowerExt(filename string) (base, ext string) {
 	ext = strings.ToLower(filepath.Ext(filename))
 	base = filename[:len(filename)-len(ext)]
@@ -73,27 +74,22 @@ func loadDCFBaseName(fi io.Reader) string {
 }
 
 // show filenames
-func listFiles(rs io.ReadSeeker, arch *aliceafa.AliceArch) (err error) {
-	for _, e := range arch.Entry {
-		_, ext := baseAndLowerExt(e.Name)
-		if imageOnly && !isImageExt(ext) {
-			continue
+func listFiles(ra io.ReaderAt, arch *aliceafa.AliceArch) (err error) {
+	return aliceafa.Walk(ra, arch, depth, func(e *aliceafa.WalkEntry) error {
+		if imageOnly && !e.Type.IsImage() {
+			return nil
 		}
-		if ext == ".dcf" {
+		if e.Type == aliceafa.ContentDCF {
 			// for DCF, also show the name of the base file
-			baseName := ""
-			_, err = rs.Seek(e.Offset, io.SeekStart)
-			if err == nil {
-				baseName = loadDCFBaseName(rs)
-			}
+			baseName := loadDCFBaseName(e.Content)
 			if baseName != "" {
-				fmt.Printf("%s (%s)\n", e.Name, baseName)
+				fmt.Printf("%s (%s)\n", e.Path, baseName)
 			}
-			continue
+			return nil
 		}
-		fmt.Printf("%s\n", e.Name)
-	}
-	return
+		fmt.Printf("%s\n", e.Path)
+		return nil
+	})
 }
 
 func isFileExist(path string) bool {
@@ -101,29 +97,30 @@ func isFileExist(path string) bool {
 	return !os.IsNotExist(err)
 }
 
-// PNG save goroutine
+// converted file save goroutine
 type OutFile struct {
 	outPath string
-	img     image.Image
+	v       interface{}
+	export  aliceafa.ExportKind
 }
 
 var (
-	savePngCh = make(chan OutFile, 10)
+	saveCh = make(chan OutFile, 10)
 )
 
-// a goroutine to save PNG in background
-func savePngProc(dataCh chan OutFile) (err error) {
+// a goroutine to encode and save converted files in background
+func saveProc(dataCh chan OutFile) (err error) {
 	for {
 		of, ok := <-dataCh
 		if !ok {
 			return nil
 		}
-		if of.img == nil {
+		if of.v == nil {
 			continue
 		}
 
-		// write PNG image
-		of.outPath += ".png"
+		// write the file in the export format
+		of.outPath += of.export.Ext()
 		if !overwrite && isFileExist(of.outPath) {
 			return fmt.Errorf("file %s exists", of.outPath)
 		}
@@ -133,8 +130,8 @@ func savePngProc(dataCh chan OutFile) (err error) {
 		if err != nil {
 			return
 		}
-		defer fo.Close()
-		err = png.Encode(fo, of.img)
+		err = aliceafa.Export(fo, of.export, of.v)
+		fo.Close()
 		if err != nil {
 			return
 		}
@@ -144,94 +141,131 @@ func savePngProc(dataCh chan OutFile) (err error) {
 	}
 }
 
-func saveFile(rs io.ReadSeeker, arch *aliceafa.AliceArch, index int, nameMap map[string]int) (err error) {
-	e := arch.Entry[index]
+// save file as-is. returns the path of the saved file.
+func saveRaw(rs io.Reader, e aliceafa.FileEntry, contentType aliceafa.ContentType, outPath string) (savedPath string, err error) {
 	_, ext := baseAndLowerExt(e.Name)
-	isImage := isImageExt(ext)
-	if imageOnly && !isImage {
-		// don't save non-image file
+	if contentType != aliceafa.ContentUnknown && !contentType.MatchExt(ext) {
+		// add the extension of the actual content
+		outPath += contentType.Ext()
+	}
+	if !overwrite && isFileExist(outPath) {
+		return "", fmt.Errorf("file %s exists", outPath)
+	}
+	fo, err := os.Create(outPath)
+	if err != nil {
 		return
 	}
+	defer fo.Close()
+	_, err = io.CopyN(fo, rs, e.Size)
+	if !quiet {
+		fmt.Println(outPath)
+	}
+	return outPath, err
+}
 
-	outPath := filepath.Join(outDir, e.Name)
-	_, err = rs.Seek(e.Offset, io.SeekStart)
+// save the properties of an audio file to a sidecar JSON file
+func saveAudioInfo(ra io.ReaderAt, e aliceafa.FileEntry, audioPath string) (err error) {
+	info, err := aliceafa.ReadAudioInfo(ra, e.Size)
 	if err != nil {
+		// not fatal; the audio itself is saved
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", e.Name, err)
+		}
+		return nil
+	}
+	outPath := audioPath + aliceafa.ExportJSON.Ext()
+	if !overwrite && isFileExist(outPath) {
+		return fmt.Errorf("file %s exists", outPath)
+	}
+	fo, err := os.Create(outPath)
+	if err != nil {
+		return
+	}
+	err = aliceafa.Export(fo, aliceafa.ExportJSON, info)
+	if e := fo.Close(); err == nil {
+		err = e
+	}
+	if err == nil && !quiet {
+		fmt.Println(outPath)
+	}
+	return
+}
+
+func saveFile(we *aliceafa.WalkEntry, cache *aliceafa.ImageCache) (err error) {
+	contentType, rs := we.Type, we.Content
+	if imageOnly && !contentType.IsImage() {
+		// don't save non-image file
 		return
 	}
+	e := aliceafa.FileEntry{Name: we.Path, Size: we.Size}
 
-	if rawImage || !isImage {
-		// save file as-is
-		if !overwrite && isFileExist(outPath) {
-			err = fmt.Errorf("file %s exists", outPath)
+	outPath := filepath.Join(outDir, we.Path)
+	if we.Depth > 0 {
+		// directory of the nested container
+		err = os.MkdirAll(filepath.Dir(outPath), 0755)
+		if err != nil {
 			return
 		}
-		var fo *os.File
-		fo, err = os.Create(outPath)
+	}
+
+	dec := aliceafa.LookupDecoder(contentType)
+	if rawImage || dec == nil || dec.Decode == nil {
+		var savedPath string
+		savedPath, err = saveRaw(rs, e, contentType, outPath)
 		if err != nil {
 			return
 		}
-		defer fo.Close()
-		_, err = io.CopyN(fo, rs, e.Size)
-		if !quiet {
-			fmt.Println(outPath)
+		if ra, ok := rs.(io.ReaderAt); ok && audioInfo && contentType.IsAudio() {
+			err = saveAudioInfo(ra, e, savedPath)
 		}
 		return
 	}
 
-	var img image.Image
-	switch ext {
-	case ".qnt":
-		img, err = aliceafa.LoadQNT(rs)
-		if err != nil {
-			return
-		}
-	case ".dcf":
-		baseName := ""
-		img, baseName, err = aliceafa.LoadDCF(rs)
+	ctx := &aliceafa.DecodeContext{Name: we.Path, Cache: cache}
+	if !plainDCF {
+		ctx.Resolver = we.Resolver
+	}
+	if !quiet {
+		ctx.Warn = func(err error) { fmt.Fprintln(os.Stderr, err) }
+	}
+	v, err := dec.Decode(rs, ctx)
+	if errors.Is(err, ex.ErrNoKey) {
+		// encrypted .ex without the key; save as-is
+		_, err = rs.Seek(0, io.SeekStart)
 		if err != nil {
 			return
 		}
-		if !plainDCF && baseName != "" {
-			baseBase, _ := baseAndLowerExt(baseName)
-			baseIdx, ok := nameMap[baseBase]
-			if ok {
-				// load the base file
-				baseEntry := arch.Entry[baseIdx]
-				_, err = rs.Seek(baseEntry.Offset, io.SeekStart)
-				if err != nil {
-					return
-				}
-				baseImg, er := aliceafa.LoadQNT(rs)
-				if er != nil {
-					_, err = rs.Seek(baseEntry.Offset, io.SeekStart)
-					if err != nil {
-						return
-					}
-					baseImg, _, _ = aliceafa.LoadDCF(rs)
-				}
-				if baseImg != nil {
-					img, err = mergeImage(baseImg, img)
-					if err != nil {
-						return
-					}
-				}
-			}
+		_, err = saveRaw(rs, e, contentType, outPath)
+		return
+	}
+	if err != nil {
+		return
+	}
+	if v == nil {
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "%s: skipped, %v\n", we.Path, aliceafa.ErrNoImage)
 		}
+		return
 	}
 
-	// send the image to png save worker
-	savePngCh <- OutFile{outPath: outPath, img: img}
+	// send the decoded value to the save worker
+	saveCh <- OutFile{outPath: outPath, v: v, export: dec.Export}
 
 	return
 }
 
-func mergeImage(baseImg, img image.Image) (image.Image, error) {
-	bi, ok := baseImg.(draw.Image)
-	if !ok {
-		return nil, fmt.Errorf("not a drawable iamge")
+// whether the file or one of its containers is in the names
+func selected(names map[string]bool, path string) bool {
+	for {
+		if names[path] {
+			return true
+		}
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
 	}
-	draw.Draw(bi, img.Bounds(), img, image.Point{}, draw.Over)
-	return baseImg, nil
 }
 
 func run() (err error) {
@@ -272,6 +306,16 @@ func run() (err error) {
 		}
 	}
 
+	// XOR key of AFF-wrapped files
+	if affKeyFile != "" {
+		var key []byte
+		key, err = os.ReadFile(affKeyFile)
+		if err != nil {
+			return
+		}
+		aliceafa.SetAFFKey(key)
+	}
+
 	if listOnly {
 		// show file list
 		return listFiles(fi, arch)
@@ -286,61 +330,54 @@ func run() (err error) {
 		return
 	}
 
-	// make archive name map for DCF merge
-	nameMap := make(map[string]int)
-	for i, e := range arch.Entry {
-		base, _ := baseAndLowerExt(e.Name)
-		nameMap[base] = i
+	// key table of .ex files
+	if exKeyFile != "" {
+		var key []byte
+		key, err = os.ReadFile(exKeyFile)
+		if err != nil {
+			return
+		}
+		err = ex.SetKeyTable(key)
+		if err != nil {
+			return
+		}
 	}
 
-	// start the png save thread
-	var savePngErr error
-	var savePngWg sync.WaitGroup
-	savePngWg.Add(1)
+	// cache of base images for DCF merge
+	cache := aliceafa.NewImageCache(int64(cacheMB) << 20)
+
+	// start the file save thread
+	var saveErr error
+	var saveWg sync.WaitGroup
+	saveWg.Add(1)
 	defer func() {
-		close(savePngCh)
-		savePngWg.Wait()
+		close(saveCh)
+		saveWg.Wait()
 	}()
 	go func() {
-		savePngErr = savePngProc(savePngCh)
+		saveErr = saveProc(saveCh)
 		for { // dry up the channel
-			_, ok := <-savePngCh
+			_, ok := <-saveCh
 			if !ok {
 				break
 			}
 		}
-		savePngWg.Done()
+		saveWg.Done()
 	}()
 
-	if len(args) > 0 {
-		argMap := make(map[string]bool)
-		for _, s := range args {
-			argMap[s] = true
-		}
-		for i, e := range arch.Entry {
-			if argMap[e.Name] {
-				err = saveFile(fi, arch, i, nameMap)
-				if err != nil {
-					return
-				}
-			}
-			if savePngErr != nil { // PNG save worker failed
-				err = savePngErr
-				return
-			}
+	argMap := make(map[string]bool)
+	for _, s := range args {
+		argMap[s] = true
+	}
+	err = aliceafa.Walk(fi, arch, depth, func(e *aliceafa.WalkEntry) error {
+		if saveErr != nil { // save worker failed
+			return saveErr
 		}
-	} else {
-		for i := range arch.Entry {
-			err = saveFile(fi, arch, i, nameMap)
-			if err != nil {
-				return
-			}
-			if savePngErr != nil { // PNG save worker failed
-				err = savePngErr
-				return
-			}
+		if len(args) > 0 && !selected(argMap, e.Path) {
+			return nil
 		}
-	}
+		return saveFile(e, cache)
+	})
 
 	return
 }
@@ -356,11 +393,16 @@ func main() {
 		flag.PrintDefaults()
 	}
 	flag.BoolVar(&listOnly, "ls", listOnly, "show list of files without extracting")
-	flag.BoolVar(&imageOnly, "imageonly", imageOnly, "extract only QNT/DCF image files")
-	flag.BoolVar(&rawImage, "raw", rawImage, "do NOT convert QNT/DCF to PNG")
+	flag.BoolVar(&imageOnly, "imageonly", imageOnly, "extract only QNT/DCF/AJP/PMS/VSP image files")
+	flag.BoolVar(&rawImage, "raw", rawImage, "do NOT convert files (images to PNG, tables to JSON or CSV)")
 	flag.BoolVar(&plainDCF, "plaindcf", plainDCF, "do NOT join DCF with base image")
 	flag.BoolVar(&quiet, "q", quiet, "suppress log output")
 	flag.BoolVar(&overwrite, "f", overwrite, "force overwrite existing files")
+	flag.IntVar(&cacheMB, "cachemb", cacheMB, "memory budget in MB for caching base images of DCF")
+	flag.StringVar(&exKeyFile, "exkey", exKeyFile, "256-byte substitution table `file` to decrypt .ex files")
+	flag.StringVar(&affKeyFile, "affkey", affKeyFile, "XOR key `file` to unwrap AFF-wrapped files. AFF files are saved as-is without the key")
+	flag.BoolVar(&audioInfo, "audioinfo", audioInfo, "write the properties and loop points of audio files to sidecar JSON files")
+	flag.IntVar(&depth, "depth", depth, "extract files in nested AFA/ALD/FLAT containers up to `n` levels, into directories named after the containers")
 	flag.StringVar(&outDir, "outdir", outDir, "output directory. default is the name of input file")
 
 	flag.Parse()
@@ -0,0 +1,248 @@
+package aliceafa
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ErrImageNotFound = errors.New("image not found")
+	ErrCircularImage = errors.New("circular reference of base images")
+)
+
+// ImageResolver finds the base image of a DCF image by its name.
+type ImageResolver interface {
+	// Open the data of an image with the name.
+	// The name is matched without the extension, because the base image name in DCF may have a different extension.
+	// The returned key uniquely identifies the image entry.
+	// Returns ErrImageNotFound if there is no such image.
+	OpenImage(name string) (rs io.ReadSeeker, key string, err error)
+
+	// The key of an image with the name, same as the one returned by OpenImage, without reading the data.
+	// Returns ErrImageNotFound if there is no such image.
+	ImageKey(name string) (key string, err error)
+}
+
+// normalize the image name for lookup
+func imageLookupName(name string) string {
+	name = strings.ToLower(name)
+	return name[:len(name)-len(filepath.Ext(name))]
+}
+
+// ImageResolver for files in an ALD/AFA archive.
+type ArchiveResolver struct {
+	Arch    *AliceArch
+	R       io.ReadSeeker // open file handle of the archive file
+	nameMap map[string]int
+	id      uint64 // unique id of the resolver, for the image keys
+}
+
+// last id of the ArchiveResolvers
+var archiveResolverID atomic.Uint64
+
+// Make an ImageResolver for an archive.
+// r must be the open file handle of the archive file.
+func NewArchiveResolver(arch *AliceArch, r io.ReadSeeker) *ArchiveResolver {
+	nameMap := make(map[string]int)
+	for i, e := range arch.Entry {
+		nameMap[imageLookupName(e.Name)] = i
+	}
+	return &ArchiveResolver{Arch: arch, R: r, nameMap: nameMap, id: archiveResolverID.Add(1)}
+}
+
+// Open the data of an image in the archive.
+func (p *ArchiveResolver) OpenImage(name string) (rs io.ReadSeeker, key string, err error) {
+	index, ok := p.nameMap[imageLookupName(name)]
+	if !ok {
+		err = ErrImageNotFound
+		return
+	}
+	data, err := p.Arch.Read(p.R, index)
+	if err != nil {
+		return
+	}
+	return bytes.NewReader(data), p.entryKey(index), nil
+}
+
+// The key of an image in the archive.
+func (p *ArchiveResolver) ImageKey(name string) (key string, err error) {
+	index, ok := p.nameMap[imageLookupName(name)]
+	if !ok {
+		return "", ErrImageNotFound
+	}
+	return p.entryKey(index), nil
+}
+
+// keys are unique among the resolvers made by NewArchiveResolver, even after the archive is freed
+func (p *ArchiveResolver) entryKey(index int) string {
+	return fmt.Sprintf("archive#%d:%d", p.id, index)
+}
+
+// ImageResolver for image files in a directory on disk.
+type DirResolver struct {
+	Dir     string
+	nameMap map[string]string
+}
+
+// Make an ImageResolver for a directory.
+func NewDirResolver(dir string) (resolver *DirResolver, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	nameMap := make(map[string]string)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		nameMap[imageLookupName(e.Name())] = e.Name()
+	}
+	return &DirResolver{Dir: dir, nameMap: nameMap}, nil
+}
+
+// Open the data of an image file in the directory.
+func (p *DirResolver) OpenImage(name string) (rs io.ReadSeeker, key string, err error) {
+	key, err = p.ImageKey(name)
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return
+	}
+	return bytes.NewReader(data), key, nil
+}
+
+// The key of an image file in the directory, which is the absolute path of the file.
+func (p *DirResolver) ImageKey(name string) (key string, err error) {
+	filename, ok := p.nameMap[imageLookupName(name)]
+	if !ok {
+		return "", ErrImageNotFound
+	}
+	return filepath.Abs(filepath.Join(p.Dir, filename))
+}
+
+// ImageResolver that searches a list of resolvers in order.
+// Images in earlier resolvers override images in later resolvers.
+type LayeredResolver []ImageResolver
+
+// Open the data of an image from the first resolver that has the image.
+func (p LayeredResolver) OpenImage(name string) (rs io.ReadSeeker, key string, err error) {
+	for _, r := range p {
+		rs, key, err = r.OpenImage(name)
+		if !errors.Is(err, ErrImageNotFound) {
+			return
+		}
+	}
+	return nil, "", ErrImageNotFound
+}
+
+// The key of an image from the first resolver that has the image.
+func (p LayeredResolver) ImageKey(name string) (key string, err error) {
+	for _, r := range p {
+		key, err = r.ImageKey(name)
+		if !errors.Is(err, ErrImageNotFound) {
+			return
+		}
+	}
+	return "", ErrImageNotFound
+}
+
+// Compose a DCF image on its base image.
+// The base image is found by the resolver. If the base image is also a DCF, it is composed recursively.
+// The returned image covers both the base image and the DCF image, placed on their origins.
+func ComposeDCF(dcf *DCF, resolver ImageResolver) (img *image.NRGBA, err error) {
+	return composeDCF(dcf, resolver, nil, make(map[string]bool))
+}
+
+// Compose a DCF image on its base image, same as ComposeDCF.
+// Decoded base images are stored in the cache and reused for other DCF images with the same base.
+func ComposeDCFWithCache(dcf *DCF, resolver ImageResolver, cache *ImageCache) (img *image.NRGBA, err error) {
+	return composeDCF(dcf, resolver, cache, make(map[string]bool))
+}
+
+func composeDCF(dcf *DCF, resolver ImageResolver, cache *ImageCache, visited map[string]bool) (img *image.NRGBA, err error) {
+	baseImg, err := loadBaseImage(dcf.BaseImageName, resolver, cache, visited)
+	if err != nil {
+		return
+	}
+	return mergeImage(baseImg, dcf.MaskedImage()), nil
+}
+
+// load the base image of a DCF
+func loadBaseImage(name string, resolver ImageResolver, cache *ImageCache, visited map[string]bool) (img image.Image, err error) {
+	key, err := resolver.ImageKey(name)
+	if err != nil {
+		return
+	}
+	if visited[key] {
+		return nil, ErrCircularImage
+	}
+	visited[key] = true
+
+	if cache != nil {
+		if cached, ok := cache.Get(key); ok {
+			return cached, nil
+		}
+		defer func() {
+			if err == nil {
+				cache.Put(key, img)
+			}
+		}()
+	}
+
+	// read the data only if not cached
+	rs, _, err := resolver.OpenImage(name)
+	if err != nil {
+		return
+	}
+	rs, err = UnwrapAFF(rs)
+	if err != nil {
+		return
+	}
+
+	// check the signature
+	var sig [4]byte
+	_, err = io.ReadFull(rs, sig[:])
+	if err != nil {
+		return
+	}
+	_, err = rs.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	switch string(sig[:]) {
+	case "QNT\x00":
+		img, err = LoadQNT(rs)
+		if err == nil && img == nil {
+			err = ErrNoImage
+		}
+		return
+	case "dcf ":
+		var dcf *DCF
+		dcf, err = DecodeDCF(rs)
+		if err != nil {
+			return
+		}
+		return composeDCF(dcf, resolver, cache, visited)
+	}
+	return nil, fmt.Errorf("base image %s: %w", name, ErrInvalidFormat)
+}
+
+// overlay img on baseImg. The result covers both images.
+func mergeImage(baseImg, img image.Image) *image.NRGBA {
+	r := baseImg.Bounds().Union(img.Bounds())
+	merged := image.NewNRGBA(r)
+	draw.Draw(merged, baseImg.Bounds(), baseImg, baseImg.Bounds().Min, draw.Src)
+	draw.Draw(merged, img.Bounds(), img, img.Bounds().Min, draw.Over)
+	return merged
+}
@@ -0,0 +1,199 @@
+package aliceafa
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ImageResolver on memory
+type mapResolver map[string][]byte
+
+func (p mapResolver) OpenImage(name string) (rs io.ReadSeeker, key string, err error) {
+	key = imageLookupName(name)
+	data, ok := p[key]
+	if !ok {
+		return nil, "", ErrImageNotFound
+	}
+	return bytes.NewReader(data), key, nil
+}
+
+func (p mapResolver) ImageKey(name string) (key string, err error) {
+	key = imageLookupName(name)
+	if _, ok := p[key]; !ok {
+		return "", ErrImageNotFound
+	}
+	return
+}
+
+// ImageResolver that counts the reads of the data
+type countingResolver struct {
+	ImageResolver
+	reads int
+}
+
+func (p *countingResolver) OpenImage(name string) (rs io.ReadSeeker, key string, err error) {
+	p.reads++
+	return p.ImageResolver.OpenImage(name)
+}
+
+func encodeTestDCF(t *testing.T, base, img image.Image, baseName string) []byte {
+	var buf bytes.Buffer
+	err := EncodeDCF(&buf, base, img, baseName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestComposeDCF(t *testing.T) {
+	r := image.Rect(0, 0, 64, 48)
+	base := testPattern(r, 0)
+	v1 := testPattern(r, 0)
+	for x := 0; x < 20; x++ {
+		v1.SetNRGBA(x, 5, color.NRGBA{0xff, 0, 0, 0xff})
+	}
+	v2 := image.NewNRGBA(r)
+	copy(v2.Pix, v1.Pix)
+	v2.SetNRGBA(40, 40, color.NRGBA{0, 0xff, 0, 0xff})
+
+	var qnt bytes.Buffer
+	err := EncodeQNT(&qnt, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := mapResolver{
+		"base": qnt.Bytes(),
+		"v1":   encodeTestDCF(t, base, v1, "base.qnt"),
+		"v2":   encodeTestDCF(t, v1, v2, "v1.dcf"), // DCF on DCF
+	}
+
+	dcf, err := DecodeDCF(bytes.NewReader(resolver["v2"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := ComposeDCF(dcf, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(img, v2) {
+		t.Fatalf("composed image mismatch")
+	}
+
+	// circular reference
+	resolver["v1"] = encodeTestDCF(t, base, v1, "v2.dcf")
+	_, err = ComposeDCF(dcf, resolver)
+	if !errors.Is(err, ErrCircularImage) {
+		t.Fatalf("circular reference not detected: %v", err)
+	}
+
+	// missing base
+	delete(resolver, "v1")
+	_, err = ComposeDCF(dcf, resolver)
+	if !errors.Is(err, ErrImageNotFound) {
+		t.Fatalf("missing base image not detected: %v", err)
+	}
+}
+
+func TestComposeDCFOrigin(t *testing.T) {
+	// a variant placed off the origin of the base image
+	base := testPattern(image.Rect(0, 0, 64, 64), 0)
+	vr := image.Rect(16, 32, 48, 64)
+	variant := testPattern(vr, 3)
+
+	var qnt bytes.Buffer
+	err := EncodeQNT(&qnt, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, "Base.QNT"), qnt.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirResolver, err := NewDirResolver(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := LayeredResolver{mapResolver{}, dirResolver}
+
+	dcf, err := DecodeDCF(bytes.NewReader(encodeTestDCF(t, base, variant, "base.qnt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := ComposeDCF(dcf, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != base.Bounds() {
+		t.Fatalf("invalid bounds: %v", img.Bounds())
+	}
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			want := base.At(x, y)
+			if image.Pt(x, y).In(vr) {
+				want = variant.At(x, y)
+			}
+			if img.At(x, y) != want {
+				t.Fatalf("pixel mismatch at (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+func TestImageCache(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16)) // 1024 bytes
+	cache := NewImageCache(2048)
+	cache.Put("a", img)
+	cache.Put("b", img)
+	if _, ok := cache.Get("a"); !ok { // "a" is now the most recently used
+		t.Fatalf("cached image not found")
+	}
+	cache.Put("c", img) // "b" is evicted
+	if _, ok := cache.Get("b"); ok {
+		t.Fatalf("image not evicted")
+	}
+	if cache.Len() != 2 || cache.Size() != 2048 {
+		t.Fatalf("invalid cache size: %d, %d", cache.Len(), cache.Size())
+	}
+	cache.Put("d", image.NewNRGBA(image.Rect(0, 0, 64, 64))) // too large to cache
+	if _, ok := cache.Get("d"); ok || cache.Len() != 2 {
+		t.Fatalf("image larger than budget is cached")
+	}
+
+	// composition with cache
+	r := image.Rect(0, 0, 32, 32)
+	base := testPattern(r, 0)
+	variant := testPattern(r, 1)
+	var qnt bytes.Buffer
+	err := EncodeQNT(&qnt, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &countingResolver{ImageResolver: mapResolver{"base": qnt.Bytes()}}
+	dcf, err := DecodeDCF(bytes.NewReader(encodeTestDCF(t, base, variant, "base.qnt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache = NewImageCache(1 << 20)
+	for i := 0; i < 2; i++ {
+		img, err := ComposeDCFWithCache(dcf, resolver, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameImage(img, variant) {
+			t.Fatalf("composed image mismatch")
+		}
+	}
+	if cached, ok := cache.Get("base"); !ok || !sameImage(cached, base) {
+		t.Fatalf("base image is not cached")
+	}
+	if resolver.reads != 1 {
+		t.Fatalf("cached base image is read %d times", resolver.reads)
+	}
+}
@@ -0,0 +1,215 @@
+package aliceafa
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Type of the content of a file, detected by its magic bytes.
+type ContentType string
+
+const (
+	ContentUnknown ContentType = ""
+
+	// images
+	ContentQNT  ContentType = "qnt"
+	ContentDCF  ContentType = "dcf"
+	ContentAJP  ContentType = "ajp"
+	ContentPMS  ContentType = "pms"
+	ContentVSP  ContentType = "vsp"
+	ContentPNG  ContentType = "png"
+	ContentJPEG ContentType = "jpg"
+	ContentBMP  ContentType = "bmp"
+
+	// audio
+	ContentOGG ContentType = "ogg"
+	ContentWAV ContentType = "wav"
+	ContentMP3 ContentType = "mp3"
+
+	// scripts and data
+	ContentAIN ContentType = "ain" // System 4 bytecode
+	ContentEX  ContentType = "ex"  // System 4 configuration table
+	ContentACX ContentType = "acx" // System 4 table
+	ContentSCO ContentType = "sco" // System 3.x scenario
+	ContentFNL ContentType = "fnl" // font
+	ContentPOL ContentType = "pol" // Reign-engine model
+	ContentMDL ContentType = "mdl" // Reign-engine model
+	ContentMOT ContentType = "mot" // Reign-engine motion
+
+	// containers
+	ContentAFA  ContentType = "afa"
+	ContentALD  ContentType = "ald" // detected only by the extension
+	ContentAFF  ContentType = "aff"
+	ContentFLAT ContentType = "flat"
+)
+
+// Minimum length of the header to detect content types.
+const ContentSniffLen = 64
+
+// known filename extensions of content types. the first one is the preferred one.
+var contentExts = map[ContentType][]string{
+	ContentJPEG: {".jpg", ".jpeg"},
+	ContentAIN:  {".ain"},
+	ContentSCO:  {".sco"},
+	ContentFLAT: {".flat", ".flt"},
+	ContentALD:  {".ald"},
+}
+
+// Preferred filename extension of the content type, including the leading dot.
+// Returns an empty string for ContentUnknown.
+func (t ContentType) Ext() string {
+	if t == ContentUnknown {
+		return ""
+	}
+	if exts, ok := contentExts[t]; ok {
+		return exts[0]
+	}
+	return "." + string(t)
+}
+
+// Whether the filename extension ext is a known extension of the content type.
+func (t ContentType) MatchExt(ext string) bool {
+	ext = strings.ToLower(ext)
+	if exts, ok := contentExts[t]; ok {
+		for _, e := range exts {
+			if e == ext {
+				return true
+			}
+		}
+		return false
+	}
+	return t != ContentUnknown && ext == t.Ext()
+}
+
+// Whether the content is an image.
+func (t ContentType) IsImage() bool {
+	switch t {
+	case ContentQNT, ContentDCF, ContentAJP, ContentPMS, ContentVSP, ContentPNG, ContentJPEG, ContentBMP:
+		return true
+	}
+	return false
+}
+
+// Whether the content is an audio.
+func (t ContentType) IsAudio() bool {
+	switch t {
+	case ContentOGG, ContentWAV, ContentMP3:
+		return true
+	}
+	return false
+}
+
+// first bytes of "VERS" tag in encrypted AIN files
+var encryptedAINMagic = []byte{0xd3, 0xfa, 0x08, 0xe1}
+
+// magic bytes at the start of the data
+var contentMagics = []struct {
+	magic []byte
+	t     ContentType
+}{
+	{[]byte("QNT\x00"), ContentQNT},
+	{[]byte("dcf "), ContentDCF},
+	{[]byte("AJP\x00"), ContentAJP},
+	{[]byte("\x89PNG\r\n\x1a\n"), ContentPNG},
+	{[]byte("\xff\xd8\xff"), ContentJPEG},
+	{[]byte("OggS"), ContentOGG},
+	{[]byte("ID3"), ContentMP3},
+	{[]byte("AI2\x00"), ContentAIN},
+	{[]byte("VERS"), ContentAIN},
+	{encryptedAINMagic, ContentAIN},
+	{[]byte("ACX\x00"), ContentACX},
+	{[]byte("FNA\x00"), ContentFNL},
+	{[]byte("POL\x00"), ContentPOL},
+	{[]byte("MDL\x00"), ContentMDL},
+	{[]byte("MOT\x00"), ContentMOT},
+	{[]byte("AFAH"), ContentAFA},
+	{[]byte("AFF\x00"), ContentAFF},
+	{[]byte("ELNA"), ContentFLAT},
+	{[]byte("FLAT"), ContentFLAT},
+	{[]byte("S350"), ContentSCO},
+	{[]byte("S351"), ContentSCO},
+	{[]byte("153S"), ContentSCO},
+	{[]byte("S360"), ContentSCO},
+	{[]byte("S380"), ContentSCO},
+}
+
+// Detect the content type of data by its first bytes.
+// header should have at least ContentSniffLen bytes, if the data is long enough.
+// Returns ContentUnknown if the type is not recognized.
+func DetectContentType(header []byte) ContentType {
+	for _, m := range contentMagics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.t
+		}
+	}
+
+	switch {
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return ContentWAV
+
+	case len(header) >= 12 && string(header[0:4]) == "HEAD" && string(header[8:12]) == "EXTF":
+		return ContentEX
+
+	case len(header) >= 14 && string(header[0:2]) == "BM" &&
+		le32(header[6:10]) == 0 && le32(header[10:14]) >= 14:
+		// BMP with zero reserved field and a valid pixel offset
+		return ContentBMP
+
+	case len(header) >= 8 && string(header[0:2]) == "PM" && (header[6] == 8 || header[6] == 16):
+		return ContentPMS
+
+	case isVSPHeader(header):
+		return ContentVSP
+	}
+	return ContentUnknown
+}
+
+// little endian uint32
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// VSP has no magic; check whether the header has sane values
+func isVSPHeader(header []byte) bool {
+	if len(header) < 0x3a {
+		return false
+	}
+	x0, y0 := int(header[0])|int(header[1])<<8, int(header[2])|int(header[3])<<8
+	x1, y1 := int(header[4])|int(header[5])<<8, int(header[6])|int(header[7])<<8
+	if x1 <= x0 || y1 <= y0 || x1 > 80 || y1 > 480 || header[8] != 0 {
+		return false
+	}
+	for _, c := range header[0x0a:0x3a] { // 4-bit palette values
+		if c > 0x0f {
+			return false
+		}
+	}
+	return true
+}
+
+// Guess the content type by a filename extension.
+// Returns ContentUnknown if the extension is not known.
+func ContentTypeByExt(ext string) ContentType {
+	ext = strings.ToLower(ext)
+	for t, exts := range contentExts {
+		for _, e := range exts {
+			if e == ext {
+				return t
+			}
+		}
+	}
+	if len(ext) < 2 || ext[0] != '.' {
+		return ContentUnknown
+	}
+	t := ContentType(ext[1:])
+	for _, m := range contentMagics {
+		if m.t == t {
+			return t
+		}
+	}
+	switch t {
+	case ContentWAV, ContentEX, ContentBMP, ContentPMS, ContentVSP:
+		return t
+	}
+	return ContentUnknown
+}
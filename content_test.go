@@ -0,0 +1,50 @@
+package aliceafa
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	var qnt bytes.Buffer
+	err := EncodeQNT(&qnt, testPattern(image.Rect(0, 0, 8, 8), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vsp := make([]byte, 0x40)
+	copy(vsp, []byte{0, 0, 0, 0, 80, 0, 0x90, 1})
+
+	for _, c := range []struct {
+		header []byte
+		t      ContentType
+	}{
+		{qnt.Bytes(), ContentQNT},
+		{[]byte("dcf \x20\x00\x00\x00"), ContentDCF},
+		{[]byte("AJP\x00\x00\x00\x00\x00"), ContentAJP},
+		{[]byte("PM\x01\x00\x30\x00\x08\x00"), ContentPMS},
+		{vsp, ContentVSP},
+		{[]byte("OggS\x00\x02"), ContentOGG},
+		{[]byte("RIFF\x24\x00\x00\x00WAVEfmt "), ContentWAV},
+		{[]byte("\x89PNG\r\n\x1a\n\x00"), ContentPNG},
+		{[]byte("AI2\x00\x00\x00\x00\x00"), ContentAIN},
+		{[]byte("VERS\x04\x00\x00\x00"), ContentAIN},
+		{[]byte("\xd3\xfa\x08\xe1\x42\x16"), ContentAIN},
+		{[]byte("HEAD\x0c\x00\x00\x00EXTF"), ContentEX},
+		{[]byte("AFAH\x1c\x00\x00\x00AlicArch"), ContentAFA},
+		{[]byte("PM\x01\x00\x30\x00\x18\x00"), ContentUnknown},
+		{[]byte("unknown data"), ContentUnknown},
+		{nil, ContentUnknown},
+	} {
+		if got := DetectContentType(c.header); got != c.t {
+			t.Errorf("%q: expected %q, got %q", c.header, c.t, got)
+		}
+	}
+
+	if ContentTypeByExt(".JPEG") != ContentJPEG || ContentTypeByExt(".qnt") != ContentQNT || ContentTypeByExt(".txt") != ContentUnknown {
+		t.Errorf("invalid content type by extension")
+	}
+	if ContentQNT.Ext() != ".qnt" || !ContentJPEG.MatchExt(".JPG") || ContentOGG.MatchExt(".wav") {
+		t.Errorf("invalid content type extension")
+	}
+}
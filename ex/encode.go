@@ -0,0 +1,237 @@
+package ex
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// little-endian writer of decompressed data
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) int32(v int32) {
+	w.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+}
+
+// write a string in ShiftJIS, zero-padded to 4 bytes
+func (w *writer) str(s string) (err error) {
+	b, err := sjisEncoder.Bytes([]byte(s))
+	if err != nil {
+		return fmt.Errorf("string %q is not representable in ShiftJIS", s)
+	}
+	padded := (len(b) + 4) &^ 3
+	w.int32(int32(padded))
+	w.Write(b)
+	w.Write(make([]byte, padded-len(b)))
+	return nil
+}
+
+// write a value with its size
+func (w *writer) sized(write func(w *writer) error) (err error) {
+	sub := new(writer)
+	err = write(sub)
+	if err != nil {
+		return
+	}
+	w.int32(int32(sub.Len()))
+	w.Write(sub.Bytes())
+	return
+}
+
+// write a block: type, size, name and value
+func (w *writer) block(b *Block) (err error) {
+	w.int32(int32(b.Value.Type))
+	return w.sized(func(w *writer) error {
+		if err := w.str(b.Name); err != nil {
+			return err
+		}
+		return w.value(&b.Value, nil)
+	})
+}
+
+// write a value. parent is the field of the cell, or nil if the value is not a table cell.
+func (w *writer) value(v *Value, parent *Field) (err error) {
+	switch v.Type {
+	case TypeInt:
+		w.int32(v.Int)
+	case TypeFloat:
+		w.int32(int32(math.Float32bits(v.Float)))
+	case TypeString:
+		err = w.str(v.String)
+	case TypeTable:
+		if v.Table == nil {
+			return fmt.Errorf("table value is nil")
+		}
+		err = w.table(v.Table, parent)
+	case TypeList:
+		w.int32(int32(len(v.List)))
+		for i := range v.List {
+			item := &v.List[i]
+			w.int32(int32(item.Type))
+			err = w.sized(func(w *writer) error { return w.value(item, nil) })
+			if err != nil {
+				return
+			}
+		}
+	case TypeTree:
+		if v.Tree == nil {
+			return fmt.Errorf("tree value is nil")
+		}
+		err = w.tree(v.Tree)
+	default:
+		err = fmt.Errorf("unknown value type %d", v.Type)
+	}
+	return
+}
+
+func (w *writer) fields(fields []Field) (err error) {
+	w.int32(int32(len(fields)))
+	for i := range fields {
+		f := &fields[i]
+		w.int32(int32(f.Type))
+		err = w.str(f.Name)
+		if err != nil {
+			return
+		}
+		w.int32(boolInt(f.HasValue))
+		w.int32(boolInt(f.IsIndex))
+		if f.HasValue {
+			if f.Value.Type != f.Type {
+				return fmt.Errorf("field %q has a default value of %v", f.Name, f.Value.Type)
+			}
+			err = w.value(&f.Value, nil)
+			if err != nil {
+				return
+			}
+		}
+		if f.Type == TypeTable {
+			err = w.fields(f.Fields)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// write a table. fields of sub-tables are taken from the field of the parent table.
+func (w *writer) table(t *Table, parent *Field) (err error) {
+	fields := t.Fields
+	if parent == nil {
+		err = w.fields(fields)
+		if err != nil {
+			return
+		}
+	} else {
+		fields = parent.Fields
+	}
+	w.int32(int32(len(t.Rows)))
+	w.int32(int32(len(fields)))
+	for i, row := range t.Rows {
+		if len(row) != len(fields) {
+			return fmt.Errorf("row %d has %d columns but the table has %d fields", i, len(row), len(fields))
+		}
+		for j := range row {
+			if row[j].Type != fields[j].Type {
+				return fmt.Errorf("cell (%d, %d) has type %v but the field is %v", i, j, row[j].Type, fields[j].Type)
+			}
+			w.int32(int32(row[j].Type))
+			err = w.value(&row[j], &fields[j])
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (w *writer) tree(t *Tree) (err error) {
+	if t.Leaf != nil {
+		w.int32(1)
+		err = w.block(t.Leaf)
+		w.int32(0)
+		return
+	}
+	w.int32(0)
+	w.int32(int32(len(t.Children)))
+	for _, c := range t.Children {
+		err = w.str(c.Name)
+		if err != nil {
+			return
+		}
+		if c.Tree == nil {
+			return fmt.Errorf("tree node %q is nil", c.Name)
+		}
+		err = w.tree(c.Tree)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func boolInt(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Encode the .ex file.
+// The data is encrypted if the key table is set by SetKeyTable.
+func (p *EX) Encode() (data []byte, err error) {
+	plain := new(writer)
+	for i := range p.Blocks {
+		err = plain.block(&p.Blocks[i])
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	_, err = zw.Write(plain.Bytes())
+	if err != nil {
+		return
+	}
+	err = zw.Close()
+	if err != nil {
+		return
+	}
+	body := z.Bytes()
+	if encryptTable != nil {
+		for i, b := range body {
+			body[i] = encryptTable[b]
+		}
+	}
+
+	hdr := exHeader{
+		Head:             [4]byte{'H', 'E', 'A', 'D'},
+		HeadSize:         0x0c,
+		Extf:             [4]byte{'E', 'X', 'T', 'F'},
+		Version:          1,
+		BlockCount:       uint32(len(p.Blocks)),
+		Data:             [4]byte{'D', 'A', 'T', 'A'},
+		CompressedSize:   uint32(len(body)),
+		DecompressedSize: uint32(plain.Len()),
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// Write the .ex file.
+func (p *EX) Write(w io.Writer) (err error) {
+	data, err := p.Encode()
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
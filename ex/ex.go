@@ -0,0 +1,445 @@
+// Package ex reads and writes .ex files, the configuration tables of AliceSoft System 4 games.
+//
+// An .ex file is a "HEAD" chunk followed by a "DATA" chunk, which is a zlib stream behind a byte substitution cipher.
+// The stream is a list of named blocks, each holds an int, a float, a string, a table, a list or a tree.
+package ex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/text/encoding/japanese"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+	"github.com/mixcode/alicesoft-afa/internal/zlibutil"
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid ex format")
+	ErrNoKey         = errors.New("ex data is encrypted; the key table is not set")
+)
+
+var (
+	sjisDecoder = japanese.ShiftJIS.NewDecoder()
+	sjisEncoder = japanese.ShiftJIS.NewEncoder()
+)
+
+func init() {
+	aliceafa.RegisterDecoder(&aliceafa.Decoder{
+		Type:   aliceafa.ContentEX,
+		Export: aliceafa.ExportJSON,
+		Decode: func(rs io.ReadSeeker, ctx *aliceafa.DecodeContext) (v interface{}, err error) {
+			return Read(rs)
+		},
+	})
+}
+
+// Type of a value.
+type ValueType int
+
+const (
+	TypeInt    ValueType = 2
+	TypeFloat  ValueType = 3
+	TypeString ValueType = 4
+	TypeTable  ValueType = 5
+	TypeList   ValueType = 6
+	TypeTree   ValueType = 7
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "float"
+	case TypeString:
+		return "string"
+	case TypeTable:
+		return "table"
+	case TypeList:
+		return "list"
+	case TypeTree:
+		return "tree"
+	}
+	return fmt.Sprintf("type(%d)", int(t))
+}
+
+// A value. Only the member of the Type is valid.
+type Value struct {
+	Type   ValueType
+	Int    int32
+	Float  float32
+	String string
+	Table  *Table
+	List   []Value
+	Tree   *Tree
+}
+
+// A column of a table.
+type Field struct {
+	Type     ValueType
+	Name     string
+	HasValue bool    // whether Value is the default value of the field
+	IsIndex  bool    // whether the field is the index of the rows
+	Value    Value   // default value
+	Fields   []Field `json:",omitempty"` // fields of the sub-table, if Type is TypeTable
+}
+
+// A table, rows of values.
+type Table struct {
+	Fields []Field
+	Rows   [][]Value
+}
+
+// A node of a tree. A node is either a leaf with a value, or a branch with children.
+type Tree struct {
+	Leaf     *Block `json:",omitempty"`
+	Children []TreeNode
+}
+
+// A named child of a tree node.
+type TreeNode struct {
+	Name string
+	Tree *Tree
+}
+
+// A named value.
+type Block struct {
+	Name  string
+	Value Value
+}
+
+// A decoded .ex file.
+type EX struct {
+	Blocks []Block
+}
+
+// Find a block by its name. Returns nil if not exists.
+func (p *EX) Block(name string) *Block {
+	for i := range p.Blocks {
+		if p.Blocks[i].Name == name {
+			return &p.Blocks[i]
+		}
+	}
+	return nil
+}
+
+// substitution tables of the cipher
+var (
+	decryptTable *[256]byte
+	encryptTable *[256]byte
+)
+
+// Set the byte substitution table of the cipher; an encrypted byte b is decrypted to table[b].
+// The table is not bundled with this package, and must be a permutation of 256 bytes.
+// If the table is not set, only unencrypted data can be read, and data is written unencrypted.
+// Unencrypted data is read as-is even if the table is set.
+// A nil table clears the current table.
+func SetKeyTable(table []byte) error {
+	if table == nil {
+		decryptTable, encryptTable = nil, nil
+		return nil
+	}
+	if len(table) != 256 {
+		return fmt.Errorf("key table must have 256 bytes")
+	}
+	var dec, enc [256]byte
+	var used [256]bool
+	for i, b := range table {
+		if used[b] {
+			return fmt.Errorf("key table is not a permutation")
+		}
+		used[b] = true
+		dec[i] = b
+		enc[b] = byte(i)
+	}
+	decryptTable, encryptTable = &dec, &enc
+	return nil
+}
+
+// .ex file header
+type exHeader struct {
+	Head             [4]byte // "HEAD"
+	HeadSize         uint32  // 0x0c
+	Extf             [4]byte // "EXTF"
+	Version          uint32  // 1
+	BlockCount       uint32
+	Data             [4]byte // "DATA"
+	CompressedSize   uint32
+	DecompressedSize uint32
+}
+
+// Read an .ex file.
+func Read(r io.Reader) (ex *EX, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return Decode(data)
+}
+
+// Decode an .ex file.
+func Decode(data []byte) (ex *EX, err error) {
+	var hdr exHeader
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr)
+	if err != nil || string(hdr.Head[:]) != "HEAD" || hdr.HeadSize != 0x0c || string(hdr.Extf[:]) != "EXTF" || string(hdr.Data[:]) != "DATA" {
+		return nil, ErrInvalidFormat
+	}
+	if hdr.Version != 1 {
+		return nil, fmt.Errorf("unsupported ex version %d", hdr.Version)
+	}
+	body := data[binary.Size(hdr):]
+	if int64(hdr.CompressedSize) > int64(len(body)) {
+		return nil, ErrInvalidFormat
+	}
+	body = body[:hdr.CompressedSize]
+
+	// unencrypted data starts with a zlib header.
+	// encrypted data may look like a zlib header by chance, so it is decrypted if the data can't be inflated.
+	plain, err := inflate(body, hdr.DecompressedSize)
+	if err != nil {
+		if decryptTable == nil {
+			if !zlibutil.IsHeader(body) {
+				err = ErrNoKey
+			}
+			return nil, err
+		}
+		z := make([]byte, len(body))
+		for i, b := range body {
+			z[i] = decryptTable[b]
+		}
+		plain, err = inflate(z, hdr.DecompressedSize)
+		if err != nil {
+			return
+		}
+	}
+
+	// the number of blocks is not trusted for allocation; a broken count fails on reading
+	ex = &EX{}
+	r := &reader{b: plain}
+	for i := 0; i < int(hdr.BlockCount); i++ {
+		var b Block
+		b, err = r.block()
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		ex.Blocks = append(ex.Blocks, b)
+	}
+	return
+}
+
+// inflate zlib data of the size
+func inflate(z []byte, size uint32) (plain []byte, err error) {
+	if !zlibutil.IsHeader(z) {
+		return nil, ErrInvalidFormat
+	}
+	return zlibutil.Inflate(z, size)
+}
+
+// little-endian reader of decompressed data.
+// the first error is kept in err, and the following reads return zero values.
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.pos = len(r.b)
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.b) {
+		r.fail(io.ErrUnexpectedEOF)
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) int32() int32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int32(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+}
+
+// read an element count. minSize is the minimum size of an element, to reject broken counts.
+func (r *reader) count(minSize int) int {
+	n := int(r.int32())
+	if r.err == nil && (n < 0 || n*minSize > len(r.b)-r.pos) {
+		r.fail(fmt.Errorf("invalid count %d", n))
+		return 0
+	}
+	return n
+}
+
+// read a string: a length padded to 4 bytes, and zero-padded ShiftJIS bytes
+func (r *reader) str() string {
+	b := r.bytes(r.count(1))
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	s, err := sjisDecoder.Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
+
+// read a block: type, size, name and value
+func (r *reader) block() (b Block, err error) {
+	t := ValueType(r.int32())
+	size := r.count(1)
+	end := r.pos + size
+	b.Name = r.str()
+	b.Value, err = r.value(t, nil)
+	if err == nil {
+		err = r.err
+	}
+	if err == nil && r.pos != end {
+		err = fmt.Errorf("block %q: size mismatch", b.Name)
+	}
+	return
+}
+
+// read a value of type t. parent is the field of the cell, or nil if the value is not a table cell.
+func (r *reader) value(t ValueType, parent *Field) (v Value, err error) {
+	v.Type = t
+	switch t {
+	case TypeInt:
+		v.Int = r.int32()
+	case TypeFloat:
+		v.Float = math.Float32frombits(uint32(r.int32()))
+	case TypeString:
+		v.String = r.str()
+	case TypeTable:
+		v.Table, err = r.table(parent)
+	case TypeList:
+		n := r.count(8)
+		v.List = make([]Value, n)
+		for i := range v.List {
+			it := ValueType(r.int32())
+			size := r.count(0)
+			end := r.pos + size
+			v.List[i], err = r.value(it, nil)
+			if err == nil && r.err == nil && r.pos != end {
+				err = fmt.Errorf("list item %d: size mismatch", i)
+			}
+			if err != nil {
+				return
+			}
+		}
+	case TypeTree:
+		v.Tree, err = r.tree()
+	default:
+		err = fmt.Errorf("unknown value type %d", t)
+	}
+	if err == nil {
+		err = r.err
+	}
+	return
+}
+
+// read table fields
+func (r *reader) fields() (fields []Field, err error) {
+	fields = make([]Field, r.count(16))
+	for i := range fields {
+		f := &fields[i]
+		f.Type = ValueType(r.int32())
+		f.Name = r.str()
+		f.HasValue = r.int32() != 0
+		f.IsIndex = r.int32() != 0
+		if f.HasValue {
+			f.Value, err = r.value(f.Type, nil)
+			if err != nil {
+				return
+			}
+		}
+		if f.Type == TypeTable {
+			f.Fields, err = r.fields()
+			if err != nil {
+				return
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	return
+}
+
+// read a table. fields of sub-tables are defined in the field of the parent table.
+func (r *reader) table(parent *Field) (t *Table, err error) {
+	t = new(Table)
+	fields := []Field(nil)
+	if parent == nil {
+		fields, err = r.fields()
+		if err != nil {
+			return
+		}
+	} else {
+		fields = parent.Fields
+	}
+	t.Fields = fields
+	nRows := r.count(4)
+	nCols := r.count(0)
+	if r.err == nil && nCols != len(fields) {
+		return nil, fmt.Errorf("table has %d columns but %d fields", nCols, len(fields))
+	}
+	t.Rows = make([][]Value, nRows)
+	for i := range t.Rows {
+		row := make([]Value, nCols)
+		for j := range row {
+			ct := ValueType(r.int32())
+			if r.err == nil && ct != fields[j].Type {
+				return nil, fmt.Errorf("cell (%d, %d) has type %v but the field is %v", i, j, ct, fields[j].Type)
+			}
+			row[j], err = r.value(ct, &fields[j])
+			if err != nil {
+				return
+			}
+		}
+		t.Rows[i] = row
+	}
+	return t, r.err
+}
+
+// read a tree node
+func (r *reader) tree() (t *Tree, err error) {
+	t = new(Tree)
+	if r.int32() != 0 {
+		// a leaf
+		var b Block
+		b, err = r.block()
+		if err != nil {
+			return
+		}
+		t.Leaf = &b
+		if r.int32() != 0 && r.err == nil {
+			return nil, fmt.Errorf("tree leaf %q is not terminated", b.Name)
+		}
+		return t, r.err
+	}
+	t.Children = make([]TreeNode, r.count(8))
+	for i := range t.Children {
+		t.Children[i].Name = r.str()
+		t.Children[i].Tree, err = r.tree()
+		if err != nil {
+			return
+		}
+	}
+	return t, r.err
+}
@@ -0,0 +1,153 @@
+package ex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+)
+
+func testEX() *EX {
+	subFields := []Field{{Type: TypeInt, Name: "n"}, {Type: TypeString, Name: "s"}}
+	fields := []Field{
+		{Type: TypeInt, Name: "id", IsIndex: true},
+		{Type: TypeFloat, Name: "rate", HasValue: true, Value: Value{Type: TypeFloat, Float: 0.5}},
+		{Type: TypeString, Name: "名前"},
+		{Type: TypeTable, Name: "sub", Fields: subFields},
+	}
+	sub := &Table{Fields: subFields, Rows: [][]Value{
+		{{Type: TypeInt, Int: 7}, {Type: TypeString, String: "x"}},
+	}}
+	return &EX{Blocks: []Block{
+		{Name: "int", Value: Value{Type: TypeInt, Int: -3}},
+		{Name: "float", Value: Value{Type: TypeFloat, Float: 1.25}},
+		{Name: "string", Value: Value{Type: TypeString, String: "ランス"}},
+		{Name: "table", Value: Value{Type: TypeTable, Table: &Table{Fields: fields, Rows: [][]Value{
+			{{Type: TypeInt, Int: 1}, {Type: TypeFloat, Float: 0.1}, {Type: TypeString, String: "abcd"}, {Type: TypeTable, Table: sub}},
+			{{Type: TypeInt, Int: 2}, {Type: TypeFloat, Float: 2}, {Type: TypeString, String: ""}, {Type: TypeTable, Table: &Table{Fields: subFields, Rows: [][]Value{}}}},
+		}}}},
+		{Name: "list", Value: Value{Type: TypeList, List: []Value{
+			{Type: TypeInt, Int: 1},
+			{Type: TypeString, String: "two"},
+			{Type: TypeList, List: []Value{}},
+		}}},
+		{Name: "tree", Value: Value{Type: TypeTree, Tree: &Tree{Children: []TreeNode{
+			{Name: "a", Tree: &Tree{Leaf: &Block{Name: "leaf", Value: Value{Type: TypeInt, Int: 9}}}},
+			{Name: "b", Tree: &Tree{Children: []TreeNode{}}},
+		}}}},
+	}}
+}
+
+func TestEXRoundTrip(t *testing.T) {
+	src := testEX()
+	data, err := src.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceafa.DetectContentType(data) != aliceafa.ContentEX {
+		t.Errorf("content type not detected")
+	}
+	ex, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ex, src) {
+		t.Errorf("decoded ex differs")
+	}
+	if b := ex.Block("string"); b == nil || b.Value.String != "ランス" {
+		t.Errorf("block not found")
+	}
+
+	// JSON
+	var buf bytes.Buffer
+	err = ex.WriteJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"float": 0.1`) || !strings.Contains(buf.String(), `"Type": "int"`) {
+		t.Errorf("unexpected JSON:\n%s", buf.String())
+	}
+	ex, err = ReadJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ex, src) {
+		t.Errorf("ex from JSON differs")
+	}
+
+	// through the decoder registry
+	v, export, err := aliceafa.DecodeContent(aliceafa.ContentEX, bytes.NewReader(data), nil)
+	if err != nil || export != aliceafa.ExportJSON || !reflect.DeepEqual(v, src) {
+		t.Errorf("registry decode failed: %v", err)
+	}
+
+	// broken values
+	ex.Blocks[0].Value.Type = 99
+	if _, err = ex.Encode(); err == nil {
+		t.Errorf("unknown type must fail")
+	}
+	if _, err = ReadJSON(strings.NewReader(`{"Blocks":[{"Name":"x","Value":{"int":1,"float":2}}]}`)); err == nil {
+		t.Errorf("value with two types must fail")
+	}
+}
+
+func TestEXBrokenCount(t *testing.T) {
+	data, err := testEX().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range []int{16, 28} { // BlockCount and DecompressedSize
+		b := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(b[off:], 0xffffffff)
+		if _, err := Decode(b); err == nil {
+			t.Errorf("broken count at %d must fail", off)
+		}
+	}
+}
+
+func TestEXKey(t *testing.T) {
+	key := make([]byte, 256)
+	for i := range key {
+		key[i] = byte(i) ^ 0xa5
+	}
+	err := SetKeyTable(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := testEX().Encode()
+	SetKeyTable(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Decode(data)
+	if !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey, got %v", err)
+	}
+	SetKeyTable(key)
+	_, err = Decode(data)
+	SetKeyTable(nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// unencrypted data is read with the key set
+	data, err = testEX().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyTable(key)
+	_, err = Decode(data)
+	SetKeyTable(nil)
+	if err != nil {
+		t.Errorf("unencrypted data with the key set: %v", err)
+	}
+
+	key[1] = key[0]
+	if SetKeyTable(key) == nil {
+		t.Errorf("non-permutation key must fail")
+	}
+}
@@ -0,0 +1,112 @@
+package ex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// name of the value type in JSON
+func (t ValueType) MarshalText() ([]byte, error) {
+	switch t {
+	case TypeInt, TypeFloat, TypeString, TypeTable, TypeList, TypeTree:
+		return []byte(t.String()), nil
+	}
+	return nil, fmt.Errorf("unknown value type %d", int(t))
+}
+
+func (t *ValueType) UnmarshalText(b []byte) error {
+	for _, v := range []ValueType{TypeInt, TypeFloat, TypeString, TypeTable, TypeList, TypeTree} {
+		if v.String() == string(b) {
+			*t = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown value type %q", b)
+}
+
+// A value is a JSON object with a single member of the type name, as in {"int": 1} or {"string": "text"}.
+// A zero Value is null.
+func (v Value) MarshalJSON() ([]byte, error) {
+	var m interface{}
+	switch v.Type {
+	case 0:
+		return []byte("null"), nil
+	case TypeInt:
+		m = v.Int
+	case TypeFloat:
+		m = json.Number(strconv.FormatFloat(float64(v.Float), 'g', -1, 32))
+	case TypeString:
+		m = v.String
+	case TypeTable:
+		m = v.Table
+	case TypeList:
+		if v.List == nil {
+			m = []Value{}
+		} else {
+			m = v.List
+		}
+	case TypeTree:
+		m = v.Tree
+	default:
+		return nil, fmt.Errorf("unknown value type %d", int(v.Type))
+	}
+	return json.Marshal(map[string]interface{}{v.Type.String(): m})
+}
+
+func (v *Value) UnmarshalJSON(b []byte) (err error) {
+	*v = Value{}
+	if string(bytes.TrimSpace(b)) == "null" {
+		return nil
+	}
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return
+	}
+	if len(m) != 1 {
+		return fmt.Errorf("a value must have exactly one type")
+	}
+	for k, raw := range m {
+		err = v.Type.UnmarshalText([]byte(k))
+		if err != nil {
+			return
+		}
+		switch v.Type {
+		case TypeInt:
+			err = json.Unmarshal(raw, &v.Int)
+		case TypeFloat:
+			err = json.Unmarshal(raw, &v.Float)
+		case TypeString:
+			err = json.Unmarshal(raw, &v.String)
+		case TypeTable:
+			err = json.Unmarshal(raw, &v.Table)
+		case TypeList:
+			err = json.Unmarshal(raw, &v.List)
+		case TypeTree:
+			err = json.Unmarshal(raw, &v.Tree)
+		}
+	}
+	return
+}
+
+// Write the .ex as indented JSON text.
+func (p *EX) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// Read an .ex from JSON text written by WriteJSON.
+func ReadJSON(r io.Reader) (ex *EX, err error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	ex = new(EX)
+	err = dec.Decode(ex)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
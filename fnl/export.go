@@ -0,0 +1,107 @@
+package fnl
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Number of glyphs in a row of the glyph atlas.
+const AtlasColumns = 32
+
+// Codes of the glyphs present in the face, in the order of the glyph index.
+func (p *Face) Codes() (codes []int) {
+	for i, g := range p.Glyphs {
+		if len(g.Data) > 0 {
+			codes = append(codes, GlyphCode(i))
+		}
+	}
+	return
+}
+
+// Draw the glyphs of the face on a grid of AtlasColumns, in the order of Codes().
+// Glyphs are white on a transparent background.
+func (p *Face) Atlas() (img *image.Alpha, codes []int, err error) {
+	codes = p.Codes()
+	rows := (len(codes) + AtlasColumns - 1) / AtlasColumns
+	img = image.NewAlpha(image.Rect(0, 0, AtlasColumns*p.Height, rows*p.Height))
+	for i, code := range codes {
+		var bitmap []byte
+		bitmap, err = p.Bitmap(p.Glyph(code))
+		if err != nil {
+			return nil, nil, fmt.Errorf("glyph 0x%04x: %w", code, err)
+		}
+		p.draw(img, image.Pt(i%AtlasColumns*p.Height, i/AtlasColumns*p.Height), bitmap)
+	}
+	return
+}
+
+// Unicode code point of a ShiftJIS code. Returns -1 if the code is not a character.
+func CodeToRune(code int) rune {
+	var b []byte
+	if code < 0x100 {
+		b = []byte{byte(code)}
+	} else {
+		b = []byte{byte(code >> 8), byte(code)}
+	}
+	s, err := japanese.ShiftJIS.NewDecoder().Bytes(b)
+	if err != nil {
+		return -1
+	}
+	r, n := utf8.DecodeRune(s)
+	if r == utf8.RuneError || n != len(s) {
+		return -1
+	}
+	return r
+}
+
+// Write the face as a BDF font, encoded in Unicode.
+// Glyphs without a Unicode mapping are written with ENCODING -1 and the ShiftJIS code.
+func (p *Face) WriteBDF(w io.Writer, name string) (err error) {
+	if p.Height <= 0 {
+		return fmt.Errorf("invalid face height %d", p.Height)
+	}
+	codes := p.Codes()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "STARTFONT 2.1\n")
+	fmt.Fprintf(bw, "FONT %s-%d\n", name, p.Height)
+	fmt.Fprintf(bw, "SIZE %d 72 72\n", p.Height)
+	fmt.Fprintf(bw, "FONTBOUNDINGBOX %d %d 0 0\n", p.Height, p.Height)
+	fmt.Fprintf(bw, "STARTPROPERTIES 5\n")
+	fmt.Fprintf(bw, "PIXEL_SIZE %d\n", p.Height)
+	fmt.Fprintf(bw, "FONT_ASCENT %d\n", p.Height)
+	fmt.Fprintf(bw, "FONT_DESCENT 0\n")
+	fmt.Fprintf(bw, "CHARSET_REGISTRY \"ISO10646\"\n")
+	fmt.Fprintf(bw, "CHARSET_ENCODING \"1\"\n")
+	fmt.Fprintf(bw, "ENDPROPERTIES\n")
+	fmt.Fprintf(bw, "CHARS %d\n", len(codes))
+	stride := p.stride()
+	for _, code := range codes {
+		g := p.Glyph(code)
+		var bitmap []byte
+		bitmap, err = p.Bitmap(g)
+		if err != nil {
+			return fmt.Errorf("glyph 0x%04x: %w", code, err)
+		}
+		fmt.Fprintf(bw, "STARTCHAR sjis%04x\n", code)
+		if r := CodeToRune(code); r >= 0 {
+			fmt.Fprintf(bw, "ENCODING %d\n", r)
+		} else {
+			fmt.Fprintf(bw, "ENCODING -1 %d\n", code)
+		}
+		fmt.Fprintf(bw, "SWIDTH %d 0\n", g.Width*1000/p.Height)
+		fmt.Fprintf(bw, "DWIDTH %d 0\n", g.Width)
+		fmt.Fprintf(bw, "BBX %d %d 0 0\n", p.Height, p.Height)
+		fmt.Fprintf(bw, "BITMAP\n")
+		for y := 0; y < p.Height; y++ {
+			fmt.Fprintf(bw, "%X\n", bitmap[y*stride:(y+1)*stride])
+		}
+		fmt.Fprintf(bw, "ENDCHAR\n")
+	}
+	fmt.Fprintf(bw, "ENDFONT\n")
+	return bw.Flush()
+}
@@ -0,0 +1,309 @@
+// Package fnl reads .fnl font files of AliceSoft games.
+//
+// An .fnl file has a list of fonts, each with faces of several pixel sizes.
+// A glyph of a face is a zlib-compressed 1-bit bitmap, indexed by ShiftJIS code.
+package fnl
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid fnl format")
+)
+
+// .fnl file header
+type fnlHeader struct {
+	Signature  [4]byte // "FNA\0"
+	Version    uint32
+	FileSize   uint32
+	DataOffset uint32 // offset of the glyph data
+}
+
+// A glyph of a face.
+type Glyph struct {
+	Width int    // advance width in pixels
+	Data  []byte // zlib-compressed bitmap. empty if the face has no glyph for the code
+}
+
+// Largest face height accepted by Decode. Real faces are tens of pixels.
+const maxFaceHeight = 256
+
+// A face of a font, of a pixel size.
+type Face struct {
+	Height  int
+	Unknown uint32
+	Glyphs  []Glyph // indexed by GlyphIndex()
+}
+
+// A font.
+type Font struct {
+	Faces []*Face
+}
+
+// A decoded .fnl file.
+type FNL struct {
+	Version int
+	Fonts   []*Font
+}
+
+// Read an .fnl file.
+func Read(r io.Reader) (fnl *FNL, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return Decode(data)
+}
+
+// Decode an .fnl file.
+func Decode(data []byte) (fnl *FNL, err error) {
+	var hdr fnlHeader
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr)
+	if err != nil || string(hdr.Signature[:]) != "FNA\x00" {
+		return nil, ErrInvalidFormat
+	}
+	if int64(hdr.FileSize) > int64(len(data)) || hdr.DataOffset > hdr.FileSize {
+		return nil, ErrInvalidFormat
+	}
+	data = data[:hdr.FileSize]
+
+	fnl = &FNL{Version: int(hdr.Version)}
+	r := &reader{b: data[:hdr.DataOffset], pos: binary.Size(hdr)}
+	fnl.Fonts = make([]*Font, r.count(4))
+	for i := range fnl.Fonts {
+		font := &Font{Faces: make([]*Face, r.count(12))}
+		for j := range font.Faces {
+			face := &Face{Height: int(r.uint32()), Unknown: r.uint32()}
+			if r.err == nil && (face.Height <= 0 || face.Height > maxFaceHeight) {
+				return nil, fmt.Errorf("face %d/%d has an invalid height %d", i, j, face.Height)
+			}
+			face.Glyphs = make([]Glyph, r.count(12))
+			for k := range face.Glyphs {
+				g := &face.Glyphs[k]
+				g.Width = int(r.uint32())
+				pos, size := int64(r.uint32()), int64(r.uint32())
+				if r.err != nil {
+					break
+				}
+				if size == 0 {
+					continue
+				}
+				if pos < int64(hdr.DataOffset) || pos+size > int64(len(data)) {
+					return nil, fmt.Errorf("glyph data of face %d/%d/%d is out of range", i, j, k)
+				}
+				g.Data = data[pos : pos+size]
+			}
+			font.Faces[j] = face
+		}
+		fnl.Fonts[i] = font
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return
+}
+
+// little-endian reader of the font index
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+4 > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(r.b[r.pos:])
+	r.pos += 4
+	return v
+}
+
+func (r *reader) count(minSize int) int {
+	n := int64(r.uint32())
+	if r.err == nil && n*int64(minSize) > int64(len(r.b)-r.pos) {
+		r.err = fmt.Errorf("invalid count %d", n)
+		return 0
+	}
+	return int(n)
+}
+
+// Glyph index of a ShiftJIS code.
+// Single-byte codes are indexed by themselves, and a double-byte code is indexed from 0x100
+// in the order of the lead bytes 0x81-0x9f, 0xe0-0xfc and the trail bytes 0x40-0xff.
+// Returns -1 for an invalid code.
+func GlyphIndex(code int) int {
+	if code >= 0 && code < 0x100 {
+		return code
+	}
+	lead, trail := code>>8, code&0xff
+	switch {
+	case lead >= 0x81 && lead <= 0x9f:
+		lead -= 0x81
+	case lead >= 0xe0 && lead <= 0xfc:
+		lead -= 0xe0 - 0x1f
+	default:
+		return -1
+	}
+	if trail < 0x40 {
+		return -1
+	}
+	return 0x100 + lead*0xc0 + trail - 0x40
+}
+
+// ShiftJIS code of a glyph index. The reverse of GlyphIndex().
+func GlyphCode(index int) int {
+	if index < 0x100 {
+		return index
+	}
+	index -= 0x100
+	lead, trail := index/0xc0, index%0xc0+0x40
+	if lead < 0x1f {
+		lead += 0x81
+	} else {
+		lead += 0xe0 - 0x1f
+	}
+	return lead<<8 | trail
+}
+
+// Glyph of a ShiftJIS code. Returns nil if the face has no glyph for the code.
+func (p *Face) Glyph(code int) *Glyph {
+	i := GlyphIndex(code)
+	if i < 0 || i >= len(p.Glyphs) || len(p.Glyphs[i].Data) == 0 {
+		return nil
+	}
+	return &p.Glyphs[i]
+}
+
+// bytes per row of the glyph bitmaps
+func (p *Face) stride() int {
+	return (p.Height + 7) / 8
+}
+
+// Decompress the bitmap of a glyph.
+// The bitmap is a square of the face height, one bit per pixel from the MSB, and each row is padded to bytes.
+func (p *Face) Bitmap(g *Glyph) (bitmap []byte, err error) {
+	zr, err := zlib.NewReader(bytes.NewReader(g.Data))
+	if err != nil {
+		return
+	}
+	defer zr.Close()
+	bitmap = make([]byte, p.stride()*p.Height)
+	_, err = io.ReadFull(zr, bitmap)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Set the bitmap of a glyph. The bitmap must be in the layout of Bitmap().
+func (p *Face) SetBitmap(g *Glyph, bitmap []byte) (err error) {
+	if len(bitmap) != p.stride()*p.Height {
+		return fmt.Errorf("bitmap size %d does not match the face height %d", len(bitmap), p.Height)
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	_, err = zw.Write(bitmap)
+	if err != nil {
+		return
+	}
+	err = zw.Close()
+	if err != nil {
+		return
+	}
+	g.Data = z.Bytes()
+	return
+}
+
+// Decode a glyph to an image, white on a transparent background.
+func (p *Face) Image(g *Glyph) (img *image.Alpha, err error) {
+	bitmap, err := p.Bitmap(g)
+	if err != nil {
+		return
+	}
+	img = image.NewAlpha(image.Rect(0, 0, p.Height, p.Height))
+	p.draw(img, image.Point{}, bitmap)
+	return
+}
+
+// draw a bitmap on an image
+func (p *Face) draw(img *image.Alpha, at image.Point, bitmap []byte) {
+	stride := p.stride()
+	for y := 0; y < p.Height; y++ {
+		for x := 0; x < p.Height; x++ {
+			if bitmap[y*stride+x/8]&(0x80>>(x%8)) != 0 {
+				img.SetAlpha(at.X+x, at.Y+y, color.Alpha{0xff})
+			}
+		}
+	}
+}
+
+// Encode the .fnl file.
+func (p *FNL) Encode() (data []byte, err error) {
+	// size of the index
+	indexSize := binary.Size(fnlHeader{}) + 4
+	for _, font := range p.Fonts {
+		indexSize += 4
+		for _, face := range font.Faces {
+			indexSize += 12 + 12*len(face.Glyphs)
+		}
+	}
+
+	var index, glyphs bytes.Buffer
+	put := func(v uint32) {
+		binary.Write(&index, binary.LittleEndian, v)
+	}
+	put(uint32(len(p.Fonts)))
+	for _, font := range p.Fonts {
+		put(uint32(len(font.Faces)))
+		for _, face := range font.Faces {
+			put(uint32(face.Height))
+			put(face.Unknown)
+			put(uint32(len(face.Glyphs)))
+			for _, g := range face.Glyphs {
+				put(uint32(g.Width))
+				if len(g.Data) == 0 {
+					put(0)
+				} else {
+					put(uint32(indexSize + glyphs.Len()))
+				}
+				put(uint32(len(g.Data)))
+				glyphs.Write(g.Data)
+			}
+		}
+	}
+
+	hdr := fnlHeader{
+		Signature:  [4]byte{'F', 'N', 'A', 0},
+		Version:    uint32(p.Version),
+		FileSize:   uint32(indexSize + glyphs.Len()),
+		DataOffset: uint32(indexSize),
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	buf.Write(index.Bytes())
+	buf.Write(glyphs.Bytes())
+	return buf.Bytes(), nil
+}
+
+// Write the .fnl file.
+func (p *FNL) Write(w io.Writer) (err error) {
+	data, err := p.Encode()
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
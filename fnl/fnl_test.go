@@ -0,0 +1,122 @@
+package fnl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+)
+
+func TestGlyphIndex(t *testing.T) {
+	for _, code := range []int{0x20, 0x41, 0xb1, 0x8140, 0x82a0, 0x9ffc, 0xe040, 0xeaa4, 0xfcfc} {
+		i := GlyphIndex(code)
+		if i < 0 || GlyphCode(i) != code {
+			t.Errorf("code 0x%04x: index %d, back to 0x%04x", code, i, GlyphCode(i))
+		}
+	}
+	for _, code := range []int{0x8000, 0xa040, 0x813f} {
+		if GlyphIndex(code) != -1 {
+			t.Errorf("code 0x%04x must be invalid", code)
+		}
+	}
+}
+
+func TestFNL(t *testing.T) {
+	// a 10-pixel face with 'A' and 'あ'
+	face := &Face{Height: 10, Glyphs: make([]Glyph, GlyphIndex(0x82a0)+1)}
+	bitmapA := make([]byte, 2*10)
+	bitmapA[0] = 0x80  // top-left pixel
+	bitmapA[19] = 0x40 // bottom-right pixel
+	bitmapHira := bytes.Repeat([]byte{0xff, 0xc0}, 10)
+	gA, gHira := &face.Glyphs[0x41], &face.Glyphs[GlyphIndex(0x82a0)]
+	gA.Width, gHira.Width = 5, 10
+	if err := face.SetBitmap(gA, bitmapA); err != nil {
+		t.Fatal(err)
+	}
+	if err := face.SetBitmap(gHira, bitmapHira); err != nil {
+		t.Fatal(err)
+	}
+	src := &FNL{Fonts: []*Font{{Faces: []*Face{face}}}}
+
+	data, err := src.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceafa.DetectContentType(data) != aliceafa.ContentFNL {
+		t.Errorf("content type not detected")
+	}
+	fnl, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fnl, src) {
+		t.Fatalf("decoded fnl differs")
+	}
+
+	f := fnl.Fonts[0].Faces[0]
+	if !reflect.DeepEqual(f.Codes(), []int{0x41, 0x82a0}) {
+		t.Errorf("unexpected codes %v", f.Codes())
+	}
+	if f.Glyph(0x42) != nil {
+		t.Errorf("missing glyph must be nil")
+	}
+	img, err := f.Image(f.Glyph(0x41))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.AlphaAt(0, 0).A != 0xff || img.AlphaAt(9, 9).A != 0xff || img.AlphaAt(1, 0).A != 0 {
+		t.Errorf("unexpected glyph image")
+	}
+
+	// atlas
+	atlas, codes, err := f.Atlas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || atlas.Bounds().Dx() != AtlasColumns*10 || atlas.Bounds().Dy() != 10 {
+		t.Errorf("unexpected atlas size %v", atlas.Bounds())
+	}
+	if atlas.AlphaAt(10, 0).A != 0xff || atlas.AlphaAt(19, 9).A != 0xff || atlas.AlphaAt(20, 0).A != 0 {
+		t.Errorf("unexpected atlas image")
+	}
+
+	// BDF
+	var buf bytes.Buffer
+	err = f.WriteBDF(&buf, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdf := buf.String()
+	for _, s := range []string{
+		"CHARS 2\n",
+		"STARTCHAR sjis0041\nENCODING 65\nSWIDTH 500 0\nDWIDTH 5 0\nBBX 10 10 0 0\nBITMAP\n8000\n",
+		"ENCODING 12354\n", // U+3042
+		"FFC0\nENDCHAR\nENDFONT\n",
+	} {
+		if !strings.Contains(bdf, s) {
+			t.Errorf("BDF does not contain %q:\n%s", s, bdf)
+		}
+	}
+
+	// errors
+	if _, err := Decode(data[:20]); err == nil {
+		t.Errorf("truncated data must fail")
+	}
+	hpos := binary.Size(fnlHeader{}) + 8 // height of the first face
+	if binary.LittleEndian.Uint32(data[hpos:]) != 10 {
+		t.Fatalf("face height not found")
+	}
+	for _, h := range []uint32{0, 257, 0xffffffff} {
+		broken := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(broken[hpos:], h)
+		if _, err := Decode(broken); err == nil {
+			t.Errorf("face height %d must fail", h)
+		}
+	}
+	if err := face.SetBitmap(gA, bitmapA[1:]); err == nil {
+		t.Errorf("wrong bitmap size must fail")
+	}
+}
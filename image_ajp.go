@@ -0,0 +1,149 @@
+package aliceafa
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+func init() {
+	image.RegisterFormat("ajp", "AJP\x00", decodeAJP, DecodeAJPConfig)
+}
+
+// first 16 bytes of the JPEG stream and the mask are XORed with this key
+var ajpKey = []byte{
+	0x5d, 0x91, 0xae, 0x87, 0x4a, 0x56, 0x41, 0xcd,
+	0x83, 0xec, 0x4c, 0x92, 0xb5, 0xcb, 0x16, 0x34,
+}
+
+// AJP file header
+type ajpHeader struct {
+	//+0x00
+	Signature     []byte `binary:"[4]byte"` // "AJP\0"
+	Version       int    `binary:"uint32"`
+	HeaderSize    int    `binary:"uint32"` // usually 0x38
+	Width, Height int    `binary:"uint32"`
+	//+0x14
+	JPEGOffset, JPEGSize int `binary:"uint32"`
+	MaskOffset, MaskSize int `binary:"uint32"` // the mask is an 8-bit PMS image
+	//+0x24
+	Unknown1 []byte `binary:"[16]byte"`
+	Unknown2 int    `binary:"uint32"`
+	//+0x38
+}
+
+// read AJP file header
+func readAJPHeader(r io.Reader) (hdr *ajpHeader, err error) {
+	hdr = new(ajpHeader)
+	_, err = bst.Read(r, bst.LittleEndian, hdr)
+	if err != nil {
+		return nil, err
+	}
+	if string(hdr.Signature) != "AJP\x00" {
+		return nil, ErrInvalidFormat
+	}
+	return
+}
+
+// XOR the first bytes of AJP stream
+func ajpDecrypt(data []byte) {
+	for i := 0; i < len(ajpKey) && i < len(data); i++ {
+		data[i] ^= ajpKey[i]
+	}
+}
+
+// Load AJP image.
+// AJP is a JPEG image with a separate alpha mask.
+func LoadAJP(rs io.ReadSeeker) (img *image.NRGBA, err error) {
+	hdr, err := readAJPHeader(rs)
+	if err != nil {
+		return
+	}
+
+	// read a part of the file
+	readPart := func(offset, size int) (data []byte, err error) {
+		_, err = rs.Seek(int64(offset), io.SeekStart)
+		if err != nil {
+			return
+		}
+		data = make([]byte, size)
+		_, err = io.ReadFull(rs, data)
+		if err != nil {
+			return
+		}
+		ajpDecrypt(data)
+		return
+	}
+
+	// decode JPEG stream
+	jpegData, err := readPart(hdr.JPEGOffset, hdr.JPEGSize)
+	if err != nil {
+		return
+	}
+	jpegImg, err := jpeg.Decode(bytes.NewReader(jpegData))
+	if err != nil {
+		return
+	}
+	bounds := jpegImg.Bounds()
+	img = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Rect, jpegImg, bounds.Min, draw.Src)
+
+	if hdr.MaskSize == 0 {
+		// no alpha
+		return
+	}
+
+	// decode the alpha mask
+	maskData, err := readPart(hdr.MaskOffset, hdr.MaskSize)
+	if err != nil {
+		return
+	}
+	pmsHdr, err := readPMSHeader(bytes.NewReader(maskData))
+	if err != nil {
+		return
+	}
+	if pmsHdr.ColorDepth != 8 {
+		err = fmt.Errorf("unsupported mask bit depth; must be 8 but has %d", pmsHdr.ColorDepth)
+		return
+	}
+	if pmsHdr.Width != img.Rect.Dx() || pmsHdr.Height != img.Rect.Dy() {
+		err = fmt.Errorf("mask size mismatch")
+		return
+	}
+	if pmsHdr.DataOffset > len(maskData) {
+		err = ErrInvalidFormat
+		return
+	}
+	alpha, err := decodePMS8(bytes.NewReader(maskData[pmsHdr.DataOffset:]), pmsHdr.Width, pmsHdr.Height)
+	if err != nil {
+		return
+	}
+	for i, a := range alpha {
+		img.Pix[i*4+3] = a
+	}
+	return
+}
+
+// AJP decoder for image.Decode().
+func decodeAJP(r io.Reader) (img image.Image, err error) {
+	rs, err := asReadSeeker(r)
+	if err != nil {
+		return
+	}
+	return LoadAJP(rs)
+}
+
+// Read the dimension and the color model of an AJP image without decoding the image.
+func DecodeAJPConfig(r io.Reader) (cfg image.Config, err error) {
+	hdr, err := readAJPHeader(r)
+	if err != nil {
+		return
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: hdr.Width, Height: hdr.Height}, nil
+}
@@ -0,0 +1,98 @@
+package aliceafa
+
+import (
+	"container/list"
+	"image"
+	"sync"
+)
+
+// LRU cache of decoded images.
+// Images are evicted in least-recently-used order when the total pixel memory exceeds the budget.
+// Cached images are shared and must not be modified.
+type ImageCache struct {
+	mu     sync.Mutex
+	budget int64 // memory budget in bytes
+	size   int64 // current memory usage in bytes
+	lru    *list.List
+	items  map[string]*list.Element
+}
+
+type imageCacheItem struct {
+	key  string
+	img  image.Image
+	size int64
+}
+
+// Make an image cache with the memory budget in bytes.
+func NewImageCache(budget int64) *ImageCache {
+	return &ImageCache{
+		budget: budget,
+		lru:    list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+// approximate memory size of an image
+func imageMemSize(img image.Image) int64 {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		return int64(len(m.Pix))
+	case *image.RGBA:
+		return int64(len(m.Pix))
+	case *image.Paletted:
+		return int64(len(m.Pix) + len(m.Palette)*4)
+	}
+	r := img.Bounds()
+	return int64(r.Dx()) * int64(r.Dy()) * 4
+}
+
+// Get a cached image.
+func (c *ImageCache) Get(key string) (img image.Image, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*imageCacheItem).img, true
+}
+
+// Put an image into the cache.
+// An image larger than the whole budget is not cached.
+func (c *ImageCache) Put(key string, img image.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	sz := imageMemSize(img)
+	if sz > c.budget {
+		return
+	}
+	for c.size+sz > c.budget {
+		c.remove(c.lru.Back())
+	}
+	c.items[key] = c.lru.PushFront(&imageCacheItem{key: key, img: img, size: sz})
+	c.size += sz
+}
+
+// Number of cached images.
+func (c *ImageCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Total memory size of cached images in bytes.
+func (c *ImageCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *ImageCache) remove(e *list.Element) {
+	item := c.lru.Remove(e).(*imageCacheItem)
+	delete(c.items, item.key)
+	c.size -= item.size
+}
@@ -5,6 +5,7 @@ import (
 	"compress/zlib"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
 
 	bst "github.com/mixcode/binarystruct"
@@ -13,21 +14,34 @@ import (
 
 var (
 	sjisDecoder = japanese.ShiftJIS.NewDecoder()
+	sjisEncoder = japanese.ShiftJIS.NewEncoder()
 )
 
-// DCF is QNF file with independent alpha masks.
-// returned baseImageName contains the base image filename that should be overlayed on.
-func LoadDCF(rs io.ReadSeeker) (img image.Image, baseImageName string, err error) {
+const (
+	// each alpha mask byte of DCF represents a 16x16 pixel block
+	DCFBlockWidth, DCFBlockHeight = 16, 16
+)
+
+func init() {
+	image.RegisterFormat("dcf", "dcf ", decodeDCF, DecodeDCFConfig)
+}
 
-	readSz := int64(0)
+// DCF file header
+type dcfHeader struct {
+	Unknown1      int // usually 01. Version?
+	Width, Height int // image dimension
+	Unknown2      int // usually 0x20
+	BaseImageName string
+}
 
+// read the "dcf " header chunk of a DCF file
+func readDCFHeader(r io.Reader) (hdr *dcfHeader, err error) {
 	type ChunkHeader struct {
 		Signature string `binary:"[4]byte"`
 		Len       int    `binary:"uint32"` // length of the following data body
 	}
 
-	// read DCF file header chuk
-	var dcfHeader struct {
+	var dcfHeaderChunk struct {
 		ChunkHeader
 		Unknown1         int    `binary:"uint32"` // usually 01. Version?
 		Width, Height    int    `binary:"uint32"` // image dimension
@@ -35,33 +49,78 @@ func LoadDCF(rs io.ReadSeeker) (img image.Image, baseImageName string, err error
 		BaseImageNameLen int    `binary:"uint32"`
 		BaseImageName    []byte `binary:"[BaseImageNameLen]byte"` // name of the base image
 	}
-	sz, err := bst.Read(rs, bst.LittleEndian, &dcfHeader)
+	sz, err := bst.Read(r, bst.LittleEndian, &dcfHeaderChunk)
 	if err != nil {
 		return
 	}
-	if sz != dcfHeader.Len+8 {
+	if sz != dcfHeaderChunk.Len+8 {
 		// overrun
 		err = ErrInvalidFormat
 		return
 	}
-	readSz += int64(sz)
-	if dcfHeader.Signature != "dcf " {
+	if dcfHeaderChunk.Signature != "dcf " {
 		err = ErrInvalidFormat
 		return
 	}
 
 	// decode base image name
 	// the base name is ShiftJIS code bytes rotate-righted by (length%7 + 1)
-	rot := len(dcfHeader.BaseImageName)%7 + 1
-	for i, b := range dcfHeader.BaseImageName {
+	rot := len(dcfHeaderChunk.BaseImageName)%7 + 1
+	for i, b := range dcfHeaderChunk.BaseImageName {
 		// rotate left to recover ShiftJIS codes
-		dcfHeader.BaseImageName[i] = (b << rot) | (b >> (8 - rot))
+		dcfHeaderChunk.BaseImageName[i] = (b << rot) | (b >> (8 - rot))
+	}
+	baseNameBytes, err := sjisDecoder.Bytes(dcfHeaderChunk.BaseImageName)
+	if err != nil {
+		return
+	}
+
+	hdr = &dcfHeader{
+		Unknown1:      dcfHeaderChunk.Unknown1,
+		Width:         dcfHeaderChunk.Width,
+		Height:        dcfHeaderChunk.Height,
+		Unknown2:      dcfHeaderChunk.Unknown2,
+		BaseImageName: string(baseNameBytes),
+	}
+	return
+}
+
+// Components of a DCF image.
+// A DCF image is a variant of a base image. Blocks marked in the mask are taken from the base image,
+// and other blocks are taken from the embedded QNT image.
+type DCF struct {
+	Image *image.NRGBA // the embedded QNT image, without the mask applied
+
+	// Block mask in row-major order. 1 for the blocks to be taken from the base image, 0 for the blocks of Image.
+	// The mask has MaskWidth*MaskHeight bytes.
+	Mask                    []byte
+	MaskWidth, MaskHeight   int // number of mask blocks in horizontal and vertical direction
+	BlockWidth, BlockHeight int // pixel size of a mask block
+
+	Width, Height      int // canvas dimension in the DCF header. may differ from the size of Image
+	Unknown1, Unknown2 int // unknown header values; usually 1 and 0x20
+
+	BaseImageName string // filename of the base image
+}
+
+// Decode a DCF image into its components.
+// An AFF-wrapped image is unwrapped.
+func DecodeDCF(rs io.ReadSeeker) (dcf *DCF, err error) {
+	rs, err = UnwrapAFF(rs)
+	if err != nil {
+		return
+	}
+
+	type ChunkHeader struct {
+		Signature string `binary:"[4]byte"`
+		Len       int    `binary:"uint32"` // length of the following data body
 	}
-	baseNameBytes, err := sjisDecoder.Bytes(dcfHeader.BaseImageName)
+
+	// read DCF file header chuk
+	dcfHeader, err := readDCFHeader(rs)
 	if err != nil {
 		return
 	}
-	baseImageName = string(baseNameBytes)
 
 	// read alpha mask block chunk
 	var alphaChunk struct {
@@ -69,11 +128,10 @@ func LoadDCF(rs io.ReadSeeker) (img image.Image, baseImageName string, err error
 		UncompressedSize int    `binary:"uint32"`
 		Zip              []byte `binary:"[Len - 4]byte"`
 	}
-	sz, err = bst.Read(rs, bst.LittleEndian, &alphaChunk)
+	_, err = bst.Read(rs, bst.LittleEndian, &alphaChunk)
 	if err != nil {
 		return
 	}
-	readSz += int64(sz)
 	if alphaChunk.Signature != "dfdl" {
 		err = ErrInvalidFormat
 		return
@@ -83,6 +141,10 @@ func LoadDCF(rs io.ReadSeeker) (img image.Image, baseImageName string, err error
 		return
 	}
 	// first 4 byte is the number of alpha mask bytes
+	if len(alphaMask) < 4 {
+		err = ErrInvalidFormat
+		return
+	}
 	maskCount := 0
 	for i := 0; i < 4; i++ {
 		maskCount = maskCount | (int(alphaMask[i]) << (8 * i))
@@ -95,82 +157,227 @@ func LoadDCF(rs io.ReadSeeker) (img image.Image, baseImageName string, err error
 
 	// read embedded QNF image
 	var imageChunk ChunkHeader
-	sz, err = bst.Read(rs, bst.LittleEndian, &imageChunk)
+	_, err = bst.Read(rs, bst.LittleEndian, &imageChunk)
 	if err != nil {
 		return
 	}
-	readSz += int64(sz)
 	if imageChunk.Signature != "dcgd" {
 		err = ErrInvalidFormat
 		return
 	}
-	//qnfImg, sz64, err := LoadQNT(fi)
-	//readSz += sz64
 	qnfImg, err := LoadQNT(rs)
 	if err != nil {
 		return
 	}
-	// TODO: use stream counter for actual read size
-	readSz += int64(imageChunk.Len)
 
-	// merge the image and the alpha mask
-	const (
-		// each alpha mask byte represents a 16x16 pixel block
-		blockX, blockY = 16, 16
-	)
-	//xCount := (dcfHeader.Width + blockX - 1) / blockX
-	//yCount := (dcfHeader.Height + blockY - 1) / blockY
-	xCount := dcfHeader.Width / blockX // Note: the mask may be smaller than the image
-	yCount := dcfHeader.Height / blockY
+	// check the mask dimension
+	// the number of blocks may be either rounded up or rounded down if the canvas size is not a multiple of the block size.
+	// if rounded down, the partial blocks on the right and bottom edge are not masked.
+	xCount := (dcfHeader.Width + DCFBlockWidth - 1) / DCFBlockWidth
+	yCount := (dcfHeader.Height + DCFBlockHeight - 1) / DCFBlockHeight
 	if xCount*yCount != maskCount {
-		// log.Printf("dim[%d x %d]", dcfHeader.Width, dcfHeader.Height)
-		// log.Printf("qnt[%d x %d]", qnfImg.Bounds().Dx(), qnfImg.Bounds().Dy())
-		// log.Printf("dimb[%d x %d](%d)", dcfHeader.Width/blockX, dcfHeader.Height/blockY, (dcfHeader.Width/blockX)*(dcfHeader.Height/blockY))
-		err = fmt.Errorf("invalid alpha block size: expected %d, actual %d", xCount*yCount, maskCount)
-		return
+		roundUpCount := xCount * yCount
+		xCount = dcfHeader.Width / DCFBlockWidth
+		yCount = dcfHeader.Height / DCFBlockHeight
+		if xCount*yCount != maskCount {
+			err = fmt.Errorf("invalid alpha block size: expected %d or %d, actual %d", roundUpCount, xCount*yCount, maskCount)
+			return
+		}
+	}
+	for _, maskValue := range alphaMask {
+		if maskValue != 0 && maskValue != 1 { // maskValue is 0 or 1
+			err = fmt.Errorf("unknown alpha mask value")
+			return
+		}
 	}
 
 	// assume that QNF image is in NRGBA format.
 	// NRGBA is Non-alpha-premultiplied RGB, that means 0<=RGB<=255
 	// (plane RGBA is Alpha-premultiplied, that means 0<=RGB<=A)
+	if qnfImg == nil {
+		err = ErrNoImage
+		return
+	}
 	rgbImg, ok := qnfImg.(*image.NRGBA)
 	if !ok {
 		err = fmt.Errorf("image is not in NRGBA format")
 		return
 	}
-	k := 0
-	for i := 0; i < yCount; i++ { // iterate over each 16x16 block, then remove alpha if the block's mask value is 0
-		for j := 0; j < xCount; j++ {
-			maskValue := alphaMask[k] // maskValue is 0 or 1
-			k++
-			if maskValue != 0 && maskValue != 1 {
-				err = fmt.Errorf("unknown alpha mask value")
-				return
-			}
-			if maskValue == 0 { // zero: do not mask
+
+	dcf = &DCF{
+		Image:         rgbImg,
+		Mask:          alphaMask,
+		MaskWidth:     xCount,
+		MaskHeight:    yCount,
+		BlockWidth:    DCFBlockWidth,
+		BlockHeight:   DCFBlockHeight,
+		Width:         dcfHeader.Width,
+		Height:        dcfHeader.Height,
+		Unknown1:      dcfHeader.Unknown1,
+		Unknown2:      dcfHeader.Unknown2,
+		BaseImageName: dcfHeader.BaseImageName,
+	}
+	return
+}
+
+// Whether the block at (bx, by) is taken from the base image.
+func (p *DCF) IsMasked(bx, by int) bool {
+	if bx < 0 || bx >= p.MaskWidth || by < 0 || by >= p.MaskHeight {
+		return false
+	}
+	return p.Mask[by*p.MaskWidth+bx] != 0
+}
+
+// Pixel area of the block at (bx, by), relative to the origin of Image.
+func (p *DCF) BlockRect(bx, by int) image.Rectangle {
+	r := image.Rect(bx*p.BlockWidth, by*p.BlockHeight, (bx+1)*p.BlockWidth, (by+1)*p.BlockHeight)
+	return r.Intersect(image.Rect(0, 0, p.Width, p.Height))
+}
+
+// Returns a copy of the embedded image with the alpha of masked blocks cleared.
+// The result can be overlayed on the base image to get the variant image.
+func (p *DCF) MaskedImage() *image.NRGBA {
+	img := image.NewNRGBA(p.Image.Rect)
+	copy(img.Pix, p.Image.Pix)
+	p.applyMask(img)
+	return img
+}
+
+// clear the alpha of masked blocks
+func (p *DCF) applyMask(rgbImg *image.NRGBA) {
+	imgRect := image.Rect(0, 0, rgbImg.Rect.Dx(), rgbImg.Rect.Dy())
+	for i := 0; i < p.MaskHeight; i++ { // iterate over each block, then remove alpha if the block's mask value is 1
+		for j := 0; j < p.MaskWidth; j++ {
+			if !p.IsMasked(j, i) { // zero: do not mask
 				continue
 			}
-			px, py := j*blockX, i*blockY
-			dx, dy := px+blockX, py+blockY
-			if dx > dcfHeader.Width {
-				dx = dcfHeader.Width
-			}
-			if dy > dcfHeader.Height {
-				dy = dcfHeader.Height
-			}
-			for y := py; y < dy; y++ {
-				o := y*rgbImg.Stride + px*4 + 3 // +3 for alpha byte
-				for x := px; x < dx; x++ {
+			r := p.BlockRect(j, i).Intersect(imgRect)
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				o := y*rgbImg.Stride + r.Min.X*4 + 3 // +3 for alpha byte
+				for x := r.Min.X; x < r.Max.X; x++ {
 					rgbImg.Pix[o] = 0
 					o += 4
 				}
 			}
 		}
 	}
-	img = rgbImg
+}
+
+// Write the DCF components as a DCF file.
+func (p *DCF) Encode(w io.Writer) (err error) {
+
+	if len(p.Mask) != p.MaskWidth*p.MaskHeight {
+		return fmt.Errorf("invalid alpha block size: expected %d, actual %d", p.MaskWidth*p.MaskHeight, len(p.Mask))
+	}
+
+	// compress the block mask
+	maskCount := len(p.Mask)
+	mask := make([]byte, 4+maskCount)
+	for i := 0; i < 4; i++ { // first 4 byte is the number of alpha mask bytes
+		mask[i] = byte(maskCount >> (8 * i))
+	}
+	copy(mask[4:], p.Mask)
+	var zipBuf bytes.Buffer
+	zw := zlib.NewWriter(&zipBuf)
+	_, err = zw.Write(mask)
+	if err != nil {
+		return
+	}
+	err = zw.Close()
+	if err != nil {
+		return
+	}
+
+	// encode the base name
+	// the base name is ShiftJIS code bytes rotate-righted by (length%7 + 1)
+	nameBytes, err := sjisEncoder.Bytes([]byte(p.BaseImageName))
+	if err != nil {
+		return
+	}
+	rot := len(nameBytes)%7 + 1
+	for i, b := range nameBytes {
+		nameBytes[i] = (b >> rot) | (b << (8 - rot))
+	}
+
+	// encode the variant image
+	var qntBuf bytes.Buffer
+	err = EncodeQNT(&qntBuf, p.Image)
+	if err != nil {
+		return
+	}
+
+	type ChunkHeader struct {
+		Signature string `binary:"[4]byte"`
+		Len       int    `binary:"uint32"` // length of the following data body
+	}
+
+	var dcfHeader struct {
+		ChunkHeader
+		Unknown1         int    `binary:"uint32"`
+		Width, Height    int    `binary:"uint32"`
+		Unknown2         int    `binary:"uint32"`
+		BaseImageNameLen int    `binary:"uint32"`
+		BaseImageName    []byte `binary:"[BaseImageNameLen]byte"`
+	}
+	dcfHeader.ChunkHeader = ChunkHeader{"dcf ", 4*5 + len(nameBytes)}
+	dcfHeader.Unknown1 = p.Unknown1
+	dcfHeader.Width, dcfHeader.Height = p.Width, p.Height
+	dcfHeader.Unknown2 = p.Unknown2
+	dcfHeader.BaseImageNameLen = len(nameBytes)
+	dcfHeader.BaseImageName = nameBytes
+	_, err = bst.Write(w, bst.LittleEndian, &dcfHeader)
+	if err != nil {
+		return
+	}
+
+	var alphaChunk struct {
+		ChunkHeader
+		UncompressedSize int `binary:"uint32"`
+	}
+	alphaChunk.ChunkHeader = ChunkHeader{"dfdl", 4 + zipBuf.Len()}
+	alphaChunk.UncompressedSize = len(mask)
+	_, err = bst.Write(w, bst.LittleEndian, &alphaChunk)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(zipBuf.Bytes())
+	if err != nil {
+		return
+	}
+
+	imageChunk := ChunkHeader{"dcgd", qntBuf.Len()}
+	_, err = bst.Write(w, bst.LittleEndian, &imageChunk)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(qntBuf.Bytes())
 	return
 }
 
+// DCF is QNF file with independent alpha masks.
+// returned baseImageName contains the base image filename that should be overlayed on.
+func LoadDCF(rs io.ReadSeeker) (img image.Image, baseImageName string, err error) {
+	dcf, err := DecodeDCF(rs)
+	if err != nil {
+		return
+	}
+	// merge the image and the alpha mask
+	dcf.applyMask(dcf.Image)
+	return dcf.Image, dcf.BaseImageName, nil
+}
+
+// use r as an io.ReadSeeker, or read whole data into memory if r is not seekable
+func asReadSeeker(r io.Reader) (rs io.ReadSeeker, err error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return bytes.NewReader(data), nil
+}
+
 func uncompressZlib(input []byte) (unzipped []byte, err error) {
 	b := bytes.NewBuffer(input)
 	zl, err := zlib.NewReader(b)
@@ -179,3 +386,125 @@ func uncompressZlib(input []byte) (unzipped []byte, err error) {
 	}
 	return io.ReadAll(zl)
 }
+
+// DCF decoder for image.Decode().
+// The base image name is discarded.
+func decodeDCF(r io.Reader) (img image.Image, err error) {
+	rs, err := asReadSeeker(r)
+	if err != nil {
+		return
+	}
+	img, _, err = LoadDCF(rs)
+	return
+}
+
+// Read the dimension and the color model of a DCF image without decoding the image.
+// The dimension is taken from the QNT header of the embedded image.
+func DecodeDCFConfig(r io.Reader) (cfg image.Config, err error) {
+	type ChunkHeader struct {
+		Signature string `binary:"[4]byte"`
+		Len       int    `binary:"uint32"` // length of the following data body
+	}
+
+	_, err = readDCFHeader(r)
+	if err != nil {
+		return
+	}
+
+	// skip the alpha mask chunk
+	var alphaChunk ChunkHeader
+	_, err = bst.Read(r, bst.LittleEndian, &alphaChunk)
+	if err != nil {
+		return
+	}
+	if alphaChunk.Signature != "dfdl" {
+		err = ErrInvalidFormat
+		return
+	}
+	_, err = io.CopyN(io.Discard, r, int64(alphaChunk.Len))
+	if err != nil {
+		return
+	}
+
+	// read the header of the embedded QNT image
+	var imageChunk ChunkHeader
+	_, err = bst.Read(r, bst.LittleEndian, &imageChunk)
+	if err != nil {
+		return
+	}
+	if imageChunk.Signature != "dcgd" {
+		err = ErrInvalidFormat
+		return
+	}
+	return DecodeQNTConfig(r)
+}
+
+// Encode a DCF image.
+// img is the variant image and baseImg is the base image that the variant will be overlayed on.
+// 16x16 blocks of img identical to baseImg are marked in the mask and shows the base image after compositing.
+// baseImageName is the filename of the base image stored in the DCF.
+func EncodeDCF(w io.Writer, baseImg, img image.Image, baseImageName string) (err error) {
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// build the block mask
+	// blocks identical to the base image are marked as 1
+	// the number of blocks is rounded up, and the partial blocks on the edge are compared within the image
+	xCount := (width + DCFBlockWidth - 1) / DCFBlockWidth
+	yCount := (height + DCFBlockHeight - 1) / DCFBlockHeight
+	mask := make([]byte, xCount*yCount)
+	baseBounds := baseImg.Bounds()
+	isSameBlock := func(px, py int) bool {
+		r := image.Rect(px, py, px+DCFBlockWidth, py+DCFBlockHeight).Intersect(bounds)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				p := image.Pt(x, y)
+				if !p.In(baseBounds) {
+					return false
+				}
+				c1 := color.NRGBAModel.Convert(img.At(x, y))
+				c2 := color.NRGBAModel.Convert(baseImg.At(x, y))
+				if c1 != c2 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	k := 0
+	for i := 0; i < yCount; i++ {
+		for j := 0; j < xCount; j++ {
+			if isSameBlock(bounds.Min.X+j*DCFBlockWidth, bounds.Min.Y+i*DCFBlockHeight) {
+				mask[k] = 1
+			}
+			k++
+		}
+	}
+
+	// the embedded image is always stored in NRGBA
+	rgbImg, ok := img.(*image.NRGBA)
+	if !ok {
+		rgbImg = image.NewNRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				rgbImg.Set(x, y, img.At(x, y))
+			}
+		}
+	}
+
+	dcf := &DCF{
+		Image:         rgbImg,
+		Mask:          mask,
+		MaskWidth:     xCount,
+		MaskHeight:    yCount,
+		BlockWidth:    DCFBlockWidth,
+		BlockHeight:   DCFBlockHeight,
+		Width:         width,
+		Height:        height,
+		Unknown1:      1,
+		Unknown2:      0x20,
+		BaseImageName: baseImageName,
+	}
+	return dcf.Encode(w)
+}
@@ -0,0 +1,387 @@
+package aliceafa
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+func init() {
+	image.RegisterFormat("pms", "PM", decodePMS, DecodePMSConfig)
+}
+
+// PMS file header
+type pmsHeader struct {
+	//+0x00
+	Signature   string `binary:"[2]byte"` // "PM"
+	Version     int    `binary:"uint16"`
+	HeaderSize  int    `binary:"uint16"`
+	ColorDepth  int    `binary:"uint8"` // 8 or 16
+	ShadowDepth int    `binary:"uint8"`
+	SpriteFlag  int    `binary:"uint8"`
+	Reserved1   int    `binary:"uint8"`
+	PaletteMask int    `binary:"uint16"` // palette bank mask
+	Reserved2   int    `binary:"uint32"`
+	//+0x10
+	X, Y          int `binary:"uint32"` // image origin
+	Width, Height int `binary:"uint32"`
+	//+0x20
+	DataOffset    int `binary:"uint32"` // offset to the compressed pixels
+	PaletteOffset int `binary:"uint32"` // offset to the palette; offset to the alpha plane for 16-bit images
+	CommentOffset int `binary:"uint32"`
+	//+0x2c
+}
+
+// read PMS file header
+func readPMSHeader(r io.Reader) (hdr *pmsHeader, err error) {
+	hdr = new(pmsHeader)
+	_, err = bst.Read(r, bst.LittleEndian, hdr)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Signature != "PM" {
+		return nil, ErrInvalidFormat
+	}
+	if hdr.ColorDepth != 8 && hdr.ColorDepth != 16 {
+		return nil, fmt.Errorf("unsupported bit depth; must be 8 or 16 but has %d", hdr.ColorDepth)
+	}
+	return
+}
+
+// PMS file header info
+type PMSInfo struct {
+	Version       int           // PMS format version
+	X, Y          int           // image origin; the position of the image on the screen
+	Width, Height int           // image dimension
+	ColorDepth    int           // 8 for palettized images, 16 for RGB565 images
+	PaletteMask   int           // palette bank mask; each bit represents a bank of 16 colors
+	Palette       color.Palette // 256-color palette of 8-bit images
+	HasAlpha      bool          // whether a 16-bit image has an alpha plane
+}
+
+// Bounds of the image, placed on its origin.
+func (p *PMSInfo) Bounds() image.Rectangle {
+	return image.Rect(p.X, p.Y, p.X+p.Width, p.Y+p.Height)
+}
+
+// Read the header and the palette of a PMS image.
+// Offsets in the PMS header are relative to the current position of rs.
+func ReadPMSHeader(rs io.ReadSeeker) (info *PMSInfo, err error) {
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	_, info, err = readPMSInfo(rs, start)
+	return
+}
+
+// read PMS header and palette. start is the file offset of the PMS image.
+func readPMSInfo(rs io.ReadSeeker, start int64) (hdr *pmsHeader, info *PMSInfo, err error) {
+	hdr, err = readPMSHeader(rs)
+	if err != nil {
+		return
+	}
+	info = &PMSInfo{
+		Version:     hdr.Version,
+		X:           hdr.X,
+		Y:           hdr.Y,
+		Width:       hdr.Width,
+		Height:      hdr.Height,
+		ColorDepth:  hdr.ColorDepth,
+		PaletteMask: hdr.PaletteMask,
+	}
+	if hdr.ColorDepth == 16 {
+		info.HasAlpha = hdr.PaletteOffset != 0
+		return
+	}
+
+	// read the palette
+	// the palette is a list of 256 RGB values
+	_, err = rs.Seek(start+int64(hdr.PaletteOffset), io.SeekStart)
+	if err != nil {
+		return
+	}
+	pal := make([]byte, 256*3)
+	_, err = io.ReadFull(rs, pal)
+	if err != nil {
+		return
+	}
+	info.Palette = make(color.Palette, 256)
+	for i := range info.Palette {
+		info.Palette[i] = color.RGBA{pal[i*3], pal[i*3+1], pal[i*3+2], 0xff}
+	}
+	return
+}
+
+// Load PMS image.
+// 8-bit images are returned as *image.Paletted with the embedded palette,
+// and 16-bit images are returned as *image.NRGBA with the alpha plane applied.
+// The image is placed on the origin stored in the header.
+func LoadPMS(rs io.ReadSeeker) (img image.Image, err error) {
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	hdr, info, err := readPMSInfo(rs, start)
+	if err != nil {
+		return
+	}
+	width, height := hdr.Width, hdr.Height
+
+	// read a compressed plane at the offset
+	readPlane := func(offset int) (r io.ByteReader, err error) {
+		_, err = rs.Seek(start+int64(offset), io.SeekStart)
+		if err != nil {
+			return
+		}
+		return bufio.NewReader(rs), nil
+	}
+
+	r, err := readPlane(hdr.DataOffset)
+	if err != nil {
+		return
+	}
+	if hdr.ColorDepth == 8 {
+		var pix []byte
+		pix, err = decodePMS8(r, width, height)
+		if err != nil {
+			return
+		}
+		return &image.Paletted{Pix: pix, Stride: width, Rect: info.Bounds(), Palette: info.Palette}, nil
+	}
+
+	// 16-bit image
+	pix16, err := decodePMS16(r, width, height)
+	if err != nil {
+		return
+	}
+	var alpha []byte
+	if info.HasAlpha {
+		r, err = readPlane(hdr.PaletteOffset)
+		if err != nil {
+			return
+		}
+		alpha, err = decodePMS8(r, width, height)
+		if err != nil {
+			return
+		}
+	}
+	rgba := image.NewNRGBA(info.Bounds())
+	for i, c := range pix16 {
+		// RGB565
+		r, g, b := byte(c>>11)&0x1f, byte(c>>5)&0x3f, byte(c)&0x1f
+		rgba.Pix[i*4+0] = r<<3 | r>>2
+		rgba.Pix[i*4+1] = g<<2 | g>>4
+		rgba.Pix[i*4+2] = b<<3 | b>>2
+		if alpha != nil {
+			rgba.Pix[i*4+3] = alpha[i]
+		} else {
+			rgba.Pix[i*4+3] = 0xff
+		}
+	}
+	return rgba, nil
+}
+
+// PMS decoder for image.Decode().
+func decodePMS(r io.Reader) (img image.Image, err error) {
+	rs, err := asReadSeeker(r)
+	if err != nil {
+		return
+	}
+	return LoadPMS(rs)
+}
+
+// Read the dimension and the color model of a PMS image without decoding the image.
+// The color model of 8-bit images is the palette of the image.
+func DecodePMSConfig(r io.Reader) (cfg image.Config, err error) {
+	rs, err := asReadSeeker(r)
+	if err != nil {
+		return
+	}
+	info, err := ReadPMSHeader(rs)
+	if err != nil {
+		return
+	}
+	cfg = image.Config{ColorModel: color.NRGBAModel, Width: info.Width, Height: info.Height}
+	if info.ColorDepth == 8 {
+		cfg.ColorModel = info.Palette
+	}
+	return
+}
+
+// decompress an 8-bit PMS plane.
+// each byte of the plane is a palette index, or an alpha value for an alpha plane.
+func decodePMS8(r io.ByteReader, width, height int) (pic []byte, err error) {
+	pic = make([]byte, width*height)
+	next := func() int {
+		c, e := r.ReadByte()
+		if e != nil && err == nil {
+			err = e
+		}
+		return int(c)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; {
+			loc := y*width + x
+			c0 := next()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case c0 <= 0xf7: // a pixel
+				pic[loc] = byte(c0)
+				x++
+
+			case c0 == 0xff, c0 == 0xfe: // copy from the previous line or two lines above
+				l := next() + 3
+				src := width
+				if c0 == 0xfe {
+					src = width * 2
+				}
+				if loc-src < 0 || loc+l > len(pic) {
+					return nil, ErrInvalidFormat
+				}
+				for i := 0; i < l; i++ {
+					pic[loc+i] = pic[loc+i-src]
+				}
+				x += l
+
+			case c0 == 0xfd: // repeat a pixel
+				l := next() + 4
+				c := byte(next())
+				if loc+l > len(pic) {
+					return nil, ErrInvalidFormat
+				}
+				for i := 0; i < l; i++ {
+					pic[loc+i] = c
+				}
+				x += l
+
+			case c0 == 0xfc: // repeat two pixels
+				l := (next() + 3) * 2
+				c1, c2 := byte(next()), byte(next())
+				if loc+l > len(pic) {
+					return nil, ErrInvalidFormat
+				}
+				for i := 0; i < l; i += 2 {
+					pic[loc+i] = c1
+					pic[loc+i+1] = c2
+				}
+				x += l
+
+			default: // 0xf8~0xfb: escaped pixel value
+				pic[loc] = byte(next())
+				x++
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+// decompress a 16-bit PMS plane into RGB565 pixels.
+func decodePMS16(r io.ByteReader, width, height int) (pic []uint16, err error) {
+	pic = make([]uint16, width*height)
+	next := func() int {
+		c, e := r.ReadByte()
+		if e != nil && err == nil {
+			err = e
+		}
+		return int(c)
+	}
+	next16 := func() uint16 {
+		c0 := next()
+		c1 := next()
+		return uint16(c0 | c1<<8)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; {
+			loc := y*width + x
+			c0 := next()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case c0 <= 0xf7: // a pixel
+				pic[loc] = uint16(c0 | next()<<8)
+				x++
+
+			case c0 == 0xff, c0 == 0xfe: // copy from the previous line or two lines above
+				l := next() + 2
+				src := width
+				if c0 == 0xfe {
+					src = width * 2
+				}
+				if loc-src < 0 || loc+l > len(pic) {
+					return nil, ErrInvalidFormat
+				}
+				for i := 0; i < l; i++ {
+					pic[loc+i] = pic[loc+i-src]
+				}
+				x += l
+
+			case c0 == 0xfd: // repeat a pixel
+				l := next() + 3
+				c := next16()
+				if loc+l > len(pic) {
+					return nil, ErrInvalidFormat
+				}
+				for i := 0; i < l; i++ {
+					pic[loc+i] = c
+				}
+				x += l
+
+			case c0 == 0xfc: // repeat two pixels
+				l := (next() + 2) * 2
+				c1, c2 := next16(), next16()
+				if loc+l > len(pic) {
+					return nil, ErrInvalidFormat
+				}
+				for i := 0; i < l; i += 2 {
+					pic[loc+i] = c1
+					pic[loc+i+1] = c2
+				}
+				x += l
+
+			case c0 == 0xfb, c0 == 0xfa: // copy from the upper-left or the upper-right pixel
+				src := loc - width - 1
+				if c0 == 0xfa {
+					src = loc - width + 1
+				}
+				if src < 0 {
+					return nil, ErrInvalidFormat
+				}
+				pic[loc] = pic[src]
+				x++
+
+			case c0 == 0xf9: // pixels sharing the upper bits of each color
+				l := next() + 1
+				b0 := next()
+				if loc+l > len(pic) {
+					return nil, ErrInvalidFormat
+				}
+				upper := ((b0 & 0xe0) << 8) | ((b0 & 0x18) << 6) | ((b0 & 0x07) << 2)
+				for i := 0; i < l; i++ {
+					b1 := next()
+					lower := ((b1 & 0xc0) << 5) | ((b1 & 0x3c) << 3) | (b1 & 0x03)
+					pic[loc+i] = uint16(upper | lower)
+				}
+				x += l
+
+			default: // 0xf8: escaped pixel value
+				pic[loc] = next16()
+				x++
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
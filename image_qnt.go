@@ -1,10 +1,12 @@
 package aliceafa
 
 import (
+	"bytes"
 	"compress/zlib"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
 
 	bst "github.com/mixcode/binarystruct"
@@ -12,12 +14,39 @@ import (
 
 var (
 	ErrInvalidFormat = errors.New("invalid data format")
+	ErrNoImage       = errors.New("no image data")
 )
 
-// Load QNT image.
-// The QNT images assumed to be 8-bit RGBA image.
-// Returning img is actually an *image.NRGBA type.
-func LoadQNT(rs io.ReadSeeker) (img image.Image, err error) {
+func init() {
+	image.RegisterFormat("qnt", "QNT\x00", decodeQNT, DecodeQNTConfig)
+}
+
+// QNT file header info
+type QNTInfo struct {
+	Version       int    // QNT format version
+	HeaderSize    int64  // total header size, including the signature
+	X, Y          int    // image origin; the position of the image on the screen
+	Width, Height int    // image dimension
+	ColorDepth    int    // bits per pixel of RGB planes; usually 24
+	Reserved      int    // reserved header field
+	RGBDataSize   int    // size of compressed RGB planes
+	AlphaDataSize int    // size of compressed alpha plane. zero if the image has no alpha
+	ExtraHeader   []byte // header bytes following the known fields, if any
+}
+
+// Bounds of the image, placed on its origin.
+func (p *QNTInfo) Bounds() image.Rectangle {
+	return image.Rect(p.X, p.Y, p.X+p.Width, p.Y+p.Height)
+}
+
+// Whether the image has an alpha plane.
+func (p *QNTInfo) HasAlpha() bool {
+	return p.AlphaDataSize > 0
+}
+
+// Read the header of a QNT image.
+// Only the header is read from r; image planes are not decoded.
+func ReadQNTHeader(r io.Reader) (hdr *QNTInfo, err error) {
 
 	readSz := int64(0)
 	headerSize := int64(48)
@@ -27,7 +56,7 @@ func LoadQNT(rs io.ReadSeeker) (img image.Image, err error) {
 		Signature []byte `binary:"[4]byte"`
 		Version   int    `binary:"uint32"`
 	}
-	sz, err := bst.Read(rs, bst.LittleEndian, &qntSig)
+	sz, err := bst.Read(r, bst.LittleEndian, &qntSig)
 	if err != nil {
 		return
 	}
@@ -40,7 +69,7 @@ func LoadQNT(rs io.ReadSeeker) (img image.Image, err error) {
 	if qntSig.Version != 0 {
 		// if the version is not zero, then read the header size
 		var s uint32
-		sz, err = bst.Read(rs, bst.LittleEndian, &s)
+		sz, err = bst.Read(r, bst.LittleEndian, &s)
 		if err != nil {
 			return
 		}
@@ -59,25 +88,56 @@ func LoadQNT(rs io.ReadSeeker) (img image.Image, err error) {
 		RGBDataSize, AlphaDataSize int `binary:"uint32"`
 		//+0x20
 	}
-	sz, err = bst.Read(rs, bst.LittleEndian, &qntImageInfo)
+	sz, err = bst.Read(r, bst.LittleEndian, &qntImageInfo)
 	if err != nil {
 		return
 	}
 	readSz += int64(sz)
-	if qntImageInfo.ColorDepth != 24 {
-		err = fmt.Errorf("unsupported bit depth; must be 24 but has %d", qntImageInfo.ColorDepth)
-		return
-	}
 
 	// read extra headers if exists
 	var extraHeader []byte
 	if readSz < headerSize {
 		extraHeader = make([]byte, headerSize-readSz)
-		sz, err = io.ReadFull(rs, extraHeader)
+		_, err = io.ReadFull(r, extraHeader)
 		if err != nil {
 			return
 		}
-		readSz += int64(sz)
+	}
+
+	hdr = &QNTInfo{
+		Version:       qntSig.Version,
+		HeaderSize:    headerSize,
+		X:             qntImageInfo.X,
+		Y:             qntImageInfo.Y,
+		Width:         qntImageInfo.Width,
+		Height:        qntImageInfo.Height,
+		ColorDepth:    qntImageInfo.ColorDepth,
+		Reserved:      qntImageInfo.Reserved,
+		RGBDataSize:   qntImageInfo.RGBDataSize,
+		AlphaDataSize: qntImageInfo.AlphaDataSize,
+		ExtraHeader:   extraHeader,
+	}
+	return
+}
+
+// Load QNT image.
+// The QNT images assumed to be 8-bit RGBA image.
+// Returning img is actually an *image.NRGBA type.
+// An AFF-wrapped image is unwrapped.
+func LoadQNT(rs io.ReadSeeker) (img image.Image, err error) {
+	rs, err = UnwrapAFF(rs)
+	if err != nil {
+		return
+	}
+
+	qntImageInfo, err := ReadQNTHeader(rs)
+	if err != nil {
+		return
+	}
+	readSz := qntImageInfo.HeaderSize
+	if qntImageInfo.ColorDepth != 24 {
+		err = fmt.Errorf("unsupported bit depth; must be 24 but has %d", qntImageInfo.ColorDepth)
+		return
 	}
 
 	// image width and height
@@ -225,3 +285,178 @@ func LoadQNT(rs io.ReadSeeker) (img image.Image, err error) {
 	img = rgba
 	return
 }
+
+// QNT decoder for image.Decode().
+func decodeQNT(r io.Reader) (img image.Image, err error) {
+	rs, err := asReadSeeker(r)
+	if err != nil {
+		return
+	}
+	img, err = LoadQNT(rs)
+	if err == nil && img == nil {
+		err = ErrNoImage
+	}
+	return
+}
+
+// Read the dimension and the color model of a QNT image without decoding the image planes.
+func DecodeQNTConfig(r io.Reader) (cfg image.Config, err error) {
+	hdr, err := ReadQNTHeader(r)
+	if err != nil {
+		return
+	}
+	if hdr.ColorDepth != 24 {
+		err = fmt.Errorf("unsupported bit depth; must be 24 but has %d", hdr.ColorDepth)
+		return
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: hdr.Width, Height: hdr.Height}, nil
+}
+
+// Encode an image into QNT format.
+// The image origin (img.Bounds().Min) is stored as the QNT X, Y position.
+// The alpha plane is written only if the image has a non-opaque pixel.
+func EncodeQNT(w io.Writer, img image.Image) (err error) {
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// raw data width and colum are aligned to multiple of 2
+	rawWidth, rawHeight := width, height
+	if width%2 != 0 {
+		rawWidth++
+	}
+	if height%2 != 0 {
+		rawHeight++
+	}
+	planeSize := rawWidth * rawHeight
+
+	// split the image into R, G, B, A planes
+	// padding pixels are filled with the nearest edge pixel
+	plane := make([][]byte, 4)
+	for i := 0; i < 4; i++ {
+		plane[i] = make([]byte, planeSize)
+	}
+	hasAlpha := false
+	for y := 0; y < rawHeight; y++ {
+		sy := y
+		if sy >= height {
+			sy = height - 1
+		}
+		for x := 0; x < rawWidth; x++ {
+			sx := x
+			if sx >= width {
+				sx = width - 1
+			}
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+sx, bounds.Min.Y+sy)).(color.NRGBA)
+			k := y*rawWidth + x
+			plane[0][k], plane[1][k], plane[2][k], plane[3][k] = c.R, c.G, c.B, c.A
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+		}
+	}
+
+	// diff-encode a plane; the reverse of decodeDiff in LoadQNT
+	encodeDiff := func(src []byte, w, h int) []byte {
+		buf := make([]byte, len(src))
+		if len(src) == 0 {
+			return buf
+		}
+		buf[0] = src[0]
+		for i := 1; i < w; i++ {
+			buf[i] = src[i-1] - src[i]
+		}
+		for j := 1; j < h; j++ {
+			k := j * w
+			buf[k] = src[k-w] - src[k]
+			for i := 1; i < w; i++ {
+				k++
+				a := byte((int(src[k-w]) + int(src[k-1])) >> 1)
+				buf[k] = a - src[k]
+			}
+		}
+		return buf
+	}
+
+	// group pixels into 2x2 blocks; the reverse of reorderPixel in LoadQNT
+	orderPixel := func(src []byte, w, h int) []byte {
+		raw := make([]byte, len(src))
+		k := 0
+		for j := 0; j < h; j += 2 {
+			p := j * w
+			for i := 0; i < w; i += 2 {
+				raw[k] = src[p]       // LU
+				raw[k+1] = src[p+w]   // LD
+				raw[k+2] = src[p+1]   // RU
+				raw[k+3] = src[p+w+1] // RD
+				p, k = p+2, k+4
+			}
+		}
+		return raw
+	}
+
+	// compress BGR planes
+	var rgbBuf bytes.Buffer
+	if planeSize > 0 {
+		zw := zlib.NewWriter(&rgbBuf)
+		for i := 2; i >= 0; i-- { // QNT image is ordered as BGR
+			_, err = zw.Write(orderPixel(encodeDiff(plane[i], rawWidth, rawHeight), rawWidth, rawHeight))
+			if err != nil {
+				return
+			}
+		}
+		err = zw.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	// compress alpha plane
+	var alphaBuf bytes.Buffer
+	if hasAlpha {
+		zw := zlib.NewWriter(&alphaBuf)
+		_, err = zw.Write(encodeDiff(plane[3], rawWidth, rawHeight))
+		if err != nil {
+			return
+		}
+		err = zw.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	const qntHeaderSize = 48
+	var qntHeader struct {
+		Signature  []byte `binary:"[4]byte"`
+		Version    int    `binary:"uint32"`
+		HeaderSize int    `binary:"uint32"`
+		//+0x0c
+		X, Y          int `binary:"uint32"`
+		Width, Height int `binary:"uint32"`
+		//+0x1c
+		ColorDepth                 int `binary:"uint32"`
+		Reserved                   int `binary:"uint32"`
+		RGBDataSize, AlphaDataSize int `binary:"uint32"`
+		//+0x2c
+		Padding []byte `binary:"[4]byte"`
+	}
+	qntHeader.Signature = []byte{'Q', 'N', 'T', 0}
+	qntHeader.Version = 1
+	qntHeader.HeaderSize = qntHeaderSize
+	qntHeader.X, qntHeader.Y = bounds.Min.X, bounds.Min.Y
+	qntHeader.Width, qntHeader.Height = width, height
+	qntHeader.ColorDepth = 24
+	qntHeader.RGBDataSize = rgbBuf.Len()
+	qntHeader.AlphaDataSize = alphaBuf.Len()
+	qntHeader.Padding = make([]byte, 4)
+	_, err = bst.Write(w, bst.LittleEndian, &qntHeader)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(rgbBuf.Bytes())
+	if err != nil {
+		return
+	}
+	_, err = w.Write(alphaBuf.Bytes())
+	return
+}
@@ -1,8 +1,12 @@
 package aliceafa
 
 import (
+	"bytes"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
 	"image/png"
 	"io"
 	"os"
@@ -59,6 +63,462 @@ func TestDCF(t *testing.T) {
 
 }
 
+// make a test image with a gradient pattern
+func testPattern(r image.Rectangle, seed int) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{byte(x*7 + seed), byte(y*5 + seed), byte(x*y + seed), 0xff})
+		}
+	}
+	return img
+}
+
+func sameImage(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.NRGBAModel.Convert(a.At(x, y)) != color.NRGBAModel.Convert(b.At(x, y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestEncodeQNT(t *testing.T) {
+	img := testPattern(image.Rect(3, 5, 3+37, 5+21), 0)
+	img.SetNRGBA(10, 10, color.NRGBA{1, 2, 3, 0x80}) // a translucent pixel
+
+	var buf bytes.Buffer
+	err := EncodeQNT(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := LoadQNT(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(img, decoded) {
+		t.Fatalf("QNT image mismatch")
+	}
+}
+
+func TestReadQNTHeader(t *testing.T) {
+	img := testPattern(image.Rect(100, 200, 100+30, 200+20), 0)
+	var buf bytes.Buffer
+	err := EncodeQNT(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ReadQNTHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Bounds() != img.Bounds() || info.HasAlpha() || info.ColorDepth != 24 || int(info.HeaderSize)+info.RGBDataSize != buf.Len() {
+		t.Fatalf("invalid QNT info: %+v", info)
+	}
+
+	// a header with unknown extra fields
+	hdr := struct {
+		Signature                  string `binary:"[4]byte"`
+		Version, HeaderSize        int    `binary:"uint32"`
+		X, Y, Width, Height        int    `binary:"uint32"`
+		ColorDepth, Reserved       int    `binary:"uint32"`
+		RGBDataSize, AlphaDataSize int    `binary:"uint32"`
+		Extra                      []byte `binary:"[8]byte"`
+	}{"QNT\x00", 9, 52, 1, 2, 3, 4, 24, 0, 5, 6, []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	data, err := bst.Marshal(&hdr, bst.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = ReadQNTHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 9 || info.X != 1 || info.Y != 2 || info.Width != 3 || info.Height != 4 ||
+		info.RGBDataSize != 5 || !info.HasAlpha() || !bytes.Equal(info.ExtraHeader, hdr.Extra) {
+		t.Fatalf("invalid QNT info: %+v", info)
+	}
+}
+
+func TestEncodeDCF(t *testing.T) {
+	r := image.Rect(0, 0, 64, 40)
+	base := testPattern(r, 0)
+	variant := testPattern(r, 0)
+	// modify some blocks, including the partial bottom strip
+	for y := 20; y < 30; y++ {
+		for x := 18; x < 40; x++ {
+			variant.SetNRGBA(x, y, color.NRGBA{0xff, 0, 0, 0xff})
+		}
+	}
+	variant.SetNRGBA(5, 38, color.NRGBA{0, 0xff, 0, 0xff})
+
+	var buf bytes.Buffer
+	err := EncodeDCF(&buf, base, variant, "ベース画像.qnt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, baseName, err := LoadDCF(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseName != "ベース画像.qnt" {
+		t.Fatalf("base name mismatch: %s", baseName)
+	}
+	// the partial bottom strip is masked too
+	dcf, err := DecodeDCF(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dcf.MaskWidth != 4 || dcf.MaskHeight != 3 || dcf.IsMasked(0, 2) || !dcf.IsMasked(3, 2) {
+		t.Fatalf("invalid DCF mask: %d x %d %v", dcf.MaskWidth, dcf.MaskHeight, dcf.Mask)
+	}
+
+	// the masked block must be transparent
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Fatalf("block is not masked")
+	}
+
+	merged := image.NewNRGBA(r)
+	draw.Draw(merged, r, base, r.Min, draw.Src)
+	draw.Draw(merged, r, img, r.Min, draw.Over)
+	if !sameImage(variant, merged) {
+		t.Fatalf("composited DCF image mismatch")
+	}
+}
+
+func TestDecodeDCF(t *testing.T) {
+	r := image.Rect(0, 0, 48, 32)
+	base := testPattern(r, 0)
+	variant := testPattern(r, 0)
+	variant.SetNRGBA(20, 3, color.NRGBA{0xff, 0, 0, 0xff}) // modify block (1, 0)
+
+	var buf bytes.Buffer
+	err := EncodeDCF(&buf, base, variant, "base.qnt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dcf, err := DecodeDCF(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dcf.MaskWidth != 3 || dcf.MaskHeight != 2 || dcf.Width != 48 || dcf.Height != 32 ||
+		dcf.BlockWidth != 16 || dcf.BaseImageName != "base.qnt" || dcf.Unknown1 != 1 || dcf.Unknown2 != 0x20 {
+		t.Fatalf("invalid DCF components: %+v", dcf)
+	}
+	if !bytes.Equal(dcf.Mask, []byte{1, 0, 1, 1, 1, 1}) || dcf.IsMasked(1, 0) || !dcf.IsMasked(2, 1) {
+		t.Fatalf("invalid DCF mask: %v", dcf.Mask)
+	}
+	if !sameImage(dcf.Image, variant) {
+		t.Fatalf("embedded image mismatch")
+	}
+	if _, _, _, a := dcf.MaskedImage().At(0, 0).RGBA(); a != 0 {
+		t.Fatalf("block is not masked")
+	}
+
+	// re-encode the components
+	var buf2 bytes.Buffer
+	err = dcf.Encode(&buf2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), buf2.Bytes()) {
+		t.Fatalf("re-encoded DCF mismatch")
+	}
+}
+
+// DCF with a canvas size not divisible by the block size
+func TestDCFPartialBlock(t *testing.T) {
+	r := image.Rect(0, 0, 40, 25)
+	img := testPattern(r, 0)
+
+	for _, c := range []struct {
+		mw, mh int
+		mask   []byte
+	}{
+		{3, 2, []byte{0, 0, 1, 0, 1, 1}}, // rounded-up block count; partial blocks are masked
+		{2, 1, []byte{1, 0}},             // rounded-down block count
+	} {
+		src := &DCF{
+			Image:       img,
+			Mask:        c.mask,
+			MaskWidth:   c.mw,
+			MaskHeight:  c.mh,
+			BlockWidth:  DCFBlockWidth,
+			BlockHeight: DCFBlockHeight,
+			Width:       r.Dx(),
+			Height:      r.Dy(),
+			Unknown1:    1,
+			Unknown2:    0x20,
+		}
+		var buf bytes.Buffer
+		err := src.Encode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dcf, err := DecodeDCF(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dcf.MaskWidth != c.mw || dcf.MaskHeight != c.mh {
+			t.Fatalf("invalid mask dimension: %d x %d", dcf.MaskWidth, dcf.MaskHeight)
+		}
+
+		masked := dcf.MaskedImage()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				_, _, _, a := masked.At(x, y).RGBA()
+				if dcf.IsMasked(x/DCFBlockWidth, y/DCFBlockHeight) != (a == 0) {
+					t.Fatalf("invalid mask at (%d, %d) for %d x %d mask", x, y, c.mw, c.mh)
+				}
+			}
+		}
+	}
+
+	// neither rounded-up nor rounded-down
+	bad := &DCF{Image: img, Mask: make([]byte, 4), MaskWidth: 4, MaskHeight: 1, Width: r.Dx(), Height: r.Dy()}
+	var buf bytes.Buffer
+	err := bad.Encode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecodeDCF(bytes.NewReader(buf.Bytes()))
+	if err == nil {
+		t.Fatalf("invalid mask size not detected")
+	}
+}
+
+func TestImageDecode(t *testing.T) {
+	r := image.Rect(0, 0, 40, 33)
+	base := testPattern(r, 0)
+	variant := testPattern(r, 1)
+
+	var qnt, dcf bytes.Buffer
+	err := EncodeQNT(&qnt, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = EncodeDCF(&dcf, base, variant, "base.qnt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		format string
+		data   []byte
+	}{{"qnt", qnt.Bytes()}, {"dcf", dcf.Bytes()}} {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(c.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != c.format || cfg.Width != r.Dx() || cfg.Height != r.Dy() || cfg.ColorModel != color.NRGBAModel {
+			t.Fatalf("invalid config for %s: %s %v", c.format, format, cfg)
+		}
+		img, format, err := image.Decode(bytes.NewReader(c.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != c.format || img.Bounds() != r {
+			t.Fatalf("invalid image for %s: %s %v", c.format, format, img.Bounds())
+		}
+	}
+}
+
+// make 8-bit PMS data with uncompressed pixels
+func makeTestPMS8(t *testing.T, pix []byte, w, h int, palette []byte) []byte {
+	var body []byte
+	for _, c := range pix {
+		if c >= 0xf8 {
+			body = append(body, 0xf8) // escape
+		}
+		body = append(body, c)
+	}
+	hdr := pmsHeader{Signature: "PM", Version: 1, HeaderSize: 0x30, ColorDepth: 8, Width: w, Height: h, DataOffset: 0x30}
+	if palette != nil {
+		hdr.PaletteOffset = 0x30
+		hdr.DataOffset = 0x30 + len(palette)
+	}
+	data, err := bst.Marshal(&hdr, bst.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 0x30-len(data))...)
+	data = append(data, palette...)
+	return append(data, body...)
+}
+
+func TestAJP(t *testing.T) {
+	w, h := 24, 10
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Rect, image.NewUniform(color.NRGBA{0x40, 0x80, 0xc0, 0xff}), image.Point{}, draw.Src)
+	var jpg bytes.Buffer
+	err := jpeg.Encode(&jpg, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alpha := make([]byte, w*h)
+	for i := range alpha {
+		alpha[i] = byte(i)
+	}
+	mask := makeTestPMS8(t, alpha, w, h, nil)
+
+	jpegData, maskData := jpg.Bytes(), mask
+	ajpDecrypt(jpegData)
+	ajpDecrypt(maskData)
+	hdr := ajpHeader{
+		Signature: []byte("AJP\x00"), HeaderSize: 0x38, Width: w, Height: h,
+		JPEGOffset: 0x38, JPEGSize: len(jpegData),
+		MaskOffset: 0x38 + len(jpegData), MaskSize: len(maskData),
+		Unknown1: make([]byte, 16),
+	}
+	data, err := bst.Marshal(&hdr, bst.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(append(data, jpegData...), maskData...)
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "ajp" || img.Bounds() != src.Rect {
+		t.Fatalf("invalid AJP image: %s %v", format, img.Bounds())
+	}
+	for i := range alpha {
+		c := img.(*image.NRGBA).NRGBAAt(i%w, i/w)
+		if c.A != alpha[i] || c.R < 0x38 || c.R > 0x48 {
+			t.Fatalf("invalid pixel at %d: %v", i, c)
+		}
+	}
+}
+
+func TestPMS8(t *testing.T) {
+	w, h := 5, 3
+	pix := []byte{0, 1, 2, 0xf8, 0xff, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}
+	palette := make([]byte, 256*3)
+	for i := range palette {
+		palette[i] = byte(i)
+	}
+	data := makeTestPMS8(t, pix, w, h, palette)
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pimg, ok := img.(*image.Paletted)
+	if format != "pms" || !ok || !bytes.Equal(pimg.Pix, pix) || pimg.Rect.Dx() != w || pimg.Rect.Dy() != h {
+		t.Fatalf("invalid PMS image")
+	}
+	if pimg.Palette[2] != (color.RGBA{6, 7, 8, 0xff}) {
+		t.Fatalf("invalid palette: %v", pimg.Palette[2])
+	}
+
+	// compression commands
+	compressed := []byte{
+		0xfd, 0x02, 0x07, // repeat 7 for 6 pixels
+		0xfc, 0x00, 0x01, 0x02, // repeat 1, 2 for 6 pixels
+		0xf8, 0xfa, // escaped value
+		0xfe, 0x01, // copy 4 pixels from two lines above
+		0x05,
+	}
+	decoded, err := decodePMS8(bytes.NewReader(compressed), 6, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, []byte{7, 7, 7, 7, 7, 7, 1, 2, 1, 2, 1, 2, 0xfa, 7, 7, 7, 7, 5}) {
+		t.Fatalf("invalid decoded plane: %v", decoded)
+	}
+}
+
+func TestPMS16(t *testing.T) {
+	w, h := 4, 3
+	pixels := []byte{
+		0x34, 0x12, 0xfd, 0x00, 0x00, 0xf8, // 0x1234, 0xf800 x 3
+		0xff, 0x02, // copy the line above
+		0xfc, 0x00, 0x1f, 0x00, 0xe0, 0x07, // 0x001f, 0x07e0 x 2
+	}
+	alpha := []byte{
+		0xfd, 0x00, 0x80, // 0x80 x 4
+		0xff, 0x01, // copy the line above
+		0xf8, 0xff, 0x10, 0x20, 0x30,
+	}
+	hdr := pmsHeader{Signature: "PM", Version: 1, HeaderSize: 0x30, ColorDepth: 16,
+		X: 10, Y: 20, Width: w, Height: h, DataOffset: 0x30, PaletteOffset: 0x30 + len(pixels)}
+	data, err := bst.Marshal(&hdr, bst.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 0x30-len(data))...)
+	data = append(append(data, pixels...), alpha...)
+
+	img, err := LoadPMS(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba, ok := img.(*image.NRGBA)
+	if !ok || rgba.Rect != image.Rect(10, 20, 14, 23) {
+		t.Fatalf("invalid PMS image")
+	}
+	for _, c := range []struct {
+		x, y int
+		c    color.NRGBA
+	}{
+		{10, 20, color.NRGBA{0x10, 0x45, 0xa5, 0x80}},
+		{11, 21, color.NRGBA{0xff, 0, 0, 0x80}},
+		{12, 22, color.NRGBA{0, 0, 0xff, 0x20}},
+		{13, 22, color.NRGBA{0, 0xff, 0, 0x30}},
+	} {
+		if got := rgba.NRGBAAt(c.x, c.y); got != c.c {
+			t.Fatalf("invalid pixel at (%d, %d): %v", c.x, c.y, got)
+		}
+	}
+}
+
+func TestVSP(t *testing.T) {
+	hdr := vspHeader{X0: 1, Y0: 5, X1: 2, Y1: 7, PaletteBank: 2, Palette: make([]byte, 48)}
+	copy(hdr.Palette[3:], []byte{1, 2, 3}) // color 1
+	data, err := bst.Marshal(&hdr, bst.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data,
+		0xff, 0x0f, // plane 0
+		0x01, 0x01, 0xaa, // plane 1: repeat 0xaa
+		0x06, 0x03, 0x01, // plane 2: inverted copy of plane 0
+		0x07, 0x03, 0x07, 0x00, // plane 3: escaped values
+	)
+
+	img, err := LoadVSP(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Rect != image.Rect(8, 5, 16, 7) {
+		t.Fatalf("invalid bounds: %v", img.Rect)
+	}
+	if !bytes.Equal(img.Pix, []byte{3, 1, 3, 1, 3, 1, 11, 9, 6, 4, 6, 4, 3, 1, 3, 1}) {
+		t.Fatalf("invalid pixels: %v", img.Pix)
+	}
+	if img.Palette[1] != (color.RGBA{0x22, 0x33, 0x11, 0xff}) {
+		t.Fatalf("invalid palette: %v", img.Palette[1])
+	}
+
+	// separate palette
+	pal := make(color.Palette, 256)
+	for i := range pal {
+		pal[i] = color.Gray{byte(i)}
+	}
+	img, err = LoadVSPWithPalette(bytes.NewReader(data), pal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Pix[0] != 0x23 || img.At(8, 5) != pal[0x23] {
+		t.Fatalf("palette bank not applied: %v", img.Pix[0])
+	}
+}
+
 func savePNG(filename string, img image.Image) (err error) {
 	f, err := os.Create(filename)
 	if err != nil {
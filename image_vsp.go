@@ -0,0 +1,199 @@
+package aliceafa
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// VSP file header
+type vspHeader struct {
+	//+0x00
+	X0, Y0      int    `binary:"uint16"` // top-left position; X is in 8-pixel unit
+	X1, Y1      int    `binary:"uint16"` // bottom-right position (exclusive); X is in 8-pixel unit
+	Reserved    int    `binary:"uint8"`
+	PaletteBank int    `binary:"uint8"`
+	Palette     []byte `binary:"[48]byte"` // 16 colors of 4-bit B, R, G values
+	//+0x3a
+}
+
+// VSP file header info
+type VSPInfo struct {
+	X, Y          int           // image origin in pixels
+	Width, Height int           // image dimension in pixels
+	PaletteBank   int           // palette bank; the image uses colors from PaletteBank*16 of a 256-color palette
+	Palette       color.Palette // the embedded 16-color palette
+}
+
+// Bounds of the image, placed on its origin.
+func (p *VSPInfo) Bounds() image.Rectangle {
+	return image.Rect(p.X, p.Y, p.X+p.Width, p.Y+p.Height)
+}
+
+// Read the header of a VSP image.
+// VSP has no signature, so an error is returned only for apparently invalid dimensions.
+func ReadVSPHeader(r io.Reader) (info *VSPInfo, err error) {
+	var hdr vspHeader
+	_, err = bst.Read(r, bst.LittleEndian, &hdr)
+	if err != nil {
+		return
+	}
+	// the screen of the old PCs is 640 pixels (80 units) wide
+	if hdr.X1 <= hdr.X0 || hdr.Y1 <= hdr.Y0 || hdr.X1 > 80 || hdr.Y1 > 480 {
+		return nil, ErrInvalidFormat
+	}
+	info = &VSPInfo{
+		X:           hdr.X0 * 8,
+		Y:           hdr.Y0,
+		Width:       (hdr.X1 - hdr.X0) * 8,
+		Height:      hdr.Y1 - hdr.Y0,
+		PaletteBank: hdr.PaletteBank,
+		Palette:     make(color.Palette, 16),
+	}
+	for i := 0; i < 16; i++ {
+		// 4-bit values ordered as B, R, G
+		b, r, g := hdr.Palette[i*3], hdr.Palette[i*3+1], hdr.Palette[i*3+2]
+		info.Palette[i] = color.RGBA{(r & 0x0f) * 0x11, (g & 0x0f) * 0x11, (b & 0x0f) * 0x11, 0xff}
+	}
+	return
+}
+
+// Load VSP image.
+// VSP is a 16-color planar image of the oldest System 1-3 titles.
+// Returned image is an *image.Paletted with the embedded 16-color palette, placed on the origin stored in the header.
+func LoadVSP(r io.Reader) (img *image.Paletted, err error) {
+	return loadVSP(r, nil)
+}
+
+// Load VSP image with a separate palette, for the images that uses a palette in another file.
+// If pal has more than 16 colors, the pixel values are shifted to the palette bank stored in the header.
+func LoadVSPWithPalette(r io.Reader, pal color.Palette) (img *image.Paletted, err error) {
+	return loadVSP(r, pal)
+}
+
+func loadVSP(r io.Reader, pal color.Palette) (img *image.Paletted, err error) {
+	info, err := ReadVSPHeader(r)
+	if err != nil {
+		return
+	}
+	pix, err := decodeVSP(bufio.NewReader(r), info.Width/8, info.Height)
+	if err != nil {
+		return
+	}
+	if pal == nil {
+		pal = info.Palette
+	} else if len(pal) > 16 {
+		offset := byte(info.PaletteBank * 16)
+		for i := range pix {
+			pix[i] += offset
+		}
+	}
+	return &image.Paletted{Pix: pix, Stride: info.Width, Rect: info.Bounds(), Palette: pal}, nil
+}
+
+// decompress VSP planes into 4-bit pixels.
+// width is in 8-pixel unit.
+// The image is compressed in columns of 8 pixels wide, each column has 4 bit planes.
+func decodeVSP(r io.ByteReader, width, height int) (pic []byte, err error) {
+	pic = make([]byte, width*8*height)
+	next := func() int {
+		c, e := r.ReadByte()
+		if e != nil && err == nil {
+			err = e
+		}
+		return int(c)
+	}
+
+	// bit planes of the current and the previous column
+	var bc, bp [4][]byte
+	for i := 0; i < 4; i++ {
+		bc[i] = make([]byte, height)
+		bp[i] = make([]byte, height)
+	}
+
+	mask := byte(0)
+	for x := 0; x < width; x++ {
+		for pl := 0; pl < 4; pl++ {
+			for y := 0; y < height; {
+				c0 := next()
+				if err != nil {
+					return nil, err
+				}
+				if c0 >= 0x08 { // a plane byte
+					bc[pl][y] = byte(c0)
+					y++
+					continue
+				}
+				if c0 == 0x06 { // invert the next plane copy
+					mask = 0xff
+					continue
+				}
+				if c0 == 0x07 { // escaped plane byte
+					bc[pl][y] = byte(next())
+					y++
+					continue
+				}
+
+				l := next() + 1
+				switch c0 {
+				case 0x00: // copy from the previous column
+					if y+l > height {
+						return nil, ErrInvalidFormat
+					}
+					copy(bc[pl][y:y+l], bp[pl][y:y+l])
+					y += l
+
+				case 0x01: // repeat a byte
+					c := byte(next())
+					if y+l > height {
+						return nil, ErrInvalidFormat
+					}
+					for i := 0; i < l; i++ {
+						bc[pl][y+i] = c
+					}
+					y += l
+
+				case 0x02: // repeat two bytes
+					c1, c2 := byte(next()), byte(next())
+					if y+l*2 > height {
+						return nil, ErrInvalidFormat
+					}
+					for i := 0; i < l; i++ {
+						bc[pl][y] = c1
+						bc[pl][y+1] = c2
+						y += 2
+					}
+
+				default: // 0x03~0x05: copy from plane 0~2 of the current column
+					src := c0 - 0x03
+					if y+l > height {
+						return nil, ErrInvalidFormat
+					}
+					for i := 0; i < l; i++ {
+						bc[pl][y] = bc[src][y] ^ mask
+						y++
+					}
+					mask = 0
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// convert bit planes to pixels
+		for y := 0; y < height; y++ {
+			loc := (y*width + x) * 8
+			b0, b1, b2, b3 := bc[0][y], bc[1][y], bc[2][y], bc[3][y]
+			for i := 0; i < 8; i++ {
+				s := 7 - i
+				pic[loc+i] = (b0>>s)&1 | ((b1>>s)&1)<<1 | ((b2>>s)&1)<<2 | ((b3>>s)&1)<<3
+			}
+		}
+		bc, bp = bp, bc
+	}
+	return
+}
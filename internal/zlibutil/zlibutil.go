@@ -0,0 +1,30 @@
+// Package zlibutil has the zlib helpers shared by the readers of compressed tables and save data.
+package zlibutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+)
+
+// Whether b starts with a zlib stream header.
+func IsHeader(b []byte) bool {
+	return len(b) >= 2 && b[0]&0x0f == 8 && (uint(b[0])<<8|uint(b[1]))%31 == 0
+}
+
+// Inflate the zlib stream z of the decompressed size.
+//
+// The size comes from a file header and is not trusted: the buffer grows with the actual data,
+// so a broken header cannot make a huge allocation. A stream shorter than the size is io.ErrUnexpectedEOF.
+func Inflate(z []byte, size uint32) (plain []byte, err error) {
+	zr, err := zlib.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return
+	}
+	defer zr.Close()
+	plain, err = io.ReadAll(io.LimitReader(zr, int64(size)))
+	if err == nil && len(plain) < int(size) {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
@@ -0,0 +1,200 @@
+package aliceafa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"sync"
+)
+
+// Preferred export format of decoded content.
+type ExportKind int
+
+const (
+	ExportRaw  ExportKind = iota // the content is exported as-is
+	ExportPNG                    // the decoded value is an image.Image exported as PNG
+	ExportJSON                   // the decoded value is exported as JSON
+	ExportCSV                    // the decoded value is a CSVWriter exported as CSV
+)
+
+// A decoded value that can be written as CSV.
+type CSVWriter interface {
+	WriteCSV(w io.Writer) error
+}
+
+// Filename extension of the export format, including the leading dot.
+// Returns an empty string for ExportRaw.
+func (k ExportKind) Ext() string {
+	switch k {
+	case ExportPNG:
+		return ".png"
+	case ExportJSON:
+		return ".json"
+	case ExportCSV:
+		return ".csv"
+	}
+	return ""
+}
+
+// Context of decoding an entry.
+type DecodeContext struct {
+	Name     string        // name of the entry
+	Resolver ImageResolver // resolver for the base images of DCF. if nil, DCF images are not composed
+	Cache    *ImageCache   // cache for the base images of DCF. may be nil
+	Warn     func(error)   // called for non-fatal errors. may be nil
+}
+
+func (p *DecodeContext) warn(err error) {
+	if p != nil && p.Warn != nil {
+		p.Warn(err)
+	}
+}
+
+// Decoder of a content type.
+type Decoder struct {
+	Type   ContentType
+	Export ExportKind
+
+	// Decode the content. rs is positioned at the beginning of the content, and ctx may be nil.
+	// The type of returned value depends on Export, and a nil value means there is nothing to export, such as an empty image.
+	// Decode may be nil for ExportRaw.
+	Decode func(rs io.ReadSeeker, ctx *DecodeContext) (v interface{}, err error)
+}
+
+var (
+	ErrNoDecoder = errors.New("no decoder for the content type")
+)
+
+var (
+	decoderMu sync.RWMutex
+	decoders  = make(map[ContentType]*Decoder)
+)
+
+// Register a decoder for a content type.
+// A decoder registered later replaces the previous decoder of the same content type.
+func RegisterDecoder(d *Decoder) {
+	decoderMu.Lock()
+	defer decoderMu.Unlock()
+	decoders[d.Type] = d
+}
+
+// Find the decoder of a content type. Returns nil if no decoder is registered.
+func LookupDecoder(t ContentType) *Decoder {
+	decoderMu.RLock()
+	defer decoderMu.RUnlock()
+	return decoders[t]
+}
+
+// Decode the content with the registered decoder.
+func DecodeContent(t ContentType, rs io.ReadSeeker, ctx *DecodeContext) (v interface{}, export ExportKind, err error) {
+	// unwrap AFF
+	if t == ContentAFF {
+		t, rs, err = SniffContent(rs)
+		if err == nil && t == ContentAFF {
+			err = ErrNoAFFKey
+		}
+	} else {
+		rs, err = UnwrapAFF(rs)
+	}
+	if err != nil {
+		return nil, ExportRaw, err
+	}
+
+	d := LookupDecoder(t)
+	if d == nil || d.Decode == nil {
+		return nil, ExportRaw, ErrNoDecoder
+	}
+	v, err = d.Decode(rs, ctx)
+	return v, d.Export, err
+}
+
+// Write a decoded value in the export format.
+// For ExportRaw, v must be a []byte or an io.Reader.
+func Export(w io.Writer, kind ExportKind, v interface{}) (err error) {
+	switch kind {
+	case ExportPNG:
+		img, ok := v.(image.Image)
+		if !ok {
+			return fmt.Errorf("value is not an image")
+		}
+		return png.Encode(w, img)
+
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case ExportCSV:
+		cw, ok := v.(CSVWriter)
+		if !ok {
+			return fmt.Errorf("value is not a table")
+		}
+		return cw.WriteCSV(w)
+
+	case ExportRaw:
+		switch d := v.(type) {
+		case []byte:
+			_, err = w.Write(d)
+			return
+		case io.Reader:
+			_, err = io.Copy(w, d)
+			return
+		}
+		return fmt.Errorf("value is not raw data")
+	}
+	return fmt.Errorf("unknown export kind %d", kind)
+}
+
+// decode DCF, and compose with the base image if possible
+func decodeDCFContent(rs io.ReadSeeker, ctx *DecodeContext) (v interface{}, err error) {
+	dcf, err := DecodeDCF(rs)
+	if err != nil {
+		return
+	}
+	if ctx == nil || ctx.Resolver == nil || dcf.BaseImageName == "" {
+		return dcf.MaskedImage(), nil
+	}
+	img, err := ComposeDCFWithCache(dcf, ctx.Resolver, ctx.Cache)
+	if err != nil {
+		if !errors.Is(err, ErrImageNotFound) {
+			ctx.warn(fmt.Errorf("%s: %w", ctx.Name, err))
+		}
+		return dcf.MaskedImage(), nil
+	}
+	return img, nil
+}
+
+func init() {
+	// images
+	imageDecoder := func(load func(rs io.ReadSeeker) (image.Image, error)) func(io.ReadSeeker, *DecodeContext) (interface{}, error) {
+		return func(rs io.ReadSeeker, ctx *DecodeContext) (v interface{}, err error) {
+			img, err := load(rs)
+			if err != nil || img == nil {
+				// an image of 0x0 pixels has nothing to export
+				return nil, err
+			}
+			return img, nil
+		}
+	}
+	RegisterDecoder(&Decoder{Type: ContentQNT, Export: ExportPNG, Decode: imageDecoder(LoadQNT)})
+	RegisterDecoder(&Decoder{Type: ContentDCF, Export: ExportPNG, Decode: decodeDCFContent})
+	RegisterDecoder(&Decoder{Type: ContentAJP, Export: ExportPNG, Decode: imageDecoder(func(rs io.ReadSeeker) (image.Image, error) {
+		return decodeAJP(rs)
+	})})
+	RegisterDecoder(&Decoder{Type: ContentPMS, Export: ExportPNG, Decode: imageDecoder(LoadPMS)})
+	RegisterDecoder(&Decoder{Type: ContentVSP, Export: ExportPNG, Decode: imageDecoder(func(rs io.ReadSeeker) (image.Image, error) {
+		img, err := LoadVSP(rs)
+		if err != nil {
+			return nil, err
+		}
+		return img, nil
+	})})
+
+	// passthrough
+	for _, t := range []ContentType{ContentPNG, ContentJPEG, ContentBMP, ContentOGG, ContentWAV, ContentMP3} {
+		RegisterDecoder(&Decoder{Type: t, Export: ExportRaw})
+	}
+}
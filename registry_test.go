@@ -0,0 +1,79 @@
+package aliceafa
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestDecoderRegistry(t *testing.T) {
+	// built-in image decoder
+	src := testPattern(image.Rect(0, 0, 20, 12), 3)
+	var qnt bytes.Buffer
+	err := EncodeQNT(&qnt, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, export, err := DecodeContent(ContentQNT, bytes.NewReader(qnt.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export != ExportPNG || export.Ext() != ".png" {
+		t.Errorf("invalid export kind %d", export)
+	}
+	var buf bytes.Buffer
+	err = Export(&buf, export, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(src, img) {
+		t.Errorf("exported image does not match")
+	}
+
+	// an empty image is skipped
+	qnt.Reset()
+	err = EncodeQNT(&qnt, image.NewNRGBA(image.Rect(0, 0, 0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _, err = DecodeContent(ContentQNT, bytes.NewReader(qnt.Bytes()), nil)
+	if err != nil || v != nil {
+		t.Errorf("empty image must be skipped: %v, %v", v, err)
+	}
+
+	// passthrough
+	d := LookupDecoder(ContentOGG)
+	if d == nil || d.Export != ExportRaw || d.Decode != nil {
+		t.Errorf("invalid OGG decoder")
+	}
+	_, _, err = DecodeContent(ContentOGG, bytes.NewReader(nil), nil)
+	if !errors.Is(err, ErrNoDecoder) {
+		t.Errorf("expected ErrNoDecoder, got %v", err)
+	}
+
+	// third-party decoder
+	const testType ContentType = "test"
+	RegisterDecoder(&Decoder{Type: testType, Export: ExportJSON, Decode: func(rs io.ReadSeeker, ctx *DecodeContext) (interface{}, error) {
+		b, err := io.ReadAll(rs)
+		return map[string]string{"name": ctx.Name, "data": string(b)}, err
+	}})
+	v, export, err = DecodeContent(testType, bytes.NewReader([]byte("abc")), &DecodeContext{Name: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	err = Export(&buf, export, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "{\n  \"data\": \"abc\",\n  \"name\": \"x\"\n}\n" {
+		t.Errorf("unexpected JSON %q", buf.String())
+	}
+}
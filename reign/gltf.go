@@ -0,0 +1,687 @@
+package reign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+)
+
+// glTF 2.0 document. Only the properties used by the exporter are defined.
+type gltfDoc struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes,omitempty"`
+	Meshes      []gltfMesh       `json:"meshes,omitempty"`
+	Skins       []gltfSkin       `json:"skins,omitempty"`
+	Materials   []gltfMaterial   `json:"materials,omitempty"`
+	Textures    []gltfTexture    `json:"textures,omitempty"`
+	Images      []gltfImage      `json:"images,omitempty"`
+	Samplers    []gltfSampler    `json:"samplers,omitempty"`
+	Animations  []gltfAnimation  `json:"animations,omitempty"`
+	Accessors   []gltfAccessor   `json:"accessors,omitempty"`
+	BufferViews []gltfBufferView `json:"bufferViews,omitempty"`
+	Buffers     []gltfBuffer     `json:"buffers,omitempty"`
+}
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator,omitempty"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name        string    `json:"name,omitempty"`
+	Children    []int     `json:"children,omitempty"`
+	Mesh        *int      `json:"mesh,omitempty"`
+	Skin        *int      `json:"skin,omitempty"`
+	Translation []float32 `json:"translation,omitempty"`
+	Rotation    []float32 `json:"rotation,omitempty"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   *int           `json:"material,omitempty"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name,omitempty"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfSkin struct {
+	InverseBindMatrices int   `json:"inverseBindMatrices"`
+	Skeleton            *int  `json:"skeleton,omitempty"`
+	Joints              []int `json:"joints"`
+}
+
+type gltfTextureInfo struct {
+	Index int `json:"index"`
+}
+
+type gltfPBR struct {
+	BaseColorTexture *gltfTextureInfo `json:"baseColorTexture,omitempty"`
+	MetallicFactor   float32          `json:"metallicFactor"`
+}
+
+type gltfMaterial struct {
+	Name                 string           `json:"name,omitempty"`
+	PBRMetallicRoughness gltfPBR          `json:"pbrMetallicRoughness"`
+	NormalTexture        *gltfTextureInfo `json:"normalTexture,omitempty"`
+	AlphaMode            string           `json:"alphaMode,omitempty"`
+	DoubleSided          bool             `json:"doubleSided,omitempty"`
+}
+
+type gltfTexture struct {
+	Sampler int `json:"sampler"`
+	Source  int `json:"source"`
+}
+
+type gltfImage struct {
+	Name       string `json:"name,omitempty"`
+	BufferView int    `json:"bufferView"`
+	MimeType   string `json:"mimeType"`
+}
+
+type gltfSampler struct {
+	WrapS int `json:"wrapS"`
+	WrapT int `json:"wrapT"`
+}
+
+type gltfAnimationChannel struct {
+	Sampler int `json:"sampler"`
+	Target  struct {
+		Node int    `json:"node"`
+		Path string `json:"path"`
+	} `json:"target"`
+}
+
+type gltfAnimationSampler struct {
+	Input         int    `json:"input"`
+	Output        int    `json:"output"`
+	Interpolation string `json:"interpolation"`
+}
+
+type gltfAnimation struct {
+	Name     string                 `json:"name,omitempty"`
+	Channels []gltfAnimationChannel `json:"channels"`
+	Samplers []gltfAnimationSampler `json:"samplers"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target,omitempty"`
+}
+
+type gltfBuffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri,omitempty"`
+}
+
+// glTF constants
+const (
+	gltfFloat         = 5126
+	gltfUnsignedShort = 5123
+	gltfUnsignedInt   = 5125
+
+	gltfArrayBuffer        = 34962
+	gltfElementArrayBuffer = 34963
+
+	gltfRepeat = 10497
+)
+
+// A glTF 2.0 asset in construction.
+// The binary buffer holds the geometry, the animations and the texture images.
+type GLTF struct {
+	doc       gltfDoc
+	bin       bytes.Buffer
+	boneNodes map[string]int // node index of bones by name
+}
+
+func newGLTF() *GLTF {
+	g := &GLTF{boneNodes: make(map[string]int)}
+	g.doc.Asset = gltfAsset{Version: "2.0", Generator: "alicesoft-afa"}
+	g.doc.Scenes = []gltfScene{{Nodes: []int{}}}
+	return g
+}
+
+// append data to the binary buffer as a buffer view, aligned to 4 bytes
+func (g *GLTF) addBufferView(data []byte, target int) int {
+	for g.bin.Len()%4 != 0 {
+		g.bin.WriteByte(0)
+	}
+	g.doc.BufferViews = append(g.doc.BufferViews, gltfBufferView{ByteOffset: g.bin.Len(), ByteLength: len(data), Target: target})
+	g.bin.Write(data)
+	return len(g.doc.BufferViews) - 1
+}
+
+// add an accessor of float vectors with n components
+func (g *GLTF) addFloats(v []float32, n int, typ string, minMax bool, target int) int {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, v)
+	a := gltfAccessor{BufferView: g.addBufferView(buf.Bytes(), target), ComponentType: gltfFloat, Count: len(v) / n, Type: typ}
+	if minMax && len(v) > 0 {
+		a.Min, a.Max = make([]float32, n), make([]float32, n)
+		copy(a.Min, v[:n])
+		copy(a.Max, v[:n])
+		for i := n; i < len(v); i++ {
+			c := i % n
+			a.Min[c] = float32(math.Min(float64(a.Min[c]), float64(v[i])))
+			a.Max[c] = float32(math.Max(float64(a.Max[c]), float64(v[i])))
+		}
+	}
+	g.doc.Accessors = append(g.doc.Accessors, a)
+	return len(g.doc.Accessors) - 1
+}
+
+// add an accessor of vertex indices
+func (g *GLTF) addIndices(v []uint32) int {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, v)
+	a := gltfAccessor{BufferView: g.addBufferView(buf.Bytes(), gltfElementArrayBuffer), ComponentType: gltfUnsignedInt, Count: len(v), Type: "SCALAR"}
+	g.doc.Accessors = append(g.doc.Accessors, a)
+	return len(g.doc.Accessors) - 1
+}
+
+// add an accessor of joint indices
+func (g *GLTF) addJoints(v []uint16) int {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, v)
+	a := gltfAccessor{BufferView: g.addBufferView(buf.Bytes(), gltfArrayBuffer), ComponentType: gltfUnsignedShort, Count: len(v) / 4, Type: "VEC4"}
+	g.doc.Accessors = append(g.doc.Accessors, a)
+	return len(g.doc.Accessors) - 1
+}
+
+// add a node and return its index
+func (g *GLTF) addNode(n gltfNode) int {
+	g.doc.Nodes = append(g.doc.Nodes, n)
+	return len(g.doc.Nodes) - 1
+}
+
+// Make a glTF asset of the model.
+// Textures are found by ctx.Resolver and embedded in the buffer; images other than PNG and JPEG are converted to PNG.
+// If ctx or the resolver is nil, or a texture is not found, the texture is omitted. Textures failed to decode are reported to ctx.Warn.
+func (p *Model) GLTF(ctx *aliceafa.DecodeContext) (g *GLTF, err error) {
+	g = newGLTF()
+
+	// skeleton
+	var joints []int
+	jointOf := make(map[int]int) // bone ID to joint index
+	if len(p.Bones) > 0 {
+		joints, err = g.addSkeleton(p, jointOf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// materials
+	materials, err := g.addMaterials(p, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// meshes
+	skinned := false
+	for _, m := range p.Meshes {
+		mesh := gltfMesh{Name: m.Name}
+		// split the triangles by the materials
+		groups := make(map[int][]Triangle)
+		for _, t := range m.Triangles {
+			groups[t.Material] = append(groups[t.Material], t)
+		}
+		keys := make([]int, 0, len(groups))
+		for k := range groups {
+			keys = append(keys, k)
+		}
+		sort.Ints(keys)
+		for _, k := range keys {
+			prim, hasWeights := g.addPrimitive(&m, groups[k], jointOf)
+			mat := materials[materialKey{m.Material, k}]
+			prim.Material = &mat
+			mesh.Primitives = append(mesh.Primitives, prim)
+			skinned = skinned || hasWeights
+		}
+		if len(mesh.Primitives) == 0 {
+			continue
+		}
+		g.doc.Meshes = append(g.doc.Meshes, mesh)
+		meshIndex := len(g.doc.Meshes) - 1
+		node := g.addNode(gltfNode{Name: m.Name, Mesh: &meshIndex})
+		g.doc.Scenes[0].Nodes = append(g.doc.Scenes[0].Nodes, node)
+	}
+
+	// attach the skin to the meshes with weights
+	if skinned && len(joints) > 0 {
+		skin := len(g.doc.Skins) - 1
+		for i := range g.doc.Nodes {
+			if g.doc.Nodes[i].Mesh != nil {
+				g.doc.Nodes[i].Skin = &skin
+			}
+		}
+	}
+	return
+}
+
+// 4x4 column-major matrix
+type mat4 [16]float32
+
+// matrix of a rotation quaternion (x, y, z, w) and a translation
+func trsMatrix(t [3]float32, q [4]float32) (m mat4) {
+	x, y, z, w := q[0], q[1], q[2], q[3]
+	m[0] = 1 - 2*(y*y+z*z)
+	m[1] = 2 * (x*y + z*w)
+	m[2] = 2 * (x*z - y*w)
+	m[4] = 2 * (x*y - z*w)
+	m[5] = 1 - 2*(x*x+z*z)
+	m[6] = 2 * (y*z + x*w)
+	m[8] = 2 * (x*z + y*w)
+	m[9] = 2 * (y*z - x*w)
+	m[10] = 1 - 2*(x*x+y*y)
+	m[12], m[13], m[14] = t[0], t[1], t[2]
+	m[15] = 1
+	return
+}
+
+func (a mat4) mul(b mat4) (m mat4) {
+	for c := 0; c < 4; c++ {
+		for r := 0; r < 4; r++ {
+			var s float32
+			for k := 0; k < 4; k++ {
+				s += a[k*4+r] * b[c*4+k]
+			}
+			m[c*4+r] = s
+		}
+	}
+	return
+}
+
+// inverse of a rigid transform
+func (a mat4) invertRigid() (m mat4) {
+	for c := 0; c < 3; c++ {
+		for r := 0; r < 3; r++ {
+			m[c*4+r] = a[r*4+c]
+		}
+	}
+	for r := 0; r < 3; r++ {
+		m[12+r] = -(m[r]*a[12] + m[4+r]*a[13] + m[8+r]*a[14])
+	}
+	m[15] = 1
+	return
+}
+
+// add bone nodes and the skin
+func (g *GLTF) addSkeleton(p *Model, jointOf map[int]int) (joints []int, err error) {
+	nodeOf := make(map[int]int) // bone ID to node index
+	for i, b := range p.Bones {
+		if _, ok := nodeOf[b.ID]; ok {
+			return nil, fmt.Errorf("duplicated bone ID %d", b.ID)
+		}
+		node := g.addNode(gltfNode{
+			Name:        b.Name,
+			Translation: append([]float32(nil), b.Pos[:]...),
+			Rotation:    append([]float32(nil), b.Rot[:]...),
+		})
+		nodeOf[b.ID] = node
+		jointOf[b.ID] = i
+		joints = append(joints, node)
+		g.boneNodes[b.Name] = node
+	}
+
+	// hierarchy and global transforms
+	global := make([]mat4, len(p.Bones))
+	done := make([]bool, len(p.Bones))
+	var resolve func(i int, depth int) error
+	resolve = func(i int, depth int) error {
+		if done[i] {
+			return nil
+		}
+		if depth > len(p.Bones) {
+			return errors.New("circular bone hierarchy")
+		}
+		b := p.Bones[i]
+		local := trsMatrix(b.Pos, b.Rot)
+		if b.Parent < 0 {
+			global[i] = local
+		} else {
+			pi := p.BoneIndex(b.Parent)
+			if pi < 0 {
+				return fmt.Errorf("bone %s: parent %d not found", b.Name, b.Parent)
+			}
+			if err := resolve(pi, depth+1); err != nil {
+				return err
+			}
+			global[i] = global[pi].mul(local)
+		}
+		done[i] = true
+		return nil
+	}
+	var roots []int
+	for i, b := range p.Bones {
+		if err = resolve(i, 0); err != nil {
+			return
+		}
+		if b.Parent < 0 {
+			roots = append(roots, nodeOf[b.ID])
+		} else {
+			parent := &g.doc.Nodes[nodeOf[b.Parent]]
+			parent.Children = append(parent.Children, nodeOf[b.ID])
+		}
+	}
+	g.doc.Scenes[0].Nodes = append(g.doc.Scenes[0].Nodes, roots...)
+
+	ibm := make([]float32, 0, 16*len(p.Bones))
+	for _, m := range global {
+		inv := m.invertRigid()
+		ibm = append(ibm, inv[:]...)
+	}
+	skin := gltfSkin{InverseBindMatrices: g.addFloats(ibm, 16, "MAT4", false, 0), Joints: joints}
+	if len(roots) == 1 {
+		skin.Skeleton = &roots[0]
+	}
+	g.doc.Skins = append(g.doc.Skins, skin)
+	return
+}
+
+// material and sub-material index
+type materialKey struct {
+	material, child int
+}
+
+// add materials and their textures
+func (g *GLTF) addMaterials(p *Model, ctx *aliceafa.DecodeContext) (materials map[materialKey]int, err error) {
+	materials = make(map[materialKey]int)
+	textures := make(map[string]int) // texture index by file name
+	texture := func(name string) (*gltfTextureInfo, error) {
+		if name == "" || ctx == nil || ctx.Resolver == nil {
+			return nil, nil
+		}
+		if i, ok := textures[name]; ok {
+			if i < 0 {
+				return nil, nil
+			}
+			return &gltfTextureInfo{Index: i}, nil
+		}
+		data, mimeType, err := loadTexture(ctx, name)
+		if err != nil {
+			if !errors.Is(err, aliceafa.ErrImageNotFound) && ctx.Warn != nil {
+				ctx.Warn(fmt.Errorf("texture %s: %w", name, err))
+			}
+			textures[name] = -1
+			return nil, nil
+		}
+		if len(g.doc.Samplers) == 0 {
+			g.doc.Samplers = append(g.doc.Samplers, gltfSampler{WrapS: gltfRepeat, WrapT: gltfRepeat})
+		}
+		g.doc.Images = append(g.doc.Images, gltfImage{Name: name, BufferView: g.addBufferView(data, 0), MimeType: mimeType})
+		g.doc.Textures = append(g.doc.Textures, gltfTexture{Source: len(g.doc.Images) - 1})
+		textures[name] = len(g.doc.Textures) - 1
+		return &gltfTextureInfo{Index: len(g.doc.Textures) - 1}, nil
+	}
+	add := func(key materialKey, m *Material) (err error) {
+		gm := gltfMaterial{Name: m.Name}
+		gm.PBRMetallicRoughness.BaseColorTexture, err = texture(m.Texture(TextureColor))
+		if err != nil {
+			return
+		}
+		gm.NormalTexture, err = texture(m.Texture(TextureNormal))
+		if err != nil {
+			return
+		}
+		if m.Texture(TextureAlpha) != "" {
+			gm.AlphaMode = "BLEND"
+		}
+		g.doc.Materials = append(g.doc.Materials, gm)
+		materials[key] = len(g.doc.Materials) - 1
+		return
+	}
+
+	for i := range p.Materials {
+		m := &p.Materials[i]
+		if len(m.Children) == 0 {
+			if err = add(materialKey{i, 0}, m); err != nil {
+				return
+			}
+			continue
+		}
+		for j := range m.Children {
+			if err = add(materialKey{i, j}, &m.Children[j]); err != nil {
+				return
+			}
+		}
+	}
+	// triangles with an invalid sub-material index fall back to the first one
+	for _, mesh := range p.Meshes {
+		for _, t := range mesh.Triangles {
+			key := materialKey{mesh.Material, t.Material}
+			if _, ok := materials[key]; !ok {
+				materials[key] = materials[materialKey{mesh.Material, 0}]
+			}
+		}
+	}
+	return
+}
+
+// ImageResolver that finds textures in the directory of the model first.
+type textureResolver struct {
+	dir string
+	r   aliceafa.ImageResolver
+}
+
+// Make an ImageResolver for the textures of a model, as texture names are relative to the model.
+// modelName is the name of the model entry, whose directory is separated by '\' or '/'.
+func TextureResolver(r aliceafa.ImageResolver, modelName string) aliceafa.ImageResolver {
+	i := strings.LastIndexAny(modelName, "\\/")
+	if i < 0 {
+		return r
+	}
+	return &textureResolver{dir: modelName[:i+1], r: r}
+}
+
+func (p *textureResolver) OpenImage(name string) (rs io.ReadSeeker, key string, err error) {
+	rs, key, err = p.r.OpenImage(p.dir + name)
+	if errors.Is(err, aliceafa.ErrImageNotFound) {
+		return p.r.OpenImage(name)
+	}
+	return
+}
+
+func (p *textureResolver) ImageKey(name string) (key string, err error) {
+	key, err = p.r.ImageKey(p.dir + name)
+	if errors.Is(err, aliceafa.ErrImageNotFound) {
+		return p.r.ImageKey(name)
+	}
+	return
+}
+
+// load a texture image. PNG and JPEG are loaded as-is, and the other images are converted to PNG.
+func loadTexture(ctx *aliceafa.DecodeContext, name string) (data []byte, mimeType string, err error) {
+	rs, _, err := ctx.Resolver.OpenImage(name)
+	if err != nil {
+		return
+	}
+	data, err = io.ReadAll(rs)
+	if err != nil {
+		return
+	}
+	t := aliceafa.DetectContentType(data)
+	switch t {
+	case aliceafa.ContentPNG:
+		return data, "image/png", nil
+	case aliceafa.ContentJPEG:
+		return data, "image/jpeg", nil
+	}
+	texCtx := *ctx
+	texCtx.Name = name
+	v, export, err := aliceafa.DecodeContent(t, bytes.NewReader(data), &texCtx)
+	if err != nil {
+		return
+	}
+	img, ok := v.(image.Image)
+	if export != aliceafa.ExportPNG || !ok {
+		return nil, "", fmt.Errorf("%s is not an image", name)
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	return buf.Bytes(), "image/png", err
+}
+
+// a vertex of glTF, a combination of a position, a UV, a color, an alpha and a normal
+type gltfVertexKey struct {
+	vertex, uv, color, alpha int
+	normal                   [3]float32
+}
+
+// add a primitive of triangles
+func (g *GLTF) addPrimitive(m *Mesh, triangles []Triangle, jointOf map[int]int) (prim gltfPrimitive, hasWeights bool) {
+	indexOf := make(map[gltfVertexKey]uint32)
+	var pos, uv, color, normal, weights []float32
+	var joints []uint16
+	var indices []uint32
+	for _, t := range triangles {
+		for k := 0; k < 3; k++ {
+			key := gltfVertexKey{t.Vertex[k], t.UV[k], t.Color[k], t.Alpha[k], t.Normal[k]}
+			i, ok := indexOf[key]
+			if !ok {
+				i = uint32(len(pos) / 3)
+				indexOf[key] = i
+				v := &m.Vertices[key.vertex]
+				pos = append(pos, v.Pos[:]...)
+				if len(m.UVs) > 0 {
+					uv = append(uv, m.UVs[key.uv][:]...)
+				}
+				if len(m.Colors) > 0 || len(m.Alphas) > 0 {
+					c := [4]float32{1, 1, 1, 1}
+					if len(m.Colors) > 0 {
+						copy(c[:], m.Colors[key.color][:])
+					}
+					if len(m.Alphas) > 0 {
+						c[3] = m.Alphas[key.alpha]
+					}
+					color = append(color, c[:]...)
+				}
+				normal = append(normal, key.normal[:]...)
+				j, w := vertexWeights(v.Weights, jointOf)
+				joints = append(joints, j[:]...)
+				weights = append(weights, w[:]...)
+				hasWeights = hasWeights || len(v.Weights) > 0
+			}
+			indices = append(indices, i)
+		}
+	}
+	prim.Attributes = map[string]int{
+		"POSITION": g.addFloats(pos, 3, "VEC3", true, gltfArrayBuffer),
+		"NORMAL":   g.addFloats(normal, 3, "VEC3", false, gltfArrayBuffer),
+	}
+	if len(uv) > 0 {
+		prim.Attributes["TEXCOORD_0"] = g.addFloats(uv, 2, "VEC2", false, gltfArrayBuffer)
+	}
+	if len(color) > 0 {
+		prim.Attributes["COLOR_0"] = g.addFloats(color, 4, "VEC4", false, gltfArrayBuffer)
+	}
+	if hasWeights {
+		prim.Attributes["JOINTS_0"] = g.addJoints(joints)
+		prim.Attributes["WEIGHTS_0"] = g.addFloats(weights, 4, "VEC4", false, gltfArrayBuffer)
+	}
+	prim.Indices = g.addIndices(indices)
+	return
+}
+
+// the four largest weights of a vertex, normalized
+func vertexWeights(ws []Weight, jointOf map[int]int) (joints [4]uint16, weights [4]float32) {
+	sorted := append([]Weight(nil), ws...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Weight > sorted[j].Weight })
+	var sum float32
+	for i := 0; i < 4 && i < len(sorted); i++ {
+		j, ok := jointOf[sorted[i].Bone]
+		if !ok {
+			continue
+		}
+		joints[i], weights[i] = uint16(j), sorted[i].Weight
+		sum += weights[i]
+	}
+	if sum <= 0 {
+		// not weighted: bound to the first joint
+		return [4]uint16{}, [4]float32{1, 0, 0, 0}
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return
+}
+
+// finish the document with the buffer
+func (g *GLTF) document(uri string) gltfDoc {
+	doc := g.doc
+	for g.bin.Len()%4 != 0 {
+		g.bin.WriteByte(0)
+	}
+	doc.Buffers = []gltfBuffer{{ByteLength: g.bin.Len(), URI: uri}}
+	return doc
+}
+
+// Write the asset as glTF JSON that refers to the binary buffer at binURI.
+// Write the buffer with WriteBin().
+func (g *GLTF) WriteJSON(w io.Writer, binURI string) (err error) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g.document(binURI))
+}
+
+// Write the binary buffer of the asset.
+func (g *GLTF) WriteBin(w io.Writer) (err error) {
+	g.document("")
+	_, err = w.Write(g.bin.Bytes())
+	return
+}
+
+// Write the asset as a binary glTF (.glb) file.
+func (g *GLTF) WriteGLB(w io.Writer) (err error) {
+	js, err := json.Marshal(g.document(""))
+	if err != nil {
+		return
+	}
+	for len(js)%4 != 0 {
+		js = append(js, ' ')
+	}
+	bin := g.bin.Bytes()
+	var buf bytes.Buffer
+	put := func(v uint32) {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	put(0x46546c67) // "glTF"
+	put(2)
+	put(uint32(12 + 8 + len(js) + 8 + len(bin)))
+	put(uint32(len(js)))
+	put(0x4e4f534a) // "JSON"
+	buf.Write(js)
+	put(uint32(len(bin)))
+	put(0x004e4942) // "BIN\0"
+	buf.Write(bin)
+	_, err = w.Write(buf.Bytes())
+	return
+}
@@ -0,0 +1,334 @@
+// Package reign reads 3D models and motions of AliceSoft games on the Reign engine, and exports them to glTF 2.0.
+//
+// A model (.pol, .mdl) has materials with texture file names, meshes of triangles, and a skeleton of bones.
+// Strings are zero-terminated ShiftJIS, and numbers are little-endian int32 and float32.
+// The model layout follows the reader of xsystem4; files that do not follow it fail with ErrInvalidFormat.
+// Motion (.mot) support is experimental: its layout is a guess not yet verified against real files.
+package reign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid Reign-engine data format")
+)
+
+// Type of a texture of a material.
+type TextureType int32
+
+const (
+	TextureColor    TextureType = 1
+	TextureNormal   TextureType = 2
+	TextureSpecular TextureType = 3
+	TextureAlpha    TextureType = 4
+	TextureLight    TextureType = 5
+)
+
+// A texture of a material.
+type Texture struct {
+	Type TextureType
+	Name string // file name of the texture image
+}
+
+// A material. A material with children is a group of sub-materials selected by the triangles.
+type Material struct {
+	Name     string
+	Textures []Texture
+	Children []Material `json:",omitempty"`
+}
+
+// Texture of the type. Returns an empty string if there is none.
+func (p *Material) Texture(t TextureType) string {
+	for _, tex := range p.Textures {
+		if tex.Type == t {
+			return tex.Name
+		}
+	}
+	return ""
+}
+
+// A bone weight of a vertex.
+type Weight struct {
+	Bone   int // bone ID
+	Weight float32
+}
+
+// A vertex of a mesh.
+type Vertex struct {
+	Pos     [3]float32
+	Weights []Weight `json:",omitempty"`
+}
+
+// A triangle of a mesh.
+type Triangle struct {
+	Vertex   [3]int
+	UV       [3]int
+	LightUV  [3]int `json:",omitempty"` // version 2
+	Color    [3]int
+	Alpha    [3]int
+	Normal   [3][3]float32
+	Material int // index of the sub-material if the material of the mesh has children
+}
+
+// A mesh.
+type Mesh struct {
+	Type      int // type of the mesh; 0 for a normal mesh
+	Name      string
+	Material  int // index of the material
+	Vertices  []Vertex
+	UVs       [][2]float32
+	LightUVs  [][2]float32 `json:",omitempty"` // version 2
+	Colors    [][3]float32 `json:",omitempty"` // vertex colors in RGB
+	Alphas    []float32    `json:",omitempty"` // vertex alphas
+	Triangles []Triangle
+}
+
+// A bone of the skeleton. The position and rotation are relative to the parent bone.
+type Bone struct {
+	Name   string
+	ID     int
+	Parent int        // ID of the parent bone, or -1
+	Pos    [3]float32 // translation
+	Rot    [4]float32 // rotation quaternion in x, y, z, w
+}
+
+// A model.
+type Model struct {
+	Signature string // "POL" or "MDL"
+	Version   int
+	Materials []Material
+	Meshes    []Mesh
+	Bones     []Bone
+}
+
+// Index of the bone with the ID. Returns -1 if not found.
+func (p *Model) BoneIndex(id int) int {
+	for i, b := range p.Bones {
+		if b.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Read a model.
+func ReadModel(r io.Reader) (model *Model, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return DecodeModel(data)
+}
+
+// Decode a model.
+//
+// The file is the signature "POL\0" or "MDL\0", the version, then lists of materials, meshes and bones, each prefixed by its length.
+// A material is the name, a list of textures (type and file name) and a list of child materials.
+// A mesh is the type, the name, the material index, lists of vertices (position and bone weights), UVs, light UVs in version 2,
+// colors, alphas, and triangles (vertex, UV, light UV, color and alpha indices, vertex normals and the sub-material index).
+// Data after the bones is ignored.
+// A bone is the name, the ID, the parent ID, the position and the rotation.
+func DecodeModel(data []byte) (model *Model, err error) {
+	if len(data) < 8 || (string(data[0:4]) != "POL\x00" && string(data[0:4]) != "MDL\x00") {
+		return nil, ErrInvalidFormat
+	}
+	model = &Model{Signature: string(data[0:3])}
+	r := &reader{b: data, pos: 4}
+	model.Version = int(r.int32())
+	if model.Version != 1 && model.Version != 2 {
+		return nil, fmt.Errorf("unsupported model version %d", model.Version)
+	}
+
+	model.Materials = make([]Material, r.count(9))
+	for i := range model.Materials {
+		model.Materials[i] = r.material(true)
+	}
+
+	model.Meshes = make([]Mesh, r.count(29))
+	for i := range model.Meshes {
+		m := &model.Meshes[i]
+		m.Type = int(r.int32())
+		m.Name = r.str()
+		m.Material = int(r.int32())
+		m.Vertices = make([]Vertex, r.count(16))
+		for j := range m.Vertices {
+			v := &m.Vertices[j]
+			v.Pos = r.vec3()
+			v.Weights = make([]Weight, r.count(8))
+			for k := range v.Weights {
+				v.Weights[k] = Weight{Bone: int(r.int32()), Weight: r.float32()}
+			}
+		}
+		m.UVs = r.uvs()
+		if model.Version >= 2 {
+			m.LightUVs = r.uvs()
+		}
+		m.Colors = make([][3]float32, r.count(12))
+		for j := range m.Colors {
+			m.Colors[j] = r.vec3()
+		}
+		m.Alphas = make([]float32, r.count(4))
+		for j := range m.Alphas {
+			m.Alphas[j] = r.float32()
+		}
+		m.Triangles = make([]Triangle, r.count(88))
+		for j := range m.Triangles {
+			t := &m.Triangles[j]
+			for k := range t.Vertex {
+				t.Vertex[k] = r.index(len(m.Vertices))
+			}
+			for k := range t.UV {
+				t.UV[k] = r.index(len(m.UVs))
+			}
+			if model.Version >= 2 {
+				for k := range t.LightUV {
+					t.LightUV[k] = r.index(len(m.LightUVs))
+				}
+			}
+			for k := range t.Color {
+				t.Color[k] = r.optIndex(len(m.Colors))
+			}
+			for k := range t.Alpha {
+				t.Alpha[k] = r.optIndex(len(m.Alphas))
+			}
+			for k := range t.Normal {
+				t.Normal[k] = r.vec3()
+			}
+			t.Material = int(r.int32())
+		}
+		if r.err == nil && (m.Material < 0 || m.Material >= len(model.Materials)) {
+			r.err = fmt.Errorf("mesh %s: invalid material index %d", m.Name, m.Material)
+		}
+	}
+
+	model.Bones = make([]Bone, r.count(37))
+	for i := range model.Bones {
+		b := &model.Bones[i]
+		b.Name = r.str()
+		b.ID = int(r.int32())
+		b.Parent = int(r.int32())
+		b.Pos = r.vec3()
+		for k := range b.Rot {
+			b.Rot[k] = r.float32()
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return
+}
+
+// little-endian reader with a sticky error
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) int32() int32 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+4 > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := int32(binary.LittleEndian.Uint32(r.b[r.pos:]))
+	r.pos += 4
+	return v
+}
+
+func (r *reader) float32() float32 {
+	return math.Float32frombits(uint32(r.int32()))
+}
+
+func (r *reader) vec3() (v [3]float32) {
+	for i := range v {
+		v[i] = r.float32()
+	}
+	return
+}
+
+// read a count of items of at least minSize bytes each, which must fit in the rest of the data
+func (r *reader) count(minSize int) int {
+	n := int(r.int32())
+	if r.err == nil && (n < 0 || (minSize > 0 && n > (len(r.b)-r.pos)/minSize)) {
+		r.err = fmt.Errorf("invalid count %d", n)
+		return 0
+	}
+	return n
+}
+
+// read an index less than n
+func (r *reader) index(n int) int {
+	i := int(r.int32())
+	if r.err == nil && (i < 0 || i >= n) {
+		r.err = fmt.Errorf("index %d out of range", i)
+		return 0
+	}
+	return i
+}
+
+// read an index less than n. the index is ignored if n is 0.
+func (r *reader) optIndex(n int) int {
+	if n == 0 {
+		r.int32()
+		return 0
+	}
+	return r.index(n)
+}
+
+// read a zero-terminated ShiftJIS string
+func (r *reader) str() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.b[r.pos:], 0)
+	if i < 0 {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	b := r.b[r.pos : r.pos+i]
+	r.pos += i + 1
+	s, err := japanese.ShiftJIS.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
+
+func (r *reader) uvs() (uvs [][2]float32) {
+	uvs = make([][2]float32, r.count(8))
+	for i := range uvs {
+		uvs[i] = [2]float32{r.float32(), r.float32()}
+	}
+	return
+}
+
+// read a material. child materials do not have children.
+func (r *reader) material(hasChildren bool) (m Material) {
+	m.Name = r.str()
+	m.Textures = make([]Texture, r.count(5))
+	for i := range m.Textures {
+		m.Textures[i] = Texture{Type: TextureType(r.int32()), Name: r.str()}
+	}
+	if n := r.count(9); n > 0 {
+		if !hasChildren {
+			r.err = fmt.Errorf("material %s: nested child materials", m.Name)
+			return
+		}
+		m.Children = make([]Material, n)
+		for i := range m.Children {
+			m.Children[i] = r.material(false)
+		}
+	}
+	return
+}
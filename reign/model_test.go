@@ -0,0 +1,219 @@
+package reign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+)
+
+// writer of test data
+type testWriter struct {
+	bytes.Buffer
+}
+
+func (w *testWriter) i32(v ...int32) {
+	binary.Write(w, binary.LittleEndian, v)
+}
+
+func (w *testWriter) f32(v ...float32) {
+	binary.Write(w, binary.LittleEndian, v)
+}
+
+func (w *testWriter) str(s string) {
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+// a version 1 model of a textured quad bound to two bones
+func testModel() []byte {
+	w := &testWriter{}
+	w.WriteString("POL\x00")
+	w.i32(1)
+
+	// materials
+	w.i32(1)
+	w.str("body")
+	w.i32(1)
+	w.i32(int32(TextureColor))
+	w.str("body.png")
+	w.i32(0)
+
+	// mesh
+	w.i32(1)
+	w.i32(0) // type
+	w.str("quad")
+	w.i32(0)
+	w.i32(4) // vertices
+	for i, p := range [][3]float32{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}} {
+		w.f32(p[:]...)
+		if i < 2 {
+			w.i32(1)
+			w.i32(10)
+			w.f32(1)
+		} else {
+			w.i32(2)
+			w.i32(10)
+			w.f32(0.25)
+			w.i32(20)
+			w.f32(0.75)
+		}
+	}
+	w.i32(4) // UVs
+	w.f32(0, 0, 1, 0, 1, 1, 0, 1)
+	w.i32(2) // colors
+	w.f32(1, 1, 1, 1, 0, 0)
+	w.i32(1) // alphas
+	w.f32(0.5)
+	w.i32(2) // triangles
+	for _, t := range [][3]int32{{0, 1, 2}, {0, 2, 3}} {
+		w.i32(t[:]...)
+		w.i32(t[:]...)
+		w.i32(0, 0, 1) // colors
+		w.i32(0, 0, 0) // alphas
+		w.f32(0, 0, 1, 0, 0, 1, 0, 0, 1)
+		w.i32(0)
+	}
+
+	// bones
+	w.i32(2)
+	w.str("root")
+	w.i32(10, -1)
+	w.f32(0, 0, 0, 0, 0, 0, 1)
+	w.str("arm")
+	w.i32(20, 10)
+	w.f32(0, 1, 0, 0, 0, 0, 1)
+
+	// data after the bones is ignored
+	w.i32(0)
+	return w.Bytes()
+}
+
+// ImageResolver of images in memory
+type testResolver map[string][]byte
+
+func (p testResolver) OpenImage(name string) (rs io.ReadSeeker, key string, err error) {
+	data, ok := p[name]
+	if !ok {
+		return nil, "", aliceafa.ErrImageNotFound
+	}
+	return bytes.NewReader(data), name, nil
+}
+
+func (p testResolver) ImageKey(name string) (key string, err error) {
+	if _, ok := p[name]; !ok {
+		return "", aliceafa.ErrImageNotFound
+	}
+	return name, nil
+}
+
+// parse a .glb file
+func parseGLB(t *testing.T, glb []byte) (doc map[string]interface{}, bin []byte) {
+	if len(glb) < 20 || string(glb[0:4]) != "glTF" || binary.LittleEndian.Uint32(glb[8:]) != uint32(len(glb)) {
+		t.Fatalf("invalid glb header")
+	}
+	jsonLen := binary.LittleEndian.Uint32(glb[12:])
+	if err := json.Unmarshal(glb[20:20+jsonLen], &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc, glb[20+jsonLen+8:]
+}
+
+func TestModel(t *testing.T) {
+	data := testModel()
+	if aliceafa.DetectContentType(data) != aliceafa.ContentPOL {
+		t.Errorf("content type not detected")
+	}
+	model, err := DecodeModel(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(model.Materials) != 1 || model.Materials[0].Texture(TextureColor) != "body.png" {
+		t.Errorf("unexpected materials: %+v", model.Materials)
+	}
+	if len(model.Meshes) != 1 || len(model.Meshes[0].Vertices) != 4 || len(model.Meshes[0].Triangles) != 2 {
+		t.Fatalf("unexpected meshes: %+v", model.Meshes)
+	}
+	if m := model.Meshes[0]; len(m.Colors) != 2 || m.Colors[1][0] != 1 || m.Alphas[0] != 0.5 || m.Triangles[1].Color != [3]int{0, 0, 1} {
+		t.Errorf("unexpected colors: %+v", m)
+	}
+	if w := model.Meshes[0].Vertices[3].Weights; len(w) != 2 || w[1].Bone != 20 || w[1].Weight != 0.75 {
+		t.Errorf("unexpected weights: %+v", w)
+	}
+	if len(model.Bones) != 2 || model.Bones[1].Parent != 10 || model.Bones[1].Pos[1] != 1 || model.BoneIndex(20) != 1 {
+		t.Errorf("unexpected bones: %+v", model.Bones)
+	}
+
+	// glTF with a texture in a subdirectory
+	var png1 bytes.Buffer
+	png.Encode(&png1, image.NewNRGBA(image.Rect(0, 0, 2, 2)))
+	resolver := TextureResolver(testResolver{`chara\body.png`: png1.Bytes()}, `chara\model.pol`)
+	g, err := model.GLTF(&aliceafa.DecodeContext{Resolver: resolver})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var glb bytes.Buffer
+	if err := g.WriteGLB(&glb); err != nil {
+		t.Fatal(err)
+	}
+	doc, bin := parseGLB(t, glb.Bytes())
+	count := func(key string) int {
+		a, _ := doc[key].([]interface{})
+		return len(a)
+	}
+	if count("nodes") != 3 || count("meshes") != 1 || count("skins") != 1 || count("images") != 1 || count("materials") != 1 {
+		t.Errorf("unexpected document: %v", doc)
+	}
+	img := doc["images"].([]interface{})[0].(map[string]interface{})
+	view := doc["bufferViews"].([]interface{})[int(img["bufferView"].(float64))].(map[string]interface{})
+	off, n := int(view["byteOffset"].(float64)), int(view["byteLength"].(float64))
+	if !bytes.Equal(bin[off:off+n], png1.Bytes()) {
+		t.Errorf("texture is not embedded")
+	}
+	mesh := doc["meshes"].([]interface{})[0].(map[string]interface{})
+	attrs := mesh["primitives"].([]interface{})[0].(map[string]interface{})["attributes"].(map[string]interface{})
+	for _, a := range []string{"POSITION", "NORMAL", "TEXCOORD_0", "COLOR_0", "JOINTS_0", "WEIGHTS_0"} {
+		if _, ok := attrs[a]; !ok {
+			t.Errorf("attribute %s is missing", a)
+		}
+	}
+
+	// inverse bind matrix of the arm bone is a translation by -1 in y
+	skin := doc["skins"].([]interface{})[0].(map[string]interface{})
+	acc := doc["accessors"].([]interface{})[int(skin["inverseBindMatrices"].(float64))].(map[string]interface{})
+	view = doc["bufferViews"].([]interface{})[int(acc["bufferView"].(float64))].(map[string]interface{})
+	off = int(view["byteOffset"].(float64))
+	ibm := make([]float32, 32)
+	binary.Read(bytes.NewReader(bin[off:]), binary.LittleEndian, ibm)
+	if ibm[16+13] != -1 || ibm[16+0] != 1 || ibm[16+15] != 1 {
+		t.Errorf("unexpected inverse bind matrix: %v", ibm[16:])
+	}
+
+	// missing texture is omitted
+	g, err = model.GLTF(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var js bytes.Buffer
+	if err := g.WriteJSON(&js, "model.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(js.Bytes(), []byte(`"images"`)) || !bytes.Contains(js.Bytes(), []byte(`"uri": "model.bin"`)) {
+		t.Errorf("unexpected glTF JSON:\n%s", js.String())
+	}
+
+	// errors
+	if _, err := DecodeModel(data[:len(data)-8]); err == nil {
+		t.Errorf("truncated data must fail")
+	}
+	bad := append([]byte(nil), data...)
+	bad[4] = 9
+	if _, err := DecodeModel(bad); err == nil {
+		t.Errorf("unknown version must fail")
+	}
+}
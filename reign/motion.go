@@ -0,0 +1,138 @@
+package reign
+
+import (
+	"fmt"
+	"io"
+)
+
+// Frame rate of motions.
+const MotionFPS = 30
+
+// A key frame of a bone. The position and rotation are relative to the parent bone, like Bone.
+type KeyFrame struct {
+	Pos [3]float32
+	Rot [4]float32 // rotation quaternion in x, y, z, w
+}
+
+// Key frames of a bone.
+type BoneTrack struct {
+	Name   string
+	ID     int
+	Parent int // ID of the parent bone, or -1
+	Frames []KeyFrame
+}
+
+// A skeletal animation.
+type Motion struct {
+	Version   int
+	NumFrames int // number of frames, at MotionFPS
+	Bones     []BoneTrack
+}
+
+// Length of the motion in seconds.
+func (p *Motion) Duration() float64 {
+	if p.NumFrames == 0 {
+		return 0
+	}
+	return float64(p.NumFrames-1) / MotionFPS
+}
+
+// Read a motion.
+func ReadMotion(r io.Reader) (mot *Motion, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return DecodeMotion(data)
+}
+
+// Decode a motion.
+//
+// The file is the signature "MOT\0", the version, the number of frames and the number of bones.
+// Each bone is the name, the ID, the parent ID, and the position and the rotation of every frame.
+// The layout is not verified against real files yet.
+func DecodeMotion(data []byte) (mot *Motion, err error) {
+	if len(data) < 16 || string(data[0:4]) != "MOT\x00" {
+		return nil, ErrInvalidFormat
+	}
+	r := &reader{b: data, pos: 4}
+	mot = &Motion{Version: int(r.int32())}
+	mot.NumFrames = r.count(28) // a key frame is 28 bytes
+	frameSize := 28 * mot.NumFrames
+	mot.Bones = make([]BoneTrack, r.count(9+frameSize))
+	for i := range mot.Bones {
+		b := &mot.Bones[i]
+		b.Name = r.str()
+		b.ID = int(r.int32())
+		b.Parent = int(r.int32())
+		if r.err == nil && frameSize > len(r.b)-r.pos {
+			r.err = io.ErrUnexpectedEOF
+		}
+		if r.err != nil {
+			break
+		}
+		b.Frames = make([]KeyFrame, mot.NumFrames)
+		for j := range b.Frames {
+			f := &b.Frames[j]
+			f.Pos = r.vec3()
+			for k := range f.Rot {
+				f.Rot[k] = r.float32()
+			}
+		}
+	}
+	if r.err == nil && r.pos != len(r.b) {
+		r.err = fmt.Errorf("%d extra bytes at the end of the motion", len(r.b)-r.pos)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return
+}
+
+// Add a motion to the glTF asset of a model as an animation.
+// The tracks are attached to the bones of the model by name; tracks of bones not in the model are ignored.
+func (g *GLTF) AddMotion(name string, mot *Motion) (err error) {
+	if mot.NumFrames == 0 {
+		return fmt.Errorf("motion %s has no frames", name)
+	}
+	times := make([]float32, mot.NumFrames)
+	for i := range times {
+		times[i] = float32(i) / MotionFPS
+	}
+	anim := gltfAnimation{Name: name}
+	input := -1
+	for _, b := range mot.Bones {
+		node, ok := g.boneNodes[b.Name]
+		if !ok {
+			continue
+		}
+		if input < 0 {
+			input = g.addFloats(times, 1, "SCALAR", true, 0)
+		}
+		pos := make([]float32, 0, 3*len(b.Frames))
+		rot := make([]float32, 0, 4*len(b.Frames))
+		for _, f := range b.Frames {
+			pos = append(pos, f.Pos[:]...)
+			rot = append(rot, f.Rot[:]...)
+		}
+		for _, out := range []struct {
+			path string
+			acc  int
+		}{
+			{"translation", g.addFloats(pos, 3, "VEC3", false, 0)},
+			{"rotation", g.addFloats(rot, 4, "VEC4", false, 0)},
+		} {
+			anim.Samplers = append(anim.Samplers, gltfAnimationSampler{Input: input, Output: out.acc, Interpolation: "LINEAR"})
+			var ch gltfAnimationChannel
+			ch.Sampler = len(anim.Samplers) - 1
+			ch.Target.Node = node
+			ch.Target.Path = out.path
+			anim.Channels = append(anim.Channels, ch)
+		}
+	}
+	if len(anim.Channels) == 0 {
+		return fmt.Errorf("motion %s has no bones of the model", name)
+	}
+	g.doc.Animations = append(g.doc.Animations, anim)
+	return
+}
@@ -0,0 +1,101 @@
+package reign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+)
+
+// a motion of 3 frames, rotating the arm bone, with a bone not in the test model
+func testMotion() []byte {
+	w := &testWriter{}
+	w.WriteString("MOT\x00")
+	w.i32(1, 3, 2)
+	w.str("arm")
+	w.i32(20, 10)
+	for _, z := range []float32{0, 0.5, 0.70710677} {
+		w.f32(0, 1, 0)
+		w.f32(0, 0, z, 1-z*z)
+	}
+	w.str("tail")
+	w.i32(30, 10)
+	for i := 0; i < 3; i++ {
+		w.f32(0, 0, 0, 0, 0, 0, 1)
+	}
+	return w.Bytes()
+}
+
+func TestMotion(t *testing.T) {
+	data := testMotion()
+	if aliceafa.DetectContentType(data) != aliceafa.ContentMOT {
+		t.Errorf("content type not detected")
+	}
+	mot, err := DecodeMotion(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mot.NumFrames != 3 || len(mot.Bones) != 2 || mot.Bones[0].Name != "arm" || mot.Bones[0].Frames[1].Rot[2] != 0.5 {
+		t.Errorf("unexpected motion: %+v", mot)
+	}
+	if mot.Duration() != 2.0/MotionFPS {
+		t.Errorf("unexpected duration %v", mot.Duration())
+	}
+
+	model, err := DecodeModel(testModel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := model.GLTF(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddMotion("wave", mot); err != nil {
+		t.Fatal(err)
+	}
+	var glb bytes.Buffer
+	if err := g.WriteGLB(&glb); err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := parseGLB(t, glb.Bytes())
+	anims, _ := doc["animations"].([]interface{})
+	if len(anims) != 1 {
+		t.Fatalf("unexpected animations: %v", doc["animations"])
+	}
+	anim := anims[0].(map[string]interface{})
+	channels := anim["channels"].([]interface{})
+	if anim["name"] != "wave" || len(channels) != 2 {
+		t.Errorf("unexpected animation: %v", anim)
+	}
+	for _, c := range channels {
+		target := c.(map[string]interface{})["target"].(map[string]interface{})
+		node := doc["nodes"].([]interface{})[int(target["node"].(float64))].(map[string]interface{})
+		if node["name"] != "arm" {
+			t.Errorf("channel targets %v", node["name"])
+		}
+	}
+	input := anim["samplers"].([]interface{})[0].(map[string]interface{})["input"].(float64)
+	acc := doc["accessors"].([]interface{})[int(input)].(map[string]interface{})
+	if acc["count"].(float64) != 3 || math.Abs(acc["max"].([]interface{})[0].(float64)-2.0/MotionFPS) > 1e-6 {
+		t.Errorf("unexpected time accessor: %v", acc)
+	}
+
+	// errors
+	mot.Bones = mot.Bones[1:]
+	if err := g.AddMotion("tail", mot); err == nil {
+		t.Errorf("motion without bones of the model must fail")
+	}
+	if _, err := DecodeMotion(data[:len(data)-1]); err == nil {
+		t.Errorf("truncated data must fail")
+	}
+	for _, n := range [][2]uint32{{0x7fffffff, 1}, {0x10000000, 0x7fffffff}, {1, 0x7fffffff}} { // frames and bones
+		bad := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(bad[8:], n[0])
+		binary.LittleEndian.PutUint32(bad[12:], n[1])
+		if _, err := DecodeMotion(bad); err == nil {
+			t.Errorf("broken counts %x must fail", n)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package sco
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrBrokenText     = errors.New("broken text")
+	ErrBrokenArgument = errors.New("broken command argument")
+)
+
+// Error of walking the command stream.
+type CommandError struct {
+	Offset  int  // file offset of the command
+	Command byte // the command byte
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command %q at 0x%x: %v", e.Command, e.Offset, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Kinds of command arguments in the command table.
+const (
+	argExpr     = 'e' // expression terminated by 0x7f
+	argVariable = 'v' // variable
+	argAddress  = 'a' // 32-bit address in the page
+	argString   = 's' // ShiftJIS string terminated by ':'
+)
+
+// Arguments of the commands, by the command name.
+// A command name is one byte, or a family byte such as B, M, N, U, W or Z followed by the subcommand;
+// the longest name matching the stream is taken.
+// The layouts follow the System 3.x command reference; a command not listed stops the walk.
+var commands = map[string]string{
+	"!":  "ve",     // assign: variable, value
+	"{":  "ea",     // if: condition, address of the end of the block
+	"@":  "a",      // jump to a label
+	"\\": "a",      // call a label; address 0 returns
+	"&":  "e",      // jump to a page
+	"%":  "e",      // call a page; page 0 returns
+	"]":  "",       // open the menu
+	"A":  "",       // wait for a key
+	"R":  "",       // new line
+	"E":  "eeeeee", // fill a box: color, x1, y1, x2, y2, unused
+	"G":  "e",      // show a CG
+	"H":  "ee",     // show a number: digits, value
+	"J":  "ee",     // position of the next CG: x, y
+	"L":  "e",      // load the game
+	"P":  "eeee",   // palette: number, r, g, b
+	"Q":  "e",      // save the game
+	"S":  "e",      // play a sound
+	"T":  "ee",     // text position: x, y
+	"X":  "e",      // show a string variable
+	"Y":  "ee",     // misc command: function, value
+
+	// message windows and the menu window
+	"B1":  "eeeeee", // message window: number, x, y, width, height, mode
+	"B2":  "eeeeee", // message window frame: number, frame, color, background, shadow, unused
+	"B3":  "eeeeee", // menu window: number, x, y, width, height, mode
+	"B4":  "eeeeee", // menu window frame: number, frame, color, background, shadow, unused
+	"B10": "vv",     // message window position to variables: x, y
+	"B11": "vv",     // message window size to variables: width, height
+	"B12": "vv",     // menu window position to variables: x, y
+	"B13": "vv",     // menu window size to variables: width, height
+	"B14": "vv",     // text position to variables: x, y
+
+	// string variables
+	"MA": "ee", // append a string variable: destination, source
+	"MC": "ee", // copy a string variable: destination, source
+	"MP": "ee", // show a string variable: number, length
+	"MS": "es", // set a string variable: number, string
+	"MT": "s",  // window title
+	"MV": "e",  // version of the scenario
+
+	// variable arrays
+	"NB": "vve", // copy a block: source, destination, count
+	"NC": "ve",  // clear: variable, count
+	"NR": "ve",  // square root: variable, value
+	"N+": "vee", // add: variable, value, count
+	"N-": "vee", // subtract: variable, value, count
+	"N*": "vee", // multiply: variable, value, count
+	"N/": "vee", // divide: variable, value, count
+
+	// user interface
+	"UC": "ee", // clear the user variables: mode, number
+	"UD": "e",  // show the user variables: mode
+	"UR": "v",  // restore the user variables to a variable
+
+	// windows
+	"WV": "eeee", // viewport: x, y, width, height
+	"WW": "eee",  // canvas size: width, height, colors
+	"WX": "eeee", // window position: x, y, width, height
+	"WZ": "ee",   // window mode: number, value
+
+	// system settings
+	"ZB": "e",  // font weight
+	"ZC": "ee", // system setting: number, value
+	"ZE": "e",  // selection mode
+	"ZF": "e",  // frame color
+	"ZH": "e",  // half-width text
+	"ZI": "ee", // key mapping: key, value
+	"ZL": "e",  // line spacing
+	"ZM": "e",  // message font size
+	"ZR": "ev", // random number: range, variable
+	"ZS": "e",  // menu font size
+	"ZT": "e",  // timer
+	"ZW": "e",  // CAPS lock
+	"ZZ": "ee", // system command: function, value
+}
+
+// longest command name in the table
+const maxCommandLen = 3
+
+// Find the command at the start of b by the longest name. n is the length of the name.
+func lookupCommand(b []byte) (args string, n int, ok bool) {
+	n = maxCommandLen
+	if n > len(b) {
+		n = len(b)
+	}
+	for ; n > 0; n-- {
+		if args, ok = commands[string(b[:n])]; ok {
+			return
+		}
+	}
+	return "", 0, false
+}
+
+// reader of command arguments with a sticky error
+type walker struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (w *walker) byte() byte {
+	if w.err != nil {
+		return 0
+	}
+	if w.pos >= len(w.b) {
+		w.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	c := w.b[w.pos]
+	w.pos++
+	return c
+}
+
+func (w *walker) args(kinds string) {
+	for i := 0; i < len(kinds) && w.err == nil; i++ {
+		switch kinds[i] {
+		case argExpr:
+			w.expr()
+		case argVariable:
+			w.variable(w.byte())
+		case argAddress:
+			w.address()
+		case argString:
+			w.str()
+		}
+	}
+}
+
+// skip a string terminated by ':'
+func (w *walker) str() {
+	for w.err == nil && w.byte() != ':' {
+	}
+}
+
+// read an address. it must be in the page, or 0.
+func (w *walker) address() int {
+	if w.err != nil {
+		return 0
+	}
+	if w.pos+4 > len(w.b) {
+		w.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	a := int(binary.LittleEndian.Uint32(w.b[w.pos:]))
+	w.pos += 4
+	if a < 0 || a > len(w.b) {
+		w.err = fmt.Errorf("%w: address 0x%x out of the page", ErrBrokenArgument, a)
+	}
+	return a
+}
+
+// read the rest of a variable starting with c.
+//
+// 0x80-0xbf is a variable of one byte, and 0xc1-0xff is a variable of two bytes.
+// 0xc0 0x01 is an array element: two bytes of the variable and the index expression.
+func (w *walker) variable(c byte) {
+	switch {
+	case c >= 0x80 && c < 0xc0:
+	case c == 0xc0:
+		if w.byte() == 0x01 {
+			w.byte()
+			w.byte()
+			w.expr()
+		}
+	case c > 0xc0:
+		w.byte()
+	default:
+		if w.err == nil {
+			w.err = fmt.Errorf("%w: invalid variable 0x%02x", ErrBrokenArgument, c)
+		}
+	}
+}
+
+// read an expression in reverse Polish notation.
+//
+// 0x00-0x3f is a constant of two bytes, 0x40-0x73 is a constant of one byte,
+// 0x74-0x7e is an operator, 0x7f terminates the expression, and 0x80-0xff is a variable.
+func (w *walker) expr() {
+	for w.err == nil {
+		c := w.byte()
+		switch {
+		case c == 0x7f:
+			return
+		case c < 0x40:
+			w.byte()
+		case c < 0x80:
+		default:
+			w.variable(c)
+		}
+	}
+}
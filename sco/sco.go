@@ -0,0 +1,187 @@
+// Package sco reads .SCO scenario pages of AliceSoft System 3.x games.
+//
+// A scenario page is a header with the page name, followed by a stream of commands and messages.
+// Messages are ShiftJIS text at the position of a command, and menu items are written as '$' address text '$'.
+package sco
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid SCO format")
+)
+
+// known signatures of SCO files
+var signatures = []string{"S350", "S351", "153S", "S360", "S380"}
+
+// A scenario page.
+type SCO struct {
+	Signature  string // file version, such as "S350" or "S380"
+	HeaderSize int    // offset of the command stream
+	Size       int    // size of the page
+	Page       int    // page number
+	Name       string // source file name of the page
+	Data       []byte // the whole file
+}
+
+// Read a scenario page.
+func Read(r io.Reader) (sco *SCO, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	return Decode(data)
+}
+
+// Decode a scenario page.
+func Decode(data []byte) (sco *SCO, err error) {
+	if len(data) < 18 {
+		return nil, ErrInvalidFormat
+	}
+	sco = &SCO{Signature: string(data[0:4]), Data: data}
+	known := false
+	for _, s := range signatures {
+		known = known || s == sco.Signature
+	}
+	if !known {
+		return nil, ErrInvalidFormat
+	}
+	sco.HeaderSize = int(binary.LittleEndian.Uint32(data[4:]))
+	sco.Size = int(binary.LittleEndian.Uint32(data[8:]))
+	sco.Page = int(binary.LittleEndian.Uint32(data[12:]))
+	nameLen := int(binary.LittleEndian.Uint16(data[16:]))
+	if 18+nameLen > len(data) || sco.HeaderSize < 18+nameLen || sco.HeaderSize > len(data) {
+		return nil, ErrInvalidFormat
+	}
+	if sco.Size > len(data) {
+		return nil, fmt.Errorf("page size 0x%x is larger than the data", sco.Size)
+	}
+	sco.Name = decodeString(data[18 : 18+nameLen])
+	return
+}
+
+// the command stream
+func (p *SCO) stream() []byte {
+	end := p.Size
+	if end == 0 || end > len(p.Data) {
+		end = len(p.Data)
+	}
+	return p.Data[:end]
+}
+
+// Kind of a text.
+type TextKind int
+
+const (
+	TextMessage TextKind = iota // a message
+	TextMenu                    // a menu item
+)
+
+func (k TextKind) String() string {
+	if k == TextMenu {
+		return "menu"
+	}
+	return "message"
+}
+
+// A text in the command stream.
+type Text struct {
+	Kind   TextKind
+	Offset int // file offset of the text bytes
+	Length int // length of the text bytes
+	Target int // jump address of a menu item
+	Text   string
+}
+
+// Extract messages and menu items with their offsets.
+//
+// The command stream is walked command by command, and a text is reported only where a message appears
+// at the position of a command, or as the text of a menu item.
+// The walk stops at a command not in the command table, or at broken arguments;
+// the texts found until then are returned with a *CommandError.
+func (p *SCO) Texts() (texts []Text, err error) {
+	w := &walker{b: p.stream(), pos: p.HeaderSize}
+	for w.pos < len(w.b) {
+		cmdPos := w.pos
+		c := w.b[w.pos]
+		if isTextByte(c) {
+			n := textLen(w.b[w.pos:])
+			if n == 0 {
+				return texts, &CommandError{Offset: cmdPos, Command: c, Err: ErrBrokenText}
+			}
+			texts = append(texts, Text{Kind: TextMessage, Offset: w.pos, Length: n, Text: decodeString(w.b[w.pos : w.pos+n])})
+			w.pos += n
+			continue
+		}
+		if c == '$' {
+			w.pos++
+			// menu item: '$', address, text, '$'
+			target := w.address()
+			n := textLen(w.b[w.pos:])
+			if w.err == nil && (n == 0 || w.pos+n >= len(w.b) || w.b[w.pos+n] != '$') {
+				w.err = ErrBrokenText
+			}
+			if w.err != nil {
+				return texts, &CommandError{Offset: cmdPos, Command: c, Err: w.err}
+			}
+			texts = append(texts, Text{Kind: TextMenu, Offset: w.pos, Length: n, Target: target, Text: decodeString(w.b[w.pos : w.pos+n])})
+			w.pos += n + 1
+			continue
+		}
+		args, n, ok := lookupCommand(w.b[w.pos:])
+		if !ok {
+			return texts, &CommandError{Offset: cmdPos, Command: c, Err: ErrUnknownCommand}
+		}
+		w.pos += n
+		w.args(args)
+		if w.err != nil {
+			return texts, &CommandError{Offset: cmdPos, Command: c, Err: w.err}
+		}
+	}
+	return
+}
+
+// whether a message starts with the byte
+func isTextByte(c byte) bool {
+	return c == ' ' || (c >= 0x81 && c <= 0x9f) || (c >= 0xa1 && c <= 0xdf) || (c >= 0xe0 && c <= 0xfc)
+}
+
+// length of the ShiftJIS text at the start of b
+func textLen(b []byte) (n int) {
+	for n < len(b) {
+		c := b[n]
+		switch {
+		case (c >= 0x81 && c <= 0x9f) || (c >= 0xe0 && c <= 0xfc):
+			// double-byte character
+			if n+1 >= len(b) {
+				return
+			}
+			t := b[n+1]
+			if t < 0x40 || t == 0x7f || t > 0xfc {
+				return
+			}
+			n += 2
+		case (c >= 0xa1 && c <= 0xdf) || c == ' ':
+			// half-width katakana and space
+			n++
+		default:
+			return
+		}
+	}
+	return
+}
+
+// convert a ShiftJIS string to UTF-8
+func decodeString(b []byte) string {
+	s, err := japanese.ShiftJIS.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
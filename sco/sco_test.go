@@ -0,0 +1,127 @@
+package sco
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+
+	aliceafa "github.com/mixcode/alicesoft-afa"
+)
+
+func sjis(s string) []byte {
+	b, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestSCO(t *testing.T) {
+	name := sjis("ＴＥＳＴ.ADV")
+	hdrSize := 18 + len(name)
+	hdrSize = (hdrSize + 15) &^ 15
+
+	var body bytes.Buffer
+	body.Write([]byte{'!', 0x81, 0x41, 0x7f}) // variable assignment; 0x81 0x41 must not be a message
+	msgOffset := hdrSize + body.Len()
+	body.Write(sjis("こんにちは、世界"))
+	body.WriteString("R")
+	body.Write([]byte{'{', 0x81, 0x01, 0x00, 0x74, 0x7f}) // condition with a two-byte constant
+	binary.Write(&body, binary.LittleEndian, uint32(hdrSize))
+	body.Write(sjis("　ＯＫ"))
+	body.WriteString("A")
+	menuOffset := hdrSize + body.Len() + 5
+	body.WriteByte('$')
+	binary.Write(&body, binary.LittleEndian, uint32(hdrSize))
+	body.Write(sjis("はい"))
+	body.WriteByte('$')
+	body.WriteString("]")
+
+	// commands of the families, then another menu
+	e := []byte{0x41, 0x7f} // expression of a constant
+	body.WriteString("B1")
+	body.Write(bytes.Repeat(e, 6))
+	body.WriteString("B10")
+	body.Write([]byte{0x81, 0x82})
+	body.WriteString("MS")
+	body.Write(e)
+	body.Write(sjis("名前:"))
+	body.WriteString("MT")
+	body.Write(sjis("タイトル:"))
+	body.Write([]byte{'N', 'B', 0x81, 0xc1, 0x10})
+	body.Write(e)
+	body.WriteString("ZC")
+	body.Write(bytes.Repeat(e, 2))
+	body.WriteString("WW")
+	body.Write(bytes.Repeat(e, 3))
+	menu2Offset := hdrSize + body.Len() + 5
+	body.WriteByte('$')
+	binary.Write(&body, binary.LittleEndian, uint32(hdrSize))
+	body.Write(sjis("いいえ"))
+	body.WriteByte('$')
+	body.WriteString("]")
+
+	unknownOffset := hdrSize + body.Len()
+	body.WriteString("B9")
+	body.Write(sjis("引数"))
+
+	var buf bytes.Buffer
+	buf.WriteString("S351")
+	binary.Write(&buf, binary.LittleEndian, uint32(hdrSize))
+	binary.Write(&buf, binary.LittleEndian, uint32(hdrSize+body.Len()))
+	binary.Write(&buf, binary.LittleEndian, uint32(3))
+	binary.Write(&buf, binary.LittleEndian, uint16(len(name)))
+	buf.Write(name)
+	buf.Write(make([]byte, hdrSize-buf.Len()))
+	buf.Write(body.Bytes())
+	data := buf.Bytes()
+
+	if aliceafa.DetectContentType(data) != aliceafa.ContentSCO {
+		t.Errorf("content type not detected")
+	}
+	sco, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sco.Signature != "S351" || sco.Page != 3 || sco.Name != "ＴＥＳＴ.ADV" || sco.HeaderSize != hdrSize {
+		t.Errorf("unexpected header: %+v", sco)
+	}
+
+	texts, err := sco.Texts()
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || !errors.Is(err, ErrUnknownCommand) || cmdErr.Offset != unknownOffset || cmdErr.Command != 'B' {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(texts) != 4 {
+		t.Fatalf("unexpected texts: %+v", texts)
+	}
+	if m := texts[0]; m.Kind != TextMessage || m.Offset != msgOffset || m.Text != "こんにちは、世界" {
+		t.Errorf("unexpected message: %+v", m)
+	}
+	if m := texts[1]; m.Kind != TextMessage || m.Text != "　ＯＫ" {
+		t.Errorf("unexpected message: %+v", m)
+	}
+	if m := texts[2]; m.Kind != TextMenu || m.Offset != menuOffset || m.Target != hdrSize || m.Text != "はい" || m.Length != 4 {
+		t.Errorf("unexpected menu item: %+v", m)
+	}
+	if m := texts[3]; m.Kind != TextMenu || m.Offset != menu2Offset || m.Text != "いいえ" {
+		t.Errorf("unexpected menu item after the family commands: %+v", m)
+	}
+
+	// errors
+	if _, err := Decode(data[:10]); err == nil {
+		t.Errorf("truncated data must fail")
+	}
+	if _, err := Decode(append([]byte("XXXX"), data[4:]...)); err == nil {
+		t.Errorf("unknown signature must fail")
+	}
+	sco.Data = append(data[:msgOffset:msgOffset], '!', 0x81, 0x41) // unterminated expression
+	sco.Size = len(sco.Data)
+	if texts, err := sco.Texts(); len(texts) != 0 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected result of broken argument: %v, %v", texts, err)
+	}
+}
@@ -0,0 +1,100 @@
+package aliceafa
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+)
+
+// A file found by Walk.
+type WalkEntry struct {
+	Path    string        // path of the file. names of the containers and the file are joined with "/"
+	Depth   int           // number of the containers the file is nested in, excluding the outermost archive
+	Type    ContentType   // content type by the magic, or by the extension if not detected
+	Size    int64         // size of the content
+	Content io.ReadSeeker // the content positioned at the beginning. AFF is unwrapped if the key is set
+
+	// Resolver of the base images of DCF, which searches the containers of the file from the innermost one.
+	Resolver LayeredResolver
+}
+
+// Function called by Walk for each file.
+type WalkFunc func(e *WalkEntry) error
+
+// Whether the content type is a container Walk can descend into.
+func (t ContentType) IsContainer() bool {
+	switch t {
+	case ContentAFA, ContentALD, ContentFLAT:
+		return true
+	}
+	return false
+}
+
+// Open the content as a container.
+func openContainer(t ContentType, rs io.ReadSeeker) (arch *AliceArch, err error) {
+	switch t {
+	case ContentAFA:
+		return OpenAFA(rs)
+	case ContentALD:
+		return OpenALD(rs)
+	case ContentFLAT:
+		return OpenFLAT(rs)
+	}
+	return nil, ErrInvalidArchive
+}
+
+// Walk the files of an archive, and call fn for each file.
+// ra must be the archive file the arch is loaded from.
+//
+// AFF-wrapped files are unwrapped if the key is set with SetAFFKey().
+// Nested containers (AFA, ALD and FLAT) are descended into up to maxDepth levels; with maxDepth 0, only the files of arch are walked.
+// A container not descended into, or failed to open, is passed to fn as a file.
+func Walk(ra io.ReaderAt, arch *AliceArch, maxDepth int, fn WalkFunc) error {
+	return walk(ra, arch, "", 0, maxDepth, nil, fn)
+}
+
+// walk the files of arch. parent is the resolver of the containers of arch.
+func walk(ra io.ReaderAt, arch *AliceArch, prefix string, depth, maxDepth int, parent LayeredResolver, fn WalkFunc) (err error) {
+	resolver := LayeredResolver{NewArchiveResolver(arch, io.NewSectionReader(ra, 0, math.MaxInt64))}
+	resolver = append(resolver, parent...)
+	for _, e := range arch.Entry {
+		we := &WalkEntry{Path: prefix + e.Name, Depth: depth, Size: e.Size, Resolver: resolver}
+		we.Type, we.Content, err = SniffContent(io.NewSectionReader(ra, e.Offset, e.Size))
+		if err != nil {
+			return fmt.Errorf("%s: %w", we.Path, err)
+		}
+		if r, ok := we.Content.(*AFFReader); ok {
+			we.Size = r.Size()
+		}
+		if we.Type == ContentUnknown {
+			we.Type = ContentTypeByExt(filepath.Ext(e.Name))
+		}
+
+		if depth < maxDepth && we.Type.IsContainer() {
+			inner, e := openContainer(we.Type, we.Content)
+			if e == nil {
+				innerRA, ok := we.Content.(io.ReaderAt)
+				if !ok {
+					return fmt.Errorf("%s: content is not readable at offsets", we.Path)
+				}
+				err = walk(innerRA, inner, we.Path+"/", depth+1, maxDepth, resolver, fn)
+				if err != nil {
+					return
+				}
+				continue
+			}
+			// not a valid container; pass it as a file
+			_, err = we.Content.Seek(0, io.SeekStart)
+			if err != nil {
+				return
+			}
+		}
+
+		err = fn(we)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
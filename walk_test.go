@@ -0,0 +1,225 @@
+package aliceafa
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type testFile struct {
+	name string
+	data []byte
+}
+
+// make an AFA v2 archive
+func encodeTestAFA(t *testing.T, files []testFile) []byte {
+	var info, body bytes.Buffer
+	le := binary.LittleEndian
+	for _, f := range files {
+		padded := (len(f.name) + 4) &^ 3
+		binary.Write(&info, le, []uint32{uint32(len(f.name)), uint32(padded)})
+		info.WriteString(f.name)
+		info.Write(make([]byte, padded-len(f.name)))
+		binary.Write(&info, le, []uint32{0, 0, uint32(8 + body.Len()), uint32(len(f.data))})
+		body.Write(f.data)
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(info.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("AFAH")
+	binary.Write(&b, le, uint32(0x1c))
+	b.WriteString("AlicArch")
+	binary.Write(&b, le, []uint32{2, 1, uint32(0x1c + 0x10 + z.Len())})
+	b.WriteString("INFO")
+	binary.Write(&b, le, []uint32{uint32(0x10 + z.Len()), uint32(info.Len()), uint32(len(files))})
+	b.Write(z.Bytes())
+	b.WriteString("DATA")
+	binary.Write(&b, le, uint32(8+body.Len()))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+// make a FLAT file with a thumbnail and a library
+func encodeTestFLAT(thumbnail []byte, files []testFile) []byte {
+	le := binary.LittleEndian
+	pad := func(b *bytes.Buffer, data []byte) {
+		binary.Write(b, le, uint32(len(data)))
+		b.Write(data)
+		b.Write(make([]byte, (4-len(data)%4)%4))
+	}
+	var libl bytes.Buffer
+	binary.Write(&libl, le, uint32(len(files)))
+	for _, f := range files {
+		binary.Write(&libl, le, uint32(0))
+		pad(&libl, []byte(f.name))
+		binary.Write(&libl, le, uint32(2))
+		pad(&libl, append([]byte{1, 0, 0, 0}, f.data...)) // CG with a prefix
+	}
+
+	var b bytes.Buffer
+	b.WriteString("ELNA")
+	binary.Write(&b, le, uint32(0))
+	for _, c := range []struct {
+		sig  string
+		body []byte
+	}{
+		{"FLAT", make([]byte, 12)},
+		{"TMNL", thumbnail},
+		{"LIBL", libl.Bytes()},
+	} {
+		b.WriteString(c.sig)
+		binary.Write(&b, le, uint32(len(c.body)))
+		b.Write(c.body)
+	}
+	return b.Bytes()
+}
+
+func TestWalk(t *testing.T) {
+	defer SetAFFKey(nil)
+	SetAFFKey([]byte{0x55, 0xaa, 0x01, 0x02})
+
+	var qnt bytes.Buffer
+	err := EncodeQNT(&qnt, testPattern(image.Rect(0, 0, 16, 16), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat := encodeTestFLAT(qnt.Bytes(), []testFile{{"cg", qnt.Bytes()}, {"", []byte("text")}})
+	var aff bytes.Buffer
+	err = WrapAFF(&aff, encodeTestAFA(t, []testFile{{"deep.qnt", qnt.Bytes()}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := encodeTestAFA(t, []testFile{
+		{"a.qnt", qnt.Bytes()},
+		{"inner.flat", flat},
+		{"patch.afa", aff.Bytes()},
+		{"broken.flat", []byte("not a flat file")},
+	})
+	arch, err := OpenAFA(bytes.NewReader(outer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		Path  string
+		Depth int
+		Type  ContentType
+	}
+	walkAll := func(maxDepth int) (results []result) {
+		err := Walk(bytes.NewReader(outer), arch, maxDepth, func(e *WalkEntry) error {
+			data, err := io.ReadAll(e.Content)
+			if err != nil {
+				return err
+			}
+			if int64(len(data)) != e.Size {
+				t.Errorf("%s: size %d, read %d", e.Path, e.Size, len(data))
+			}
+			if e.Type == ContentQNT && !bytes.Equal(data, qnt.Bytes()) {
+				t.Errorf("%s: content mismatch", e.Path)
+			}
+			results = append(results, result{e.Path, e.Depth, e.Type})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	got := walkAll(0)
+	want := []result{
+		{"a.qnt", 0, ContentQNT},
+		{"inner.flat", 0, ContentFLAT},
+		{"patch.afa", 0, ContentAFA},
+		{"broken.flat", 0, ContentFLAT},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("depth 0: got %v", got)
+	}
+
+	got = walkAll(2)
+	want = []result{
+		{"a.qnt", 0, ContentQNT},
+		{"inner.flat/thumbnail", 1, ContentQNT},
+		{"inner.flat/cg", 1, ContentQNT},
+		{"inner.flat/001", 1, ContentUnknown},
+		{"patch.afa/deep.qnt", 1, ContentQNT},
+		{"broken.flat", 0, ContentFLAT},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("depth 2: got %v", got)
+	}
+}
+
+func TestWalkResolver(t *testing.T) {
+	r := image.Rect(0, 0, 32, 32)
+	base := testPattern(r, 0)
+	variant := testPattern(r, 0)
+	variant.SetNRGBA(3, 3, color.NRGBA{0xff, 0, 0, 0xff}) // other blocks are taken from the base
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		if err := EncodeQNT(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	// the base image in the FLAT overrides the one with the same name in the outer archive
+	flat := encodeTestFLAT(nil, []testFile{{"cg", encode(base)}, {"face", encodeTestDCF(t, base, variant, "cg.qnt")}})
+	outer := encodeTestAFA(t, []testFile{{"cg.qnt", encode(testPattern(r, 2))}, {"inner.flat", flat}})
+	arch, err := OpenAFA(bytes.NewReader(outer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	err = Walk(bytes.NewReader(outer), arch, 1, func(e *WalkEntry) error {
+		if e.Type != ContentDCF {
+			return nil
+		}
+		found = true
+		if len(e.Resolver) != 2 {
+			t.Errorf("unexpected resolver depth %d", len(e.Resolver))
+		}
+		v, _, err := DecodeContent(e.Type, e.Content, &DecodeContext{Name: e.Path, Resolver: e.Resolver})
+		if err != nil {
+			return err
+		}
+		if img, ok := v.(image.Image); !ok || !sameImage(img, variant) {
+			t.Errorf("%s is not composed on the base image in the same container", e.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Errorf("DCF not found")
+	}
+
+	// keys of archive entries do not depend on the address of the archive
+	r1 := NewArchiveResolver(arch, bytes.NewReader(outer))
+	r2 := NewArchiveResolver(arch, bytes.NewReader(outer))
+	k1, err := r1.ImageKey("cg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := r1.ImageKey("CG.QNT"); k != k1 {
+		t.Errorf("key of the same entry changed: %q, %q", k1, k)
+	}
+	if k2, _ := r2.ImageKey("cg"); k2 == k1 {
+		t.Errorf("resolvers share the key %q", k1)
+	}
+
+	if ContentTypeByExt(".alk") == ContentALD {
+		t.Errorf("ALK is not an ALD archive")
+	}
+}